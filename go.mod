@@ -2,4 +2,7 @@ module minecraft-exchange
 
 go 1.22.3
 
-require github.com/mattn/go-sqlite3 v1.14.32
+require (
+	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/crypto v0.31.0
+)
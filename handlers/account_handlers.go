@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 渲染账号相关页面，附带错误或成功信息
+func renderAccountPage(w http.ResponseWriter, page string, data map[string]interface{}) {
+	tmpl, err := template.ParseFiles(page)
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, data)
+}
+
+// 首次运行设置页面处理器，用于创建第一个管理员账号
+func SetupHandler(w http.ResponseWriter, r *http.Request) {
+	// 已有管理员账号时不允许再次设置
+	count, err := models.CountAdmins()
+	if err != nil {
+		log.Println("查询管理员账号失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	if r.Method != "POST" {
+		renderAccountPage(w, "templates/setup.html", nil)
+		return
+	}
+
+	// 获取表单数据
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	// 验证表单数据
+	errorMessage := ""
+	if username == "" || password == "" {
+		errorMessage = "用户名和密码不能为空"
+	} else if password != confirmPassword {
+		errorMessage = "两次输入的密码不一致"
+	} else if len(password) < models.MinPasswordLength {
+		errorMessage = models.ErrPasswordTooShort.Error()
+	}
+	if errorMessage != "" {
+		renderAccountPage(w, "templates/setup.html", map[string]interface{}{
+			"Error":    errorMessage,
+			"Username": username,
+		})
+		return
+	}
+
+	// 创建第一个管理员账号
+	adminID, err := models.CreateAdmin(username, password)
+	if err != nil {
+		log.Println("创建管理员账号失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 直接登录
+	err = startAdminSession(w, r, int(adminID))
+	if err != nil {
+		log.Println("创建会话失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+// 修改密码页面处理器
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	session, err := currentSession(r)
+	if err != nil {
+		log.Println("查询当前会话失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != "POST" {
+		renderAccountPage(w, "templates/change_password.html", map[string]interface{}{
+			"Username": session.AdminUsername,
+		})
+		return
+	}
+
+	// 获取表单数据
+	oldPassword := r.FormValue("old_password")
+	newPassword := r.FormValue("new_password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	errorMessage := ""
+	if newPassword != confirmPassword {
+		errorMessage = "两次输入的新密码不一致"
+	} else {
+		err = models.ChangeAdminPassword(session.AdminID, oldPassword, newPassword)
+		if err == models.ErrInvalidCredentials {
+			errorMessage = "当前密码错误"
+		} else if err == models.ErrPasswordTooShort {
+			errorMessage = err.Error()
+		} else if err != nil {
+			log.Println("修改密码失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if errorMessage != "" {
+		renderAccountPage(w, "templates/change_password.html", map[string]interface{}{
+			"Username": session.AdminUsername,
+			"Error":    errorMessage,
+		})
+		return
+	}
+
+	// 修改密码后让其他设备上的会话失效
+	err = models.DeleteOtherSessions(session.AdminID, session.ID)
+	if err != nil {
+		log.Println("清除其他会话失败:", err)
+	}
+
+	renderAccountPage(w, "templates/change_password.html", map[string]interface{}{
+		"Username": session.AdminUsername,
+		"Success":  "密码修改成功",
+	})
+}
+
+// 创建家长账号处理器
+func CreateAdminHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取表单数据
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	if username == "" || password == "" {
+		http.Error(w, "用户名和密码不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 创建账号
+	_, err := models.CreateAdmin(username, password)
+	if err == models.ErrPasswordTooShort {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("创建管理员账号失败:", err)
+		http.Error(w, "创建账号失败，用户名可能已存在", http.StatusBadRequest)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "家长账号创建成功",
+			Refresh: true,
+		})
+	} else {
+		// 重定向到管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 删除家长账号处理器
+func DeleteAdminHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取账号ID
+	adminIDStr := r.FormValue("admin_id")
+	if adminIDStr == "" {
+		http.Error(w, "账号ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 转换账号ID为整数
+	adminID, err := strconv.Atoi(adminIDStr)
+	if err != nil {
+		log.Println("账号ID格式错误:", err)
+		http.Error(w, "账号ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	// 不允许删除自己的账号
+	session, err := currentSession(r)
+	if err == nil && session.AdminID == adminID {
+		http.Error(w, "不能删除当前登录的账号", http.StatusBadRequest)
+		return
+	}
+
+	// 删除账号
+	err = models.DeleteAdmin(adminID)
+	if err == models.ErrLastAdmin {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("删除管理员账号失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "家长账号删除成功",
+			Refresh: true,
+		})
+	} else {
+		// 重定向到管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
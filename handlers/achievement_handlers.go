@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 检查玩家新解锁的成就并发放一次性奖励，需要在确认任务或兑换物品的事务中调用
+// 返回附加在提示消息后面的成就说明
+func unlockAchievements(tx models.Executor, playerID int) (string, error) {
+	unlocked, err := models.UnlockAchievements(tx, playerID)
+	if err != nil {
+		return "", err
+	}
+
+	message := ""
+	for _, achievement := range unlocked {
+		message += fmt.Sprintf("，解锁成就「%s」", achievement.Title)
+		if achievement.Reward > 0 {
+			_, err := models.AddEmeraldTransaction(tx, playerID, achievement.Reward, models.ReasonAchievement, nil, nil, models.ActorSystem, "解锁成就："+achievement.Title)
+			if err != nil {
+				return "", err
+			}
+			message += fmt.Sprintf("，奖励 %d 绿宝石", achievement.Reward)
+		}
+	}
+	return message, nil
+}
+
+// 成就管理页面处理器
+func AchievementsHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/achievements.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	achievements, err := models.GetAllAchievements()
+	if err != nil {
+		log.Println("查询成就失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	unlocks, err := models.GetAllAchievementUnlocks()
+	if err != nil {
+		log.Println("查询成就解锁记录失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Achievements": achievements,
+		"Unlocks":      unlocks,
+		"Rules":        models.AchievementRules,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 创建成就处理器
+func CreateAchievementHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	achievement := models.Achievement{
+		Title:       strings.TrimSpace(r.FormValue("title")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		Icon:        strings.TrimSpace(r.FormValue("icon")),
+		Rule:        r.FormValue("rule"),
+	}
+	if achievement.Title == "" {
+		http.Error(w, "成就名称不能为空", http.StatusBadRequest)
+		return
+	}
+	if !models.IsAchievementRule(achievement.Rule) {
+		http.Error(w, "解锁规则无效", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	achievement.Threshold, err = strconv.Atoi(r.FormValue("threshold"))
+	if err != nil || achievement.Threshold <= 0 {
+		http.Error(w, "解锁门槛必须是正整数", http.StatusBadRequest)
+		return
+	}
+	achievement.Reward, err = achievementRewardFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = models.CreateAchievement(achievement)
+	if err != nil {
+		log.Println("创建成就失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "成就创建成功",
+			Refresh: true,
+		})
+	} else {
+		// 创建成功后重定向回成就页面
+		http.Redirect(w, r, "/achievements", http.StatusFound)
+	}
+}
+
+// 修改成就奖励处理器
+func UpdateAchievementRewardHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	achievementID, err := strconv.Atoi(r.FormValue("achievement_id"))
+	if err != nil {
+		http.Error(w, "成就ID格式错误", http.StatusBadRequest)
+		return
+	}
+	reward, err := achievementRewardFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = models.UpdateAchievementReward(achievementID, reward)
+	if err != nil {
+		log.Println("修改成就奖励失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "成就奖励已修改",
+			Refresh: true,
+		})
+	} else {
+		// 修改成功后重定向回成就页面
+		http.Redirect(w, r, "/achievements", http.StatusFound)
+	}
+}
+
+// 删除成就处理器，内置成就不能删除
+func DeleteAchievementHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	achievementID, err := strconv.Atoi(r.FormValue("achievement_id"))
+	if err != nil {
+		http.Error(w, "成就ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	err = models.DeleteAchievement(achievementID)
+	if errors.Is(err, models.ErrBuiltinAchievement) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "成就不存在", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("删除成就失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "成就已删除",
+			Refresh: true,
+		})
+	} else {
+		// 删除成功后重定向回成就页面
+		http.Redirect(w, r, "/achievements", http.StatusFound)
+	}
+}
+
+// 读取表单中的成就奖励，未填写时为0
+func achievementRewardFromForm(r *http.Request) (int, error) {
+	value := r.FormValue("reward")
+	if value == "" {
+		return 0, nil
+	}
+	reward, err := strconv.Atoi(value)
+	if err != nil || reward < 0 {
+		return 0, errors.New("成就奖励必须是非负整数")
+	}
+	return reward, nil
+}
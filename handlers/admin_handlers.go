@@ -7,20 +7,14 @@ import (
 	"time"
 
 	"minecraft-exchange/models"
+	"minecraft-exchange/scheduler"
 	"minecraft-exchange/utils"
 )
 
 // 获取管理员数据的JSON接口
 func GetAdminDataHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，返回JSON响应
-		utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
-			Success: false,
-			Message: "未登录，请先登录",
-			Redirect: "/login",
-		})
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -35,6 +29,17 @@ func GetAdminDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 查询任务凭证图片
+	taskEvidence, err := models.GetTaskEvidenceByTask()
+	if err != nil {
+		log.Println("查询任务凭证失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
 	// 查询所有任务模板
 	taskTemplates, err := models.GetAllTaskTemplates()
 	if err != nil {
@@ -46,6 +51,57 @@ func GetAdminDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 查询已归档的任务模板
+	archivedTemplates, err := models.GetArchivedTaskTemplates()
+	if err != nil {
+		log.Println("查询已归档任务模板失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	// 查询任务模板和任务指派的玩家
+	templateAssignees, err := models.GetAllTemplateAssignees()
+	if err != nil {
+		log.Println("查询模板指派玩家失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+	taskAssignees, err := models.GetAllTaskAssignees()
+	if err != nil {
+		log.Println("查询任务指派玩家失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	// 查询任务模板和任务的清单
+	templateChecklists, err := models.GetAllTemplateChecklists()
+	if err != nil {
+		log.Println("查询模板清单失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+	taskChecklists, err := models.GetAllTaskChecklists()
+	if err != nil {
+		log.Println("查询任务清单失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
 	// 查询所有兑换记录
 	exchangeRecords, err := models.GetAllExchangeRecords()
 	if err != nil {
@@ -68,14 +124,57 @@ func GetAdminDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 查询所有玩家
+	players, err := models.GetAllPlayers()
+	if err != nil {
+		log.Println("查询玩家失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	// 查询所有有效会话
+	sessions, err := models.GetActiveSessions()
+	if err != nil {
+		log.Println("查询会话失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	// 查询所有家长账号
+	admins, err := models.GetAllAdmins()
+	if err != nil {
+		log.Println("查询管理员账号失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
 	// 返回JSON响应
 	utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"Tasks":           tasks,
-			"TaskTemplates":   taskTemplates,
-			"ExchangeRecords": exchangeRecords,
-			"Items":           items,
+			"Tasks":              tasks,
+			"TaskEvidence":       taskEvidence,
+			"TaskTemplates":      taskTemplates,
+			"ArchivedTemplates":  archivedTemplates,
+			"TemplateAssignees":  templateAssignees,
+			"TaskAssignees":      taskAssignees,
+			"TemplateChecklists": templateChecklists,
+			"TaskChecklists":     taskChecklists,
+			"ExchangeRecords":    exchangeRecords,
+			"Items":              items,
+			"Players":            players,
+			"Sessions":           sessions,
+			"CurrentSession":     currentSessionID(r),
+			"Admins":             admins,
 		},
 	})
 }
@@ -89,10 +188,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，重定向到登录页面
-		http.Redirect(w, r, "/login", http.StatusFound)
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -110,6 +206,14 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 查询任务凭证图片
+	taskEvidence, err := models.GetTaskEvidenceByTask()
+	if err != nil {
+		log.Println("查询任务凭证失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
 	// 查询所有任务模板
 	taskTemplates, err := models.GetAllTaskTemplates()
 	if err != nil {
@@ -118,6 +222,42 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 查询已归档的任务模板
+	archivedTemplates, err := models.GetArchivedTaskTemplates()
+	if err != nil {
+		log.Println("查询已归档任务模板失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询任务模板和任务指派的玩家
+	templateAssignees, err := models.GetAllTemplateAssignees()
+	if err != nil {
+		log.Println("查询模板指派玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	taskAssignees, err := models.GetAllTaskAssignees()
+	if err != nil {
+		log.Println("查询任务指派玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询任务模板和任务的清单
+	templateChecklists, err := models.GetAllTemplateChecklists()
+	if err != nil {
+		log.Println("查询模板清单失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	taskChecklists, err := models.GetAllTaskChecklists()
+	if err != nil {
+		log.Println("查询任务清单失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
 	// 查询所有兑换记录
 	exchangeRecords, err := models.GetAllExchangeRecords()
 	if err != nil {
@@ -134,12 +274,57 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 查询所有玩家
+	players, err := models.GetAllPlayers()
+	if err != nil {
+		log.Println("查询玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询所有有效会话
+	sessions, err := models.GetActiveSessions()
+	if err != nil {
+		log.Println("查询会话失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询所有家长账号
+	admins, err := models.GetAllAdmins()
+	if err != nil {
+		log.Println("查询管理员账号失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 当前登录的会话
+	session, err := currentSession(r)
+	if err != nil {
+		log.Println("查询当前会话失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
 	// 准备传递给模板的数据
 	data := map[string]interface{}{
-		"Tasks":           tasks,
-		"TaskTemplates":   taskTemplates,
-		"ExchangeRecords": exchangeRecords,
-		"Items":           items,
+		"Tasks":              tasks,
+		"TaskEvidence":       taskEvidence,
+		"TaskTemplates":      taskTemplates,
+		"ArchivedTemplates":  archivedTemplates,
+		"TemplateAssignees":  templateAssignees,
+		"TaskAssignees":      taskAssignees,
+		"TemplateChecklists": templateChecklists,
+		"TaskChecklists":     taskChecklists,
+		"ExchangeRecords":    exchangeRecords,
+		"Items":              items,
+		"Players":            players,
+		"Sessions":           sessions,
+		"CurrentSession":     session.ID,
+		"CurrentAdmin":       session.AdminID,
+		"AdminUsername":      session.AdminUsername,
+		"Admins":             admins,
+		"Now":                time.Now(),
 	}
 
 	// 执行模板渲染
@@ -149,10 +334,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 // 测试创建任务页面处理器
 func TestCreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，重定向到登录页面
-		http.Redirect(w, r, "/login", http.StatusFound)
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -168,30 +350,38 @@ func TestCreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 
 // 登录页面处理器
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	// 还没有任何管理员账号时，先进入初始设置页面
+	count, err := models.CountAdmins()
+	if err != nil {
+		log.Println("查询管理员账号失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		http.Redirect(w, r, "/setup", http.StatusFound)
+		return
+	}
+
 	if r.Method == "POST" {
 		// 处理登录请求
+		username := r.FormValue("username")
 		password := r.FormValue("password")
-		if password == "admin123" {
-			// 登录成功，生成会话token
-			sessionToken := utils.GenerateSecureToken(32)
-
-			// 设置Cookie，有效期为1小时
-			expiration := time.Now().Add(1 * time.Hour)
-			cookie := http.Cookie{
-				Name:     "session_token",
-				Value:    sessionToken,
-				Path:     "/",
-				Expires:  expiration,
-				HttpOnly: true,
+		admin, err := models.AuthenticateAdmin(username, password)
+		if err == nil {
+			// 登录成功，创建会话并设置Cookie，有效期为1小时
+			err = startAdminSession(w, r, admin.ID)
+			if err != nil {
+				log.Println("创建会话失败:", err)
+				http.Error(w, "服务器错误", http.StatusInternalServerError)
+				return
 			}
-			http.SetCookie(w, &cookie)
 
 			// 检查是否为AJAX请求
 			if utils.IsAJAXRequest(r) {
 				// 返回JSON响应
 				utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
-					Success: true,
-					Message: "登录成功",
+					Success:  true,
+					Message:  "登录成功",
 					Redirect: "/admin",
 				})
 			} else {
@@ -199,6 +389,10 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/admin", http.StatusFound)
 			}
 			return
+		} else if err != models.ErrInvalidCredentials {
+			log.Println("验证管理员账号失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
 		} else {
 			// 登录失败
 			// 检查是否为AJAX请求
@@ -206,13 +400,14 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 				// 返回JSON响应
 				utils.SendJSONResponse(w, http.StatusBadRequest, utils.JSONResponse{
 					Success: false,
-					Message: "密码错误",
+					Message: "用户名或密码错误",
 				})
 			} else {
 				// 显示错误信息
 				tmpl, _ := template.ParseFiles("templates/login.html")
 				data := map[string]interface{}{
-					"Error": "密码错误",
+					"Error":    "用户名或密码错误",
+					"Username": username,
 				}
 				tmpl.Execute(w, data)
 			}
@@ -233,23 +428,17 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 // 手动刷新日常任务处理器
 func RefreshDailyTasksHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，检查是否为AJAX请求
-		if utils.IsAJAXRequest(r) {
-			utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
-				Success: false,
-				Message: "未登录，请先登录",
-				Redirect: "/login",
-			})
-		} else {
-			http.Redirect(w, r, "/login", http.StatusFound)
-		}
+	if !requireAdmin(w, r) {
 		return
 	}
 
 	// 调用刷新日常任务函数
-	utils.RefreshDailyTasks()
+	err := scheduler.Default.RefreshDailyTasks()
+	if err != nil {
+		log.Println("刷新日常任务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
 
 	// 检查是否为AJAX请求
 	if utils.IsAJAXRequest(r) {
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecraft-exchange/ics"
+	"minecraft-exchange/models"
+	"minecraft-exchange/recurrence"
+	"minecraft-exchange/utils"
+)
+
+// 导入的日历文件大小限制
+const maxICSFileSize = 1 << 20
+
+// 假期管理页面处理器
+func BlackoutDatesHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/blackout_dates.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	blackouts, err := models.GetAllBlackoutDates()
+	if err != nil {
+		log.Println("查询假期失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 假期只影响日常任务
+	taskTemplates, err := models.GetAllTaskTemplatesByType("daily")
+	if err != nil {
+		log.Println("查询任务模板失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	players, err := models.GetAllPlayers()
+	if err != nil {
+		log.Println("查询玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Blackouts":     blackouts,
+		"TaskTemplates": taskTemplates,
+		"Players":       players,
+		"Today":         time.Now().Format(recurrence.DateLayout),
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 添加假期处理器
+// 结束日期为空时只添加开始日期这一天，模板和玩家为空时对所有模板和玩家生效
+func CreateBlackoutDateHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startDate := r.FormValue("start_date")
+	endDate := r.FormValue("end_date")
+	if endDate == "" {
+		endDate = startDate
+	}
+	start, err := time.Parse(recurrence.DateLayout, startDate)
+	if err != nil {
+		http.Error(w, "开始日期格式错误", http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse(recurrence.DateLayout, endDate)
+	if err != nil {
+		http.Error(w, "结束日期格式错误", http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		http.Error(w, "结束日期不能早于开始日期", http.StatusBadRequest)
+		return
+	}
+
+	templateID, playerID, err := blackoutScopeFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = models.CreateBlackoutDate(models.DB, models.BlackoutDate{
+		StartDate:  startDate,
+		EndDate:    endDate,
+		TemplateID: templateID,
+		PlayerID:   playerID,
+		Reason:     r.FormValue("reason"),
+		Source:     "manual",
+	})
+	if err != nil {
+		log.Println("添加假期失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "假期添加成功",
+			Refresh: true,
+		})
+	} else {
+		// 添加成功后重定向回假期页面
+		http.Redirect(w, r, "/blackout_dates", http.StatusFound)
+	}
+}
+
+// 删除假期处理器
+func DeleteBlackoutDateHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	blackoutID, err := strconv.Atoi(r.FormValue("blackout_id"))
+	if err != nil {
+		http.Error(w, "假期ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	err = models.DeleteBlackoutDate(blackoutID)
+	if err != nil {
+		log.Println("删除假期失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "假期已删除",
+			Refresh: true,
+		})
+	} else {
+		// 删除成功后重定向回假期页面
+		http.Redirect(w, r, "/blackout_dates", http.StatusFound)
+	}
+}
+
+// 从.ics日历文件导入假期处理器
+// 每个日历事件导入为一个假期，重复导入同一个文件不会产生重复的假期
+func ImportBlackoutDatesHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxICSFileSize+1<<16)
+	err := r.ParseMultipartForm(maxICSFileSize)
+	if err != nil {
+		http.Error(w, "日历文件过大或格式错误", http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("ics_file")
+	if err != nil {
+		http.Error(w, "请选择要导入的日历文件", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	events, err := ics.Parse(file, time.Local)
+	if err != nil {
+		http.Error(w, "日历文件解析失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	templateID, playerID, err := blackoutScopeFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for _, event := range events {
+		startDate := event.Start.Format(ics.DateLayout)
+		endDate := event.End.Format(ics.DateLayout)
+		created, err := models.CreateBlackoutDate(tx, models.BlackoutDate{
+			StartDate:  startDate,
+			EndDate:    endDate,
+			TemplateID: templateID,
+			PlayerID:   playerID,
+			Reason:     event.Summary,
+			Source:     "ics",
+			SourceUID:  blackoutSourceUID(event, templateID, playerID),
+		})
+		if err != nil {
+			log.Println("导入假期失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		if created {
+			imported++
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("导入了%d个假期，跳过%d个已导入的假期", imported, len(events)-imported)
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: message,
+			Refresh: true,
+		})
+	} else {
+		log.Println(message)
+		// 导入成功后重定向回假期页面
+		http.Redirect(w, r, "/blackout_dates", http.StatusFound)
+	}
+}
+
+// 读取假期适用的模板和玩家，为空表示全部
+func blackoutScopeFromForm(r *http.Request) (*int, *int, error) {
+	var templateID, playerID *int
+	if value := r.FormValue("template_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, errors.New("任务模板ID格式错误")
+		}
+		templateID = &id
+	}
+	if value := r.FormValue("player_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, errors.New("玩家ID格式错误")
+		}
+		playerID = &id
+	}
+	return templateID, playerID, nil
+}
+
+// 生成导入假期的唯一标识
+// 同一事件可以分别导入到不同的模板或玩家，因此标识中包含适用范围
+func blackoutSourceUID(event ics.Event, templateID *int, playerID *int) string {
+	uid := event.UID
+	if uid == "" {
+		uid = event.Start.Format(ics.DateLayout) + "/" + event.End.Format(ics.DateLayout) + "/" + event.Summary
+	}
+	if templateID != nil {
+		uid += fmt.Sprintf("#template=%d", *templateID)
+	}
+	if playerID != nil {
+		uid += fmt.Sprintf("#player=%d", *playerID)
+	}
+	return uid
+}
+
+// 判断日常任务是否处于玩家的假期中
+func isTaskInPlayerBlackout(task models.Task, blackouts []models.BlackoutDate) bool {
+	if task.Type != "daily" || len(task.StartTime) < len(recurrence.DateLayout) {
+		return false
+	}
+	day := task.StartTime[:len(recurrence.DateLayout)]
+	for _, b := range blackouts {
+		if b.Covers(task.TemplateID, day) {
+			return true
+		}
+	}
+	return false
+}
+
+// 去掉处于玩家假期中的日常任务
+func filterPlayerBlackoutTasks(tasks []models.Task, playerID int) ([]models.Task, error) {
+	blackouts, err := models.GetPlayerBlackoutDates(playerID)
+	if err != nil || len(blackouts) == 0 {
+		return tasks, err
+	}
+
+	var filtered []models.Task
+	for _, task := range tasks {
+		if !isTaskInPlayerBlackout(task, blackouts) {
+			filtered = append(filtered, task)
+		}
+	}
+	return filtered, nil
+}
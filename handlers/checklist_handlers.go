@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 勾选任务清单步骤处理器
+// checked为1时勾选，否则取消勾选，只能修改自己已领取、尚未提交的任务
+func CheckChecklistItemHandler(w http.ResponseWriter, r *http.Request) {
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	taskID, err := strconv.Atoi(r.FormValue("task_id"))
+	if err != nil {
+		http.Error(w, "任务ID格式错误", http.StatusBadRequest)
+		return
+	}
+	itemID, err := strconv.Atoi(r.FormValue("item_id"))
+	if err != nil {
+		http.Error(w, "清单步骤ID格式错误", http.StatusBadRequest)
+		return
+	}
+	checked := r.FormValue("checked") == "1"
+
+	// 任务状态在更新时一起检查，避免任务提交或被重新领取后还能勾选
+	err = models.SetChecklistItemChecked(taskID, itemID, player.ID, checked)
+	if errors.Is(err, models.ErrChecklistItemNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrChecklistLocked) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("更新清单步骤失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	message := "已取消勾选"
+	if checked {
+		message = "已勾选"
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: message,
+			Refresh: true,
+		})
+	} else {
+		// 勾选后重定向回任务页面
+		http.Redirect(w, r, "/tasks", http.StatusFound)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"minecraft-exchange/models"
+)
+
+// 并发请求的数量
+const parallelRequests = 20
+
+// 使用临时数据库初始化models.DB，测试结束后关闭
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	t.Setenv("UPLOAD_PATH", filepath.Join(dir, "uploads"))
+	t.Setenv("ADMIN_USERNAME", "")
+	t.Setenv("ADMIN_PASSWORD", "")
+	models.InitDB()
+	t.Cleanup(func() { models.DB.Close() })
+}
+
+// 创建管理员账号和会话，返回会话Cookie
+func adminCookie(t *testing.T) *http.Cookie {
+	t.Helper()
+	adminID, err := models.CreateAdmin("admin", "secret123")
+	if err != nil {
+		t.Fatal("创建管理员失败:", err)
+	}
+	token := "test-session-token"
+	err = models.CreateSession(token, int(adminID), time.Now().Add(time.Hour), "", "")
+	if err != nil {
+		t.Fatal("创建会话失败:", err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: token}
+}
+
+// 查询一个整数
+func queryInt(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var value int
+	err := models.DB.QueryRow(query, args...).Scan(&value)
+	if err != nil {
+		t.Fatalf("查询 %q 失败: %v", query, err)
+	}
+	return value
+}
+
+// 发送一个AJAX表单请求
+func postForm(handler http.HandlerFunc, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// 并发发送n个相同的AJAX表单请求，返回所有响应
+func postParallel(handler http.HandlerFunc, n int, form url.Values, cookie *http.Cookie) []*httptest.ResponseRecorder {
+	recorders := make([]*httptest.ResponseRecorder, n)
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := range recorders {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Requested-With", "XMLHttpRequest")
+			req.AddCookie(cookie)
+			recorders[i] = httptest.NewRecorder()
+			start.Wait()
+			handler(recorders[i], req)
+		}(i)
+	}
+	start.Done()
+	done.Wait()
+	return recorders
+}
+
+// 统计响应体中包含message的响应数量
+func countResponses(recorders []*httptest.ResponseRecorder, message string) int {
+	count := 0
+	for _, rec := range recorders {
+		if strings.Contains(rec.Body.String(), message) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestParallelExchange(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	_, err := models.AddEmeraldTransaction(models.DB, playerID, 100, models.ReasonAdjustment, nil, nil, "test", "测试")
+	if err != nil {
+		t.Fatal("增加绿宝石失败:", err)
+	}
+	err = models.CreateItem(models.Item{Name: "限量玩具", Cost: 5, Stock: 1})
+	if err != nil {
+		t.Fatal("创建物品失败:", err)
+	}
+	itemID := queryInt(t, "SELECT MAX(id) FROM items")
+
+	emeraldsBefore := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID)
+	transactionsBefore := queryInt(t, "SELECT COUNT(*) FROM emerald_transactions WHERE player_id = ?", playerID)
+
+	cookie := &http.Cookie{Name: playerCookieName, Value: strconv.Itoa(playerID)}
+	recorders := postParallel(ExchangeHandler, parallelRequests, url.Values{"item_id": {strconv.Itoa(itemID)}}, cookie)
+
+	if got := countResponses(recorders, "物品兑换成功"); got != 1 {
+		t.Errorf("成功兑换 %d 次，期望 1 次", got)
+	}
+	if got := queryInt(t, "SELECT stock FROM items WHERE id = ?", itemID); got != 0 {
+		t.Errorf("库存为 %d，期望 0", got)
+	}
+	if got := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID); got != emeraldsBefore-5 {
+		t.Errorf("绿宝石为 %d，期望 %d", got, emeraldsBefore-5)
+	}
+	if got := queryInt(t, "SELECT COUNT(*) FROM emerald_transactions WHERE player_id = ?", playerID); got != transactionsBefore+1 {
+		t.Errorf("流水为 %d 条，期望 %d 条", got, transactionsBefore+1)
+	}
+	if got := queryInt(t, "SELECT COUNT(*) FROM exchange_records WHERE item_id = ?", itemID); got != 1 {
+		t.Errorf("兑换记录为 %d 条，期望 1 条", got)
+	}
+}
+
+func TestParallelVerifyTask(t *testing.T) {
+	setupTestDB(t)
+	cookie := adminCookie(t)
+
+	// 成就奖励会在确认任务时一起发放，这里只检查任务奖励
+	_, err := models.DB.Exec("UPDATE achievements SET reward = 0")
+	if err != nil {
+		t.Fatal("修改成就奖励失败:", err)
+	}
+
+	const playerID = 1
+	taskID := queryInt(t, "SELECT MIN(id) FROM tasks")
+	reward := queryInt(t, "SELECT reward FROM tasks WHERE id = ?", taskID)
+	_, err = models.DB.Exec("UPDATE tasks SET status = 'completed', player_id = ? WHERE id = ?", playerID, taskID)
+	if err != nil {
+		t.Fatal("修改任务状态失败:", err)
+	}
+
+	emeraldsBefore := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID)
+	transactionsBefore := queryInt(t, "SELECT COUNT(*) FROM emerald_transactions WHERE player_id = ?", playerID)
+
+	recorders := postParallel(VerifyTaskHandler, parallelRequests, url.Values{"task_id": {strconv.Itoa(taskID)}}, cookie)
+
+	if got := countResponses(recorders, "任务验证成功"); got != 1 {
+		t.Errorf("验证成功 %d 次，期望 1 次", got)
+	}
+	if got := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID); got != emeraldsBefore+reward {
+		t.Errorf("绿宝石为 %d，期望 %d", got, emeraldsBefore+reward)
+	}
+	if got := queryInt(t, "SELECT COUNT(*) FROM emerald_transactions WHERE player_id = ?", playerID); got != transactionsBefore+1 {
+		t.Errorf("流水为 %d 条，期望 %d 条", got, transactionsBefore+1)
+	}
+	if got := queryInt(t, "SELECT COUNT(*) FROM task_reviews WHERE task_id = ? AND action = ?", taskID, models.ReviewVerified); got != 1 {
+		t.Errorf("确认记录为 %d 条，期望 1 条", got)
+	}
+}
+
+func TestParallelCompleteTask(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	taskID := queryInt(t, "SELECT MIN(id) FROM tasks WHERE required_minutes = 0")
+	_, err := models.DB.Exec("UPDATE tasks SET status = 'claimed', player_id = ? WHERE id = ?", playerID, taskID)
+	if err != nil {
+		t.Fatal("修改任务状态失败:", err)
+	}
+
+	cookie := &http.Cookie{Name: playerCookieName, Value: strconv.Itoa(playerID)}
+	recorders := postParallel(CompleteTaskHandler, parallelRequests, url.Values{"task_id": {strconv.Itoa(taskID)}}, cookie)
+
+	if got := countResponses(recorders, "任务提交成功"); got != 1 {
+		t.Errorf("提交成功 %d 次，期望 1 次", got)
+	}
+	if got := queryInt(t, "SELECT COUNT(*) FROM task_reviews WHERE task_id = ? AND action = ?", taskID, models.ReviewSubmitted); got != 1 {
+		t.Errorf("提交记录为 %d 条，期望 1 条", got)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 提交任务时最多上传的图片数量
+const maxEvidenceFiles = 5
+
+// 提交任务时上传的图片表单字段名
+const evidenceFormField = "evidence"
+
+// 上传图片数量超过限制
+var errTooManyEvidenceFiles = fmt.Errorf("最多只能上传%d张图片", maxEvidenceFiles)
+
+// 已通过检查、等待保存的凭证图片
+type evidenceUpload struct {
+	data        []byte
+	contentType string
+}
+
+// 限制提交任务请求的大小并解析multipart表单
+func parseEvidenceForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEvidenceFiles*utils.MaxImageSize+1<<20)
+	err := r.ParseMultipartForm(1 << 20)
+	if err == http.ErrNotMultipart {
+		// 没有上传图片的普通表单
+		return r.ParseForm()
+	}
+	return err
+}
+
+// 读取并检查提交任务时上传的图片
+func readEvidenceFiles(r *http.Request) ([]evidenceUpload, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+
+	var headers []*multipart.FileHeader
+	for _, header := range r.MultipartForm.File[evidenceFormField] {
+		// 浏览器在未选择文件时也可能提交一个空文件
+		if header.Size > 0 {
+			headers = append(headers, header)
+		}
+	}
+	if len(headers) > maxEvidenceFiles {
+		return nil, errTooManyEvidenceFiles
+	}
+
+	var uploads []evidenceUpload
+	for _, header := range headers {
+		if header.Size > utils.MaxImageSize {
+			return nil, utils.ErrImageTooLarge
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(file, utils.MaxImageSize+1))
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		contentType, err := utils.ValidateImage(data)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, evidenceUpload{data: data, contentType: contentType})
+	}
+	return uploads, nil
+}
+
+// 判断是否为需要展示给用户的图片检查错误
+func isEvidenceUserError(err error) bool {
+	return errors.Is(err, utils.ErrImageTooLarge) || errors.Is(err, utils.ErrUnsupportedImage) || errors.Is(err, utils.ErrImageDimensions) || errors.Is(err, errTooManyEvidenceFiles)
+}
+
+// 将图片保存到上传目录，并在事务中记录凭证
+// 返回已保存的文件名，事务失败时调用方应删除这些文件
+func saveEvidenceFiles(tx models.Executor, taskID int, uploads []evidenceUpload) ([]string, error) {
+	var saved []string
+	for _, upload := range uploads {
+		fileName, thumbName, err := utils.SaveImage(upload.data, upload.contentType)
+		if err != nil {
+			return saved, err
+		}
+		saved = append(saved, fileName, thumbName)
+
+		err = models.AddTaskEvidence(tx, models.TaskEvidence{
+			TaskID:      taskID,
+			FileName:    fileName,
+			ThumbName:   thumbName,
+			ContentType: upload.contentType,
+			Size:        len(upload.data),
+		})
+		if err != nil {
+			return saved, err
+		}
+	}
+	return saved, nil
+}
+
+// 任务凭证图片处理器
+// 家长可以查看所有图片，玩家只能查看自己任务的图片
+func EvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	evidenceID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "图片ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	evidence, err := models.GetTaskEvidenceByID(evidenceID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 检查访问权限
+	if !isAdminLoggedIn(r) {
+		player, err := getCurrentPlayer(r)
+		if err != nil {
+			http.Error(w, "没有权限查看该图片", http.StatusForbidden)
+			return
+		}
+		task, err := models.GetTaskByID(evidence.TaskID)
+		if err != nil || task.PlayerID == nil || *task.PlayerID != player.ID {
+			http.Error(w, "没有权限查看该图片", http.StatusForbidden)
+			return
+		}
+	}
+
+	fileName := evidence.FileName
+	contentType := evidence.ContentType
+	if r.URL.Query().Get("thumb") == "1" {
+		fileName = evidence.ThumbName
+		contentType = "image/jpeg"
+	}
+
+	// 文件名由服务器生成，这里仍只取文件名部分，避免路径穿越
+	path := filepath.Join(utils.UploadDir(), filepath.Base(fileName))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeFile(w, r, path)
+}
@@ -1,20 +1,26 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"minecraft-exchange/models"
+	"minecraft-exchange/scheduler"
 	"minecraft-exchange/utils"
 )
 
 // 获取商店数据的JSON接口
 func GetShopDataHandler(w http.ResponseWriter, r *http.Request) {
-	// 查询物品列表
-	items, err := models.GetAllItems()
+	// 查询现在可以兑换和即将上架的物品
+	now := time.Now()
+	items, err := models.GetShopItems(now)
 	if err != nil {
 		log.Println("查询物品失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
@@ -23,11 +29,26 @@ func GetShopDataHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	upcomingItems, err := models.GetUpcomingItems(now)
+	if err != nil {
+		log.Println("查询即将上架物品失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
 
-	// 获取第一个玩家ID
-	playerID, err := models.GetFirstPlayerID()
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	// 获取玩家等级，用于判断物品是否已解锁
+	level, err := models.GetPlayerLevel(player)
 	if err != nil {
-		log.Println("获取玩家ID失败:", err)
+		log.Println("查询玩家等级失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
 			Success: false,
 			Message: "服务器错误",
@@ -35,10 +56,19 @@ func GetShopDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查询玩家信息
-	player, err := models.GetPlayerInfo(playerID)
+	// 获取冻结中的绿宝石和最近的兑换记录
+	held, err := models.GetHeldEmeralds(player.ID)
 	if err != nil {
-		log.Println("查询玩家信息失败:", err)
+		log.Println("查询冻结绿宝石失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+	exchanges, err := models.GetPlayerExchangeRecords(player.ID, 10)
+	if err != nil {
+		log.Println("查询兑换记录失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
 			Success: false,
 			Message: "服务器错误",
@@ -50,9 +80,13 @@ func GetShopDataHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"PlayerName": player.Name,
-			"Emeralds":   player.Emeralds,
-			"Items":      items,
+			"PlayerName":    player.Name,
+			"Emeralds":      player.Emeralds,
+			"Held":          held,
+			"Level":         level,
+			"Items":         items,
+			"UpcomingItems": upcomingItems,
+			"Exchanges":     exchanges,
 		},
 	})
 }
@@ -71,35 +105,66 @@ func ShopHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查询物品列表
-	items, err := models.GetAllItems()
+	// 查询现在可以兑换和即将上架的物品
+	now := time.Now()
+	items, err := models.GetShopItems(now)
 	if err != nil {
 		log.Println("查询物品失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
+	upcomingItems, err := models.GetUpcomingItems(now)
+	if err != nil {
+		log.Println("查询即将上架物品失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
 
-	// 获取第一个玩家ID
-	playerID, err := models.GetFirstPlayerID()
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	// 获取玩家等级，用于判断物品是否已解锁
+	level, err := models.GetPlayerLevel(player)
 	if err != nil {
-		log.Println("获取玩家ID失败:", err)
+		log.Println("查询玩家等级失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 查询玩家信息
-	player, err := models.GetPlayerInfo(playerID)
+	// 获取冻结中的绿宝石和最近的兑换记录
+	held, err := models.GetHeldEmeralds(player.ID)
+	if err != nil {
+		log.Println("查询冻结绿宝石失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	exchanges, err := models.GetPlayerExchangeRecords(player.ID, 10)
+	if err != nil {
+		log.Println("查询兑换记录失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	window, err := cancelWindow()
 	if err != nil {
-		log.Println("查询玩家信息失败:", err)
+		log.Println("查询取消时间设置失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
 	// 准备传递给模板的数据
 	data := map[string]interface{}{
-		"PlayerName": player.Name,
-		"Emeralds":   player.Emeralds,
-		"Items":      items,
+		"PlayerName":    player.Name,
+		"Emeralds":      player.Emeralds,
+		"Held":          held,
+		"Level":         level,
+		"Items":         items,
+		"UpcomingItems": upcomingItems,
+		"Exchanges":     exchanges,
+		"CancelWindow":  window,
+		"Now":           now,
 	}
 
 	// 执行模板渲染
@@ -129,15 +194,14 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取第一个玩家ID
-	playerID, err := models.GetFirstPlayerID()
-	if err != nil {
-		log.Println("获取玩家ID失败:", err)
-		http.Error(w, "服务器错误", http.StatusInternalServerError)
+	// 获取当前玩家
+	currentPlayer, ok := requireCurrentPlayer(w, r)
+	if !ok {
 		return
 	}
+	playerID := currentPlayer.ID
 
-	// 事务处理兑换物品
+	// 事务处理兑换物品，以下所有操作都在同一个事务中执行
 	tx, err := models.DB.Begin()
 	if err != nil {
 		log.Println("开始事务失败:", err)
@@ -147,58 +211,95 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	// 查询物品信息
-	item, err := models.GetItemInfo(itemID)
+	item, err := models.GetItemInfo(tx, itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "物品不存在或已被删除", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Println("查询物品信息失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 检查物品库存
-	if item.Stock <= 0 {
-		http.Error(w, "物品库存不足", http.StatusBadRequest)
+	// 检查物品是否在上下架时间内
+	err = item.CheckAvailable(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 查询玩家信息
-	player, err := models.GetPlayerInfo(playerID)
-	if err != nil {
-		log.Println("查询玩家信息失败:", err)
-		http.Error(w, "服务器错误", http.StatusInternalServerError)
-		return
+	// 检查玩家等级是否达到物品要求
+	if item.MinLevel > 1 {
+		thresholds, err := models.GetLevelThresholds(tx)
+		if err != nil {
+			log.Println("查询等级设置失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		if models.LevelForXP(currentPlayer.XP, thresholds).Level < item.MinLevel {
+			http.Error(w, fmt.Sprintf("需要达到 %d 级才能兑换", item.MinLevel), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// 检查绿宝石是否足够
-	if player.Emeralds < item.Cost {
-		http.Error(w, "绿宝石不足", http.StatusBadRequest)
+	// 减少物品库存，库存为0时不会扣减
+	err = models.DecrementItemStock(tx, itemID)
+	if err == models.ErrOutOfStock {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("减少物品库存失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 扣减玩家绿宝石
-	newEmeralds := player.Emeralds - item.Cost
-	err = models.UpdatePlayerEmeralds(playerID, newEmeralds)
+	// 记录兑换记录，需要家长批准的物品先冻结绿宝石
+	status := models.ExchangeApproved
+	if item.RequiresApproval {
+		status = models.ExchangeRequested
+	}
+	exchangeID, err := models.CreateExchangeRecord(tx, playerID, itemID, item.Cost, status)
 	if err != nil {
-		log.Println("扣减绿宝石失败:", err)
+		log.Println("记录兑换记录失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 减少物品库存
-	newStock := item.Stock - 1
-	err = models.UpdateItemStock(itemID, newStock)
-	if err != nil {
-		log.Println("减少物品库存失败:", err)
+	// 扣减玩家绿宝石，记入流水，余额不足时不会扣减
+	exchangeRecordID := int(exchangeID)
+	_, err = models.AddEmeraldTransaction(tx, playerID, -item.Cost, models.ReasonPurchase, nil, &exchangeRecordID, playerActor(currentPlayer), item.Name)
+	if err == models.ErrInsufficientEmeralds {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("扣减绿宝石失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 记录兑换记录
-	err = models.CreateExchangeRecord(playerID, itemID)
+	// 保护卡不需要家长发放，兑换后立即到账
+	message := "物品兑换成功"
+	if status == models.ExchangeRequested {
+		message = fmt.Sprintf("兑换申请已提交，%d 绿宝石已冻结，等待家长批准", item.Cost)
+	} else if item.Effect == models.ItemEffectStreakFreeze {
+		err = models.GrantStreakFreeze(tx, playerID, exchangeRecordID)
+		if err != nil {
+			log.Println("发放保护卡失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		message = "兑换成功，保护卡已到账"
+	}
+
+	// 检查玩家新解锁的成就
+	achievementMessage, err := unlockAchievements(tx, playerID)
 	if err != nil {
-		log.Println("记录兑换记录失败:", err)
+		log.Println("检查成就失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
+	message += achievementMessage
 
 	// 提交事务
 	err = tx.Commit()
@@ -212,7 +313,7 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 	if utils.IsAJAXRequest(r) {
 		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
 			Success: true,
-			Message: "物品兑换成功",
+			Message: message,
 			Refresh: true,
 		})
 	} else {
@@ -224,18 +325,7 @@ func ExchangeHandler(w http.ResponseWriter, r *http.Request) {
 // 创建物品处理器
 func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，检查是否为AJAX请求
-		if utils.IsAJAXRequest(r) {
-			utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
-				Success: false,
-				Message: "未登录，请先登录",
-				Redirect: "/login",
-			})
-		} else {
-			http.Redirect(w, r, "/login", http.StatusFound)
-		}
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -250,7 +340,6 @@ func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 	description := r.FormValue("description")
 	costStr := r.FormValue("cost")
 	stockStr := r.FormValue("stock")
-	expiryTimeStr := r.FormValue("expiry_time")
 
 	// 验证表单数据
 	if name == "" || costStr == "" || stockStr == "" {
@@ -258,6 +347,18 @@ func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	effect, ok := itemEffectFromForm(r)
+	if !ok {
+		http.Error(w, "物品效果无效", http.StatusBadRequest)
+		return
+	}
+
+	minLevel, err := minLevelFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 转换数值
 	cost, err := strconv.Atoi(costStr)
 	if err != nil || cost <= 0 {
@@ -271,17 +372,25 @@ func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 处理过期时间
-	expiryTime := ""
-	if expiryTimeStr != "" {
-		expiryTime = expiryTimeStr
-	} else {
-		// 如果未设置过期时间，默认设置为30天后
-		expiryTime = time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05")
+	// 处理上下架时间
+	availableFrom, expiryTime, err := itemTimesFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// 创建物品
-	err = models.CreateItem(name, description, cost, stock, expiryTime)
+	err = models.CreateItem(models.Item{
+		Name:             name,
+		Description:      description,
+		Cost:             cost,
+		Stock:            stock,
+		ExpiryTime:       expiryTime,
+		AvailableFrom:    availableFrom,
+		Effect:           effect,
+		MinLevel:         minLevel,
+		RequiresApproval: r.FormValue("requires_approval") == "1",
+	})
 	if err != nil {
 		log.Println("创建物品失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
@@ -304,18 +413,7 @@ func CreateItemHandler(w http.ResponseWriter, r *http.Request) {
 // 删除物品处理器
 func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，检查是否为AJAX请求
-		if utils.IsAJAXRequest(r) {
-			utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
-				Success: false,
-				Message: "未登录，请先登录",
-				Redirect: "/login",
-			})
-		} else {
-			http.Redirect(w, r, "/login", http.StatusFound)
-		}
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -363,6 +461,11 @@ func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
 
 // 兑换奖励处理器
 func ExchangeRewardHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
 	// 确保是POST请求
 	if r.Method != "POST" {
 		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
@@ -384,8 +487,16 @@ func ExchangeRewardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 更新兑换记录状态为已兑换
-	err = models.UpdateExchangeRecordStatus(exchangeID, true)
+	// 更新兑换记录状态为已发放，只有已批准的兑换可以发放
+	err = models.FulfillExchange(models.DB, exchangeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "兑换记录不存在", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrExchangeStatus) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Println("更新兑换记录失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
@@ -396,7 +507,159 @@ func ExchangeRewardHandler(w http.ResponseWriter, r *http.Request) {
 	if utils.IsAJAXRequest(r) {
 		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
 			Success: true,
-			Message: "奖励兑换成功",
+			Message: "奖励已发放",
+			Refresh: true,
+		})
+	} else {
+		// 处理成功后重定向回管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 批准兑换申请处理器，保护卡批准后直接发放
+func ApproveExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	exchangeID, err := strconv.Atoi(r.FormValue("exchange_id"))
+	if err != nil {
+		http.Error(w, "兑换记录ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	record, err := models.ApproveExchange(tx, exchangeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "兑换记录不存在", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrExchangeStatus) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("批准兑换失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	message := "兑换已批准，等待发放"
+	item, err := models.GetItemInfo(tx, record.ItemID)
+	if err == nil && item.Effect == models.ItemEffectStreakFreeze {
+		err = models.GrantStreakFreeze(tx, record.PlayerID, record.ID)
+		if err != nil {
+			log.Println("发放保护卡失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		message = "兑换已批准，保护卡已到账"
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println("查询物品信息失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 批准后才计入兑换次数，检查玩家新解锁的成就
+	achievementMessage, err := unlockAchievements(tx, record.PlayerID)
+	if err != nil {
+		log.Println("检查成就失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	message += achievementMessage
+
+	if err := tx.Commit(); err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: message,
+			Refresh: true,
+		})
+	} else {
+		// 处理成功后重定向回管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 拒绝兑换申请处理器，冻结的绿宝石退回给玩家并恢复库存
+func RejectExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	exchangeID, err := strconv.Atoi(r.FormValue("exchange_id"))
+	if err != nil {
+		http.Error(w, "兑换记录ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+
+	// 在事务开始前读取操作人，避免在事务中访问数据库
+	actor := adminActor(r)
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	record, err := models.RejectExchange(tx, exchangeID, reason, actor)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "兑换记录不存在", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrExchangeStatus) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("拒绝兑换失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: fmt.Sprintf("已拒绝兑换，%d 绿宝石已退回给 %s", record.Cost, record.PlayerName),
 			Refresh: true,
 		})
 	} else {
@@ -405,21 +668,163 @@ func ExchangeRewardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// 退款处理器，家长为已批准或已发放的兑换退回绿宝石并恢复库存
+func RefundExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	exchangeID, err := strconv.Atoi(r.FormValue("exchange_id"))
+	if err != nil {
+		http.Error(w, "兑换记录ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		http.Error(w, "请填写退款原因", http.StatusBadRequest)
+		return
+	}
+
+	// 在事务开始前读取操作人，避免在事务中访问数据库
+	actor := adminActor(r)
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	record, err := models.RefundExchange(tx, exchangeID, reason, actor)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "兑换记录不存在", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrExchangeStatus) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("兑换退款失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: fmt.Sprintf("已退款，%d 绿宝石已退回给 %s", record.Cost, record.PlayerName),
+			Refresh: true,
+		})
+	} else {
+		// 处理成功后重定向回管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 取消兑换处理器，玩家可以在兑换后的一段时间内取消尚未发放的兑换
+func CancelExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	exchangeID, err := strconv.Atoi(r.FormValue("exchange_id"))
+	if err != nil {
+		http.Error(w, "兑换记录ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	// 获取当前玩家
+	currentPlayer, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		reason = "玩家取消"
+	}
+
+	window, err := cancelWindow()
+	if err != nil {
+		log.Println("查询取消时间设置失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	record, err := models.CancelExchange(tx, exchangeID, currentPlayer.ID, window, reason, playerActor(currentPlayer))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "兑换记录不存在", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrExchangeStatus) || errors.Is(err, models.ErrCancelWindow) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("取消兑换失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: fmt.Sprintf("已取消兑换，%d 绿宝石已退回", record.Cost),
+			Refresh: true,
+		})
+	} else {
+		// 重定向到商店页面
+		http.Redirect(w, r, "/shop", http.StatusFound)
+	}
+}
+
+// 玩家兑换后可以自行取消的时间
+func cancelWindow() (time.Duration, error) {
+	minutes, err := models.GetIntSetting(models.DB, models.SettingCancelMinutes)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
 // 更新物品处理器
 func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，检查是否为AJAX请求
-		if utils.IsAJAXRequest(r) {
-			utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
-				Success: false,
-				Message: "未登录，请先登录",
-				Redirect: "/login",
-			})
-		} else {
-			http.Redirect(w, r, "/login", http.StatusFound)
-		}
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -435,7 +840,6 @@ func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
 	description := r.FormValue("description")
 	costStr := r.FormValue("cost")
 	stockStr := r.FormValue("stock")
-	expiryTimeStr := r.FormValue("expiry_time")
 
 	// 验证表单数据
 	if itemIDStr == "" || name == "" || costStr == "" || stockStr == "" {
@@ -443,6 +847,18 @@ func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	effect, ok := itemEffectFromForm(r)
+	if !ok {
+		http.Error(w, "物品效果无效", http.StatusBadRequest)
+		return
+	}
+
+	minLevel, err := minLevelFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 转换数值
 	itemID, err := strconv.Atoi(itemIDStr)
 	if err != nil {
@@ -463,17 +879,26 @@ func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 处理过期时间
-	expiryTime := ""
-	if expiryTimeStr != "" {
-		expiryTime = expiryTimeStr
-	} else {
-		// 如果未设置过期时间，默认设置为30天后
-		expiryTime = time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05")
+	// 处理上下架时间
+	availableFrom, expiryTime, err := itemTimesFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// 更新物品
-	err = models.UpdateItem(itemID, name, description, cost, stock, expiryTime)
+	err = models.UpdateItem(models.Item{
+		ID:               itemID,
+		Name:             name,
+		Description:      description,
+		Cost:             cost,
+		Stock:            stock,
+		ExpiryTime:       expiryTime,
+		AvailableFrom:    availableFrom,
+		Effect:           effect,
+		MinLevel:         minLevel,
+		RequiresApproval: r.FormValue("requires_approval") == "1",
+	})
 	if err != nil {
 		log.Println("更新物品失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
@@ -492,3 +917,57 @@ func UpdateItemHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/admin", http.StatusFound)
 	}
 }
+
+// 读取表单中的物品效果，只接受空值（普通物品）和已知的效果
+func itemEffectFromForm(r *http.Request) (string, bool) {
+	effect := r.FormValue("effect")
+	switch effect {
+	case "", models.ItemEffectStreakFreeze:
+		return effect, true
+	}
+	return "", false
+}
+
+// 读取表单中的上架时间和下架时间，统一转换为"2006-01-02 15:04:05"格式
+// 上架时间为空表示立即上架，下架时间为空时默认为30天后
+func itemTimesFromForm(r *http.Request) (string, string, error) {
+	availableFrom := ""
+	if value := r.FormValue("available_from"); value != "" {
+		parsed, err := scheduler.ParseFormTime(value)
+		if err != nil {
+			return "", "", errors.New("上架时间格式错误")
+		}
+		availableFrom = parsed.Format("2006-01-02 15:04:05")
+	}
+
+	var expiry time.Time
+	if value := r.FormValue("expiry_time"); value != "" {
+		parsed, err := scheduler.ParseFormTime(value)
+		if err != nil {
+			return "", "", errors.New("过期时间格式错误")
+		}
+		expiry = parsed
+	} else {
+		// 如果未设置过期时间，默认设置为30天后
+		expiry = time.Now().Add(30 * 24 * time.Hour)
+	}
+	expiryTime := expiry.Format("2006-01-02 15:04:05")
+
+	if availableFrom != "" && expiryTime <= availableFrom {
+		return "", "", errors.New("过期时间必须晚于上架时间")
+	}
+	return availableFrom, expiryTime, nil
+}
+
+// 读取表单中的兑换等级要求，未填写时为0表示没有限制
+func minLevelFromForm(r *http.Request) (int, error) {
+	value := r.FormValue("min_level")
+	if value == "" {
+		return 0, nil
+	}
+	minLevel, err := strconv.Atoi(value)
+	if err != nil || minLevel < 0 || minLevel > 100 {
+		return 0, errors.New("等级要求必须是0到100之间的整数")
+	}
+	return minLevel, nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"minecraft-exchange/models"
+)
+
+func TestCancelledExchangeRevokesPurchaseAchievement(t *testing.T) {
+	const playerID = 1
+	const achievementReward = 3
+
+	tests := []struct {
+		name  string
+		close func(t *testing.T, exchangeID int)
+	}{
+		{"玩家取消", func(t *testing.T, exchangeID int) {
+			cookie := &http.Cookie{Name: playerCookieName, Value: strconv.Itoa(playerID)}
+			rec := postForm(CancelExchangeHandler, url.Values{"exchange_id": {strconv.Itoa(exchangeID)}}, cookie)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("取消兑换返回 %d: %s", rec.Code, rec.Body.String())
+			}
+		}},
+		{"家长退款", func(t *testing.T, exchangeID int) {
+			rec := postForm(RefundExchangeHandler, url.Values{"exchange_id": {strconv.Itoa(exchangeID)}, "reason": {"测试"}}, adminCookie(t))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("退款返回 %d: %s", rec.Code, rec.Body.String())
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			_, err := models.DB.Exec("UPDATE achievements SET reward = CASE WHEN rule = ? THEN ? ELSE 0 END", models.AchievementPurchases, achievementReward)
+			if err != nil {
+				t.Fatal("修改成就奖励失败:", err)
+			}
+			_, err = models.AddEmeraldTransaction(models.DB, playerID, 100, models.ReasonAdjustment, nil, nil, "test", "测试")
+			if err != nil {
+				t.Fatal("增加绿宝石失败:", err)
+			}
+			err = models.CreateItem(models.Item{Name: "玩具", Cost: 5, Stock: 1})
+			if err != nil {
+				t.Fatal("创建物品失败:", err)
+			}
+			itemID := queryInt(t, "SELECT MAX(id) FROM items")
+			emeraldsBefore := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID)
+
+			cookie := &http.Cookie{Name: playerCookieName, Value: strconv.Itoa(playerID)}
+			rec := postForm(ExchangeHandler, url.Values{"item_id": {strconv.Itoa(itemID)}}, cookie)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("兑换返回 %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID); got != emeraldsBefore-5+achievementReward {
+				t.Fatalf("兑换后绿宝石为 %d，期望 %d", got, emeraldsBefore-5+achievementReward)
+			}
+
+			tt.close(t, queryInt(t, "SELECT MAX(id) FROM exchange_records"))
+
+			if got := queryInt(t, "SELECT emeralds FROM players WHERE id = ?", playerID); got != emeraldsBefore {
+				t.Errorf("绿宝石为 %d，期望退回到 %d", got, emeraldsBefore)
+			}
+			if got := queryInt(t, "SELECT COUNT(*) FROM player_achievements p JOIN achievements a ON a.id = p.achievement_id WHERE p.player_id = ? AND a.rule = ?", playerID, models.AchievementPurchases); got != 0 {
+				t.Errorf("还有 %d 个兑换次数成就，期望撤销", got)
+			}
+			if got := queryInt(t, "SELECT COUNT(*) FROM emerald_transactions WHERE player_id = ? AND reason = ? AND amount = ?", playerID, models.ReasonAchievement, -achievementReward); got != 1 {
+				t.Errorf("收回成就奖励的流水为 %d 条，期望 1 条", got)
+			}
+		})
+	}
+}
+
+func TestExchangeUnknownItem(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	err := models.CreateItem(models.Item{Name: "玩具", Cost: 5, Stock: 1})
+	if err != nil {
+		t.Fatal("创建物品失败:", err)
+	}
+	deletedID := queryInt(t, "SELECT MAX(id) FROM items")
+	if err := models.DeleteItem(deletedID); err != nil {
+		t.Fatal("删除物品失败:", err)
+	}
+
+	cookie := &http.Cookie{Name: playerCookieName, Value: strconv.Itoa(playerID)}
+	for _, itemID := range []int{deletedID, 9999} {
+		rec := postForm(ExchangeHandler, url.Values{"item_id": {strconv.Itoa(itemID)}}, cookie)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("兑换物品 %d 返回 %d，期望 %d", itemID, rec.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/scheduler"
+)
+
+// 定时任务状态，用于页面展示
+type jobStatus struct {
+	models.Job
+	Title string
+}
+
+// 定时任务状态页面处理器，显示每个定时任务的上次运行、下次运行和错误信息
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/jobs.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	jobs, err := models.GetAllJobs()
+	if err != nil {
+		log.Println("查询定时任务状态失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	var statuses []jobStatus
+	for _, job := range jobs {
+		statuses = append(statuses, jobStatus{Job: job, Title: scheduler.JobTitle(job.Name)})
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Jobs": statuses,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
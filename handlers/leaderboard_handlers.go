@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 排行榜页面处理器
+// 通过period和metric参数选择统计周期和排名指标，家长登录后不受显示方式限制
+func LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		GetLeaderboardDataHandler(w, r)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/leaderboard.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := leaderboardData(r, player)
+	if err != nil {
+		log.Println("查询排行榜失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 获取排行榜数据的API处理器
+func GetLeaderboardDataHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := leaderboardData(r, player)
+	if err != nil {
+		log.Println("查询排行榜失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	if data["Mode"] == models.LeaderboardHidden {
+		utils.SendJSONResponse(w, http.StatusForbidden, utils.JSONResponse{
+			Success: false,
+			Message: "排行榜已关闭",
+		})
+		return
+	}
+
+	// 返回JSON响应
+	utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// 按显示方式准备排行榜数据
+// 显示排行榜时返回所有玩家的排名，只显示个人最好成绩时只返回当前玩家的成绩
+func leaderboardData(r *http.Request, player models.Player) (map[string]interface{}, error) {
+	isAdmin := isAdminLoggedIn(r)
+	mode, err := models.GetSetting(models.DB, models.SettingLeaderboardMode)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin || !models.IsLeaderboardMode(mode) {
+		mode = models.LeaderboardVisible
+	}
+
+	period := r.URL.Query().Get("period")
+	if !models.IsLeaderboardPeriod(period) {
+		period = models.PeriodWeek
+	}
+	metric := r.URL.Query().Get("metric")
+	if !models.IsLeaderboardMetric(metric) {
+		metric = models.MetricEmeralds
+	}
+
+	data := map[string]interface{}{
+		"PlayerID":   player.ID,
+		"PlayerName": player.Name,
+		"Emeralds":   player.Emeralds,
+		"Mode":       mode,
+		"Period":     period,
+		"Metric":     metric,
+		"Periods":    models.LeaderboardPeriods,
+		"Metrics":    models.LeaderboardMetrics,
+		"IsAdmin":    isAdmin,
+	}
+
+	now := time.Now()
+	switch mode {
+	case models.LeaderboardVisible:
+		entries, err := models.GetLeaderboard(period, metric, now)
+		if err != nil {
+			return nil, err
+		}
+		data["Entries"] = entries
+	case models.LeaderboardPersonalBest:
+		record, err := models.GetPersonalRecord(player, now)
+		if err != nil {
+			return nil, err
+		}
+		data["Personal"] = record
+	}
+	return data, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"minecraft-exchange/models"
+)
+
+// 绿宝石账本页面处理器
+// 家长可以通过player_id参数查看任意玩家的账本，玩家只能查看自己的账本
+func StatementHandler(w http.ResponseWriter, r *http.Request) {
+	var player models.Player
+	isAdmin := false
+
+	playerIDStr := r.URL.Query().Get("player_id")
+	if playerIDStr != "" {
+		// 查看指定玩家的账本需要管理员权限
+		if !requireAdmin(w, r) {
+			return
+		}
+		isAdmin = true
+
+		playerID, err := strconv.Atoi(playerIDStr)
+		if err != nil {
+			http.Error(w, "玩家ID格式错误", http.StatusBadRequest)
+			return
+		}
+
+		player, err = models.GetPlayerInfo(playerID)
+		if err != nil {
+			log.Println("查询玩家信息失败:", err)
+			http.Error(w, "玩家不存在", http.StatusNotFound)
+			return
+		}
+	} else {
+		var ok bool
+		player, ok = requireCurrentPlayer(w, r)
+		if !ok {
+			return
+		}
+	}
+
+	tmpl, err := template.ParseFiles("templates/statement.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询玩家流水
+	transactions, err := models.GetPlayerEmeraldTransactions(player.ID)
+	if err != nil {
+		log.Println("查询绿宝石流水失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 统计收入和支出
+	totalIn, totalOut := 0, 0
+	for _, t := range transactions {
+		if t.Amount > 0 {
+			totalIn += t.Amount
+		} else {
+			totalOut -= t.Amount
+		}
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"PlayerName":   player.Name,
+		"Emeralds":     player.Emeralds,
+		"Transactions": transactions,
+		"TotalIn":      totalIn,
+		"TotalOut":     totalOut,
+		"Balanced":     totalIn-totalOut == player.Emeralds,
+		"IsAdmin":      isAdmin,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
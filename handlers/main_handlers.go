@@ -16,26 +16,41 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取第一个玩家ID
-	playerID, err := models.GetFirstPlayerID()
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	// 获取玩家的成就
+	achievements, err := models.GetPlayerAchievements(player.ID)
 	if err != nil {
-		log.Println("获取玩家ID失败:", err)
+		log.Println("查询成就失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 查询玩家信息
-	player, err := models.GetPlayerInfo(playerID)
+	// 获取玩家的等级和最近的升级记录
+	level, err := models.GetPlayerLevel(player)
+	if err != nil {
+		log.Println("查询玩家等级失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	levelUps, err := models.GetPlayerLevelUps(player.ID, 5)
 	if err != nil {
-		log.Println("查询玩家信息失败:", err)
+		log.Println("查询升级记录失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
 	// 准备传递给模板的数据
 	data := map[string]interface{}{
-		"PlayerName": player.Name,
-		"Emeralds":   player.Emeralds,
+		"PlayerName":   player.Name,
+		"Emeralds":     player.Emeralds,
+		"Achievements": achievements,
+		"Level":        level,
+		"LevelUps":     levelUps,
 	}
 
 	// 执行模板渲染
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/scheduler"
+	"minecraft-exchange/utils"
+)
+
+// 当前玩家Cookie名称
+const playerCookieName = "player_id"
+
+// 未选择玩家时返回的错误
+var errNoPlayerSelected = errors.New("未选择玩家")
+
+// 从请求的Cookie中解析当前玩家
+func getCurrentPlayer(r *http.Request) (models.Player, error) {
+	cookie, err := r.Cookie(playerCookieName)
+	if err != nil || cookie.Value == "" {
+		return models.Player{}, errNoPlayerSelected
+	}
+
+	playerID, err := strconv.Atoi(cookie.Value)
+	if err != nil {
+		return models.Player{}, errNoPlayerSelected
+	}
+
+	player, err := models.GetPlayerInfo(playerID)
+	if err == sql.ErrNoRows {
+		// Cookie中的玩家已被删除
+		return models.Player{}, errNoPlayerSelected
+	}
+	return player, err
+}
+
+// 流水中记录的玩家操作人
+func playerActor(player models.Player) string {
+	return "player:" + player.Name
+}
+
+// 获取当前玩家，未选择玩家时跳转到玩家选择页面
+// 返回false时已经写入了响应，调用方应直接返回
+func requireCurrentPlayer(w http.ResponseWriter, r *http.Request) (models.Player, bool) {
+	player, err := getCurrentPlayer(r)
+	if err == nil {
+		return player, true
+	}
+
+	if err != errNoPlayerSelected {
+		log.Println("查询玩家信息失败:", err)
+		if utils.IsAJAXRequest(r) {
+			utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+				Success: false,
+				Message: "服务器错误",
+			})
+		} else {
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+		}
+		return player, false
+	}
+
+	// 未选择玩家，检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
+			Success:  false,
+			Message:  "请先选择玩家",
+			Redirect: "/select_player",
+		})
+	} else {
+		http.Redirect(w, r, "/select_player", http.StatusFound)
+	}
+	return player, false
+}
+
+// 选择玩家页面处理器
+func SelectPlayerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		// 获取玩家ID
+		playerIDStr := r.FormValue("player_id")
+		playerID, err := strconv.Atoi(playerIDStr)
+		if err != nil {
+			http.Error(w, "玩家ID格式错误", http.StatusBadRequest)
+			return
+		}
+
+		// 确认玩家存在
+		player, err := models.GetPlayerInfo(playerID)
+		if err != nil {
+			log.Println("查询玩家信息失败:", err)
+			http.Error(w, "玩家不存在", http.StatusBadRequest)
+			return
+		}
+
+		// 设置Cookie，有效期为30天
+		cookie := http.Cookie{
+			Name:     playerCookieName,
+			Value:    strconv.Itoa(player.ID),
+			Path:     "/",
+			Expires:  time.Now().Add(30 * 24 * time.Hour),
+			HttpOnly: true,
+		}
+		http.SetCookie(w, &cookie)
+
+		// 检查是否为AJAX请求
+		if utils.IsAJAXRequest(r) {
+			utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+				Success:  true,
+				Message:  "欢迎你，" + player.Name,
+				Redirect: "/",
+			})
+		} else {
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/players.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询所有玩家
+	players, err := models.GetAllPlayers()
+	if err != nil {
+		log.Println("查询玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 当前已选择的玩家
+	currentPlayerID := 0
+	if player, err := getCurrentPlayer(r); err == nil {
+		currentPlayerID = player.ID
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Players":         players,
+		"CurrentPlayerID": currentPlayerID,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 创建玩家处理器
+func CreatePlayerHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取表单数据
+	name := r.FormValue("name")
+	emeraldsStr := r.FormValue("emeralds")
+
+	if name == "" {
+		http.Error(w, "玩家名称不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 初始绿宝石数量，默认为0
+	emeralds := 0
+	if emeraldsStr != "" {
+		var err error
+		emeralds, err = strconv.Atoi(emeraldsStr)
+		if err != nil || emeralds < 0 {
+			http.Error(w, "初始绿宝石必须是非负整数", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 创建玩家
+	_, err := models.CreatePlayer(name, emeralds, adminActor(r))
+	if err != nil {
+		log.Println("创建玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 新玩家从任务线的第一步开始
+	err = scheduler.Default.UnlockQuestSteps()
+	if err != nil {
+		log.Println("解锁任务线步骤失败:", err)
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "玩家创建成功",
+			Refresh: true,
+		})
+	} else {
+		// 重定向到管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 更新玩家处理器
+func UpdatePlayerHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取表单数据
+	playerIDStr := r.FormValue("player_id")
+	name := r.FormValue("name")
+
+	if playerIDStr == "" || name == "" {
+		http.Error(w, "玩家ID和名称不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 转换玩家ID为整数
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		log.Println("玩家ID格式错误:", err)
+		http.Error(w, "玩家ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	// 更新玩家
+	err = models.UpdatePlayerName(playerID, name)
+	if err != nil {
+		log.Println("更新玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "玩家更新成功",
+			Refresh: true,
+		})
+	} else {
+		// 重定向到管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 删除玩家处理器
+func DeletePlayerHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取玩家ID
+	playerIDStr := r.FormValue("player_id")
+	if playerIDStr == "" {
+		http.Error(w, "玩家ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 转换玩家ID为整数
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		log.Println("玩家ID格式错误:", err)
+		http.Error(w, "玩家ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	// 删除玩家
+	err = models.DeletePlayer(playerID)
+	if err != nil {
+		log.Println("删除玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "玩家删除成功",
+			Refresh: true,
+		})
+	} else {
+		// 重定向到管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
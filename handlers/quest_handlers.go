@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/scheduler"
+	"minecraft-exchange/utils"
+)
+
+// 创建任务线时最多可以选择的步骤数量
+const maxQuestSteps = 8
+
+// 任务线管理页面处理器
+func QuestsHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/quests.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	quests, err := models.GetAllQuests()
+	if err != nil {
+		log.Println("查询任务线失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	progresses, err := models.GetAllQuestProgress()
+	if err != nil {
+		log.Println("查询任务线进度失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 按任务线分组玩家进度
+	progressByQuest := make(map[int][]models.QuestProgress)
+	for _, progress := range progresses {
+		progressByQuest[progress.Quest.ID] = append(progressByQuest[progress.Quest.ID], progress)
+	}
+
+	taskTemplates, err := models.GetAllTaskTemplates()
+	if err != nil {
+		log.Println("查询任务模板失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 已经属于任务线的模板不能再选择
+	usedTemplates := make(map[int]bool)
+	for _, quest := range quests {
+		for _, step := range quest.Steps {
+			usedTemplates[step.TemplateID] = true
+		}
+	}
+	var freeTemplates []models.TaskTemplate
+	for _, taskTemplate := range taskTemplates {
+		if !usedTemplates[taskTemplate.ID] {
+			freeTemplates = append(freeTemplates, taskTemplate)
+		}
+	}
+
+	stepNumbers := make([]int, maxQuestSteps)
+	for i := range stepNumbers {
+		stepNumbers[i] = i + 1
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Quests":          quests,
+		"ProgressByQuest": progressByQuest,
+		"TaskTemplates":   freeTemplates,
+		"StepNumbers":     stepNumbers,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 创建任务线处理器
+// 步骤模板通过step_1到step_N按顺序提交，留空的步骤会被忽略
+func CreateQuestHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		http.Error(w, "任务线名称不能为空", http.StatusBadRequest)
+		return
+	}
+
+	bonus := 0
+	if value := r.FormValue("bonus"); value != "" {
+		var err error
+		bonus, err = strconv.Atoi(value)
+		if err != nil || bonus < 0 {
+			http.Error(w, "完成奖励必须是非负整数", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stepDays, err := strconv.Atoi(r.FormValue("step_days"))
+	if err != nil || stepDays <= 0 {
+		http.Error(w, "每一步的完成期限必须是正整数", http.StatusBadRequest)
+		return
+	}
+
+	var templateIDs []int
+	seen := make(map[int]bool)
+	for i := 1; i <= maxQuestSteps; i++ {
+		value := r.FormValue("step_" + strconv.Itoa(i))
+		if value == "" {
+			continue
+		}
+		templateID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "任务模板ID格式错误", http.StatusBadRequest)
+			return
+		}
+		if seen[templateID] {
+			http.Error(w, "同一个任务模板不能重复出现在任务线中", http.StatusBadRequest)
+			return
+		}
+		seen[templateID] = true
+		templateIDs = append(templateIDs, templateID)
+	}
+	if len(templateIDs) < 2 {
+		http.Error(w, "任务线至少需要两个步骤", http.StatusBadRequest)
+		return
+	}
+
+	_, err = models.CreateQuest(models.Quest{
+		Title:       title,
+		Description: r.FormValue("description"),
+		Bonus:       bonus,
+		StepDays:    stepDays,
+	}, templateIDs)
+	if errors.Is(err, models.ErrTemplateInQuest) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("创建任务线失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 为所有玩家解锁第一步
+	err = scheduler.Default.UnlockQuestSteps()
+	if err != nil {
+		log.Println("解锁任务线步骤失败:", err)
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "任务线创建成功",
+			Refresh: true,
+		})
+	} else {
+		// 创建成功后重定向回任务线页面
+		http.Redirect(w, r, "/quests", http.StatusFound)
+	}
+}
+
+// 删除任务线处理器
+func DeleteQuestHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	questID, err := strconv.Atoi(r.FormValue("quest_id"))
+	if err != nil {
+		http.Error(w, "任务线ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	err = models.DeleteQuest(questID)
+	if err != nil {
+		log.Println("删除任务线失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "任务线已删除",
+			Refresh: true,
+		})
+	} else {
+		// 删除成功后重定向回任务线页面
+		http.Redirect(w, r, "/quests", http.StatusFound)
+	}
+}
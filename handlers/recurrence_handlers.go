@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"minecraft-exchange/recurrence"
+	"minecraft-exchange/utils"
+)
+
+// 预览时显示的重复次数
+const recurrencePreviewCount = 10
+
+// 根据表单数据生成日常任务的重复规则
+// repeat_mode可以是weekly（每周几）、daily（每N天）、monthly（每月几号）或rrule（直接填写RRULE）
+func recurrenceRuleFromForm(r *http.Request) (recurrence.Rule, error) {
+	mode := r.FormValue("repeat_mode")
+	if mode == "rrule" {
+		return recurrence.Parse(r.FormValue("rrule"))
+	}
+
+	var parts []string
+	switch mode {
+	case "daily":
+		parts = append(parts, "FREQ=DAILY")
+	case "monthly":
+		parts = append(parts, "FREQ=MONTHLY", "BYMONTHDAY="+normalizeList(r.FormValue("month_days")))
+	default:
+		// 每周几，兼容旧版本只提交repeat_days的表单
+		weekly, err := recurrence.FromWeekdays(strings.Join(r.Form["repeat_days"], ","))
+		if err != nil {
+			return weekly, err
+		}
+		parts = append(parts, weekly.String())
+	}
+
+	if interval := strings.TrimSpace(r.FormValue("repeat_interval")); interval != "" {
+		parts = append(parts, "INTERVAL="+interval)
+	}
+	if hours := normalizeList(r.FormValue("repeat_hours")); hours != "" {
+		parts = append(parts, "BYHOUR="+hours)
+	}
+	return recurrence.Parse(strings.Join(parts, ";"))
+}
+
+// 整理用户输入的逗号分隔列表，支持中文逗号和空格
+func normalizeList(s string) string {
+	s = strings.NewReplacer("，", ",", "、", ",", " ", ",").Replace(s)
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// 重复规则预览处理器，返回接下来几次重复的时间
+func PreviewRecurrenceHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("解析表单失败:", err)
+	}
+
+	rule, err := recurrenceRuleFromForm(r)
+	if err != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, utils.JSONResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 新模板从今天开始计算重复间隔
+	now := time.Now()
+	var occurrences []string
+	for _, occurrence := range rule.Next(now, now, recurrencePreviewCount) {
+		occurrences = append(occurrences, occurrence.Start.Format("2006-01-02 15:04")+" - "+occurrence.End.Format("15:04")+" "+recurrence.WeekdayName(occurrence.Start.Weekday()))
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"Rule":        rule.String(),
+			"Description": rule.Describe(),
+			"Occurrences": occurrences,
+		},
+	})
+}
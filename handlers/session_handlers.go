@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 管理员会话Cookie名称
+const sessionCookieName = "session_token"
+
+// 管理员会话有效期
+const sessionDuration = 1 * time.Hour
+
+// 无法生成会话token时返回的错误
+var errTokenGeneration = errors.New("生成会话token失败")
+
+// 检查请求是否携带有效的管理员会话
+func isAdminLoggedIn(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	valid, err := models.ValidateSession(cookie.Value)
+	if err != nil {
+		log.Println("验证会话失败:", err)
+		return false
+	}
+	return valid
+}
+
+// 检查管理员登录状态，未登录时跳转到登录页面
+// 返回false时已经写入了响应，调用方应直接返回
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if isAdminLoggedIn(r) {
+		return true
+	}
+
+	// 未登录，检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
+			Success:  false,
+			Message:  "未登录，请先登录",
+			Redirect: "/login",
+		})
+	} else {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
+	return false
+}
+
+// 为管理员账号创建会话并写入Cookie
+func startAdminSession(w http.ResponseWriter, r *http.Request, adminID int) error {
+	sessionToken := utils.GenerateSecureToken(32)
+	if sessionToken == "" {
+		return errTokenGeneration
+	}
+
+	// 顺便清理已过期的会话
+	if err := models.DeleteExpiredSessions(); err != nil {
+		log.Println("清理过期会话失败:", err)
+	}
+
+	expiration := time.Now().Add(sessionDuration)
+	err := models.CreateSession(sessionToken, adminID, expiration, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	cookie := http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionToken,
+		Path:     "/",
+		Expires:  expiration,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// 获取当前请求对应的会话
+func currentSession(r *http.Request) (models.Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return models.Session{}, http.ErrNoCookie
+	}
+	return models.GetSessionByToken(cookie.Value)
+}
+
+// 获取当前请求对应的会话ID，未登录时返回0
+func currentSessionID(r *http.Request) int {
+	session, err := currentSession(r)
+	if err != nil {
+		return 0
+	}
+	return session.ID
+}
+
+// 流水中记录的管理员操作人
+func adminActor(r *http.Request) string {
+	session, err := currentSession(r)
+	if err != nil {
+		return "admin"
+	}
+	return "admin:" + session.AdminUsername
+}
+
+// 获取客户端IP地址
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 退出登录处理器
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err == nil && cookie.Value != "" {
+		err = models.DeleteSessionByToken(cookie.Value)
+		if err != nil {
+			log.Println("删除会话失败:", err)
+		}
+	}
+
+	// 清除Cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success:  true,
+			Message:  "已退出登录",
+			Redirect: "/login",
+		})
+	} else {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
+}
+
+// 撤销会话处理器
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取会话ID
+	sessionIDStr := r.FormValue("session_id")
+	if sessionIDStr == "" {
+		http.Error(w, "会话ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 转换会话ID为整数
+	sessionID, err := strconv.Atoi(sessionIDStr)
+	if err != nil {
+		log.Println("会话ID格式错误:", err)
+		http.Error(w, "会话ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	// 删除会话
+	err = models.DeleteSession(sessionID)
+	if err != nil {
+		log.Println("撤销会话失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "会话已撤销",
+			Refresh: true,
+		})
+	} else {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 系统设置页面处理器
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/settings.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := models.GetAllSettings()
+	if err != nil {
+		log.Println("查询系统设置失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	players, err := models.GetAllPlayers()
+	if err != nil {
+		log.Println("查询玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 每个玩家当前的等级
+	levels := make(map[int]models.LevelInfo)
+	for _, player := range players {
+		level, err := models.GetPlayerLevel(player)
+		if err != nil {
+			log.Println("查询玩家等级失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		levels[player.ID] = level
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Settings":         settings,
+		"Players":          players,
+		"Levels":           levels,
+		"LeaderboardModes": models.LeaderboardModes,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 保存系统设置处理器，修改等级门槛后按新的门槛重新计算等级，已有的经验不变
+func SaveSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	thresholds, err := models.ParseLevelThresholds(r.FormValue(models.SettingLevelThresholds))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parts := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		parts[i] = strconv.Itoa(threshold)
+	}
+	settings := map[string]string{
+		models.SettingLevelThresholds: strings.Join(parts, ","),
+	}
+
+	for _, key := range []string{models.SettingXPEasy, models.SettingXPMedium, models.SettingXPHard} {
+		xp, err := strconv.Atoi(r.FormValue(key))
+		if err != nil || xp < 0 {
+			http.Error(w, "任务经验必须是非负整数", http.StatusBadRequest)
+			return
+		}
+		settings[key] = strconv.Itoa(xp)
+	}
+
+	cancelMinutes, err := strconv.Atoi(r.FormValue(models.SettingCancelMinutes))
+	if err != nil || cancelMinutes < 0 || cancelMinutes > 7*24*60 {
+		http.Error(w, "取消兑换的时间必须是0到10080之间的整数（分钟）", http.StatusBadRequest)
+		return
+	}
+	settings[models.SettingCancelMinutes] = strconv.Itoa(cancelMinutes)
+
+	mode := r.FormValue(models.SettingLeaderboardMode)
+	if !models.IsLeaderboardMode(mode) {
+		http.Error(w, "排行榜显示方式无效", http.StatusBadRequest)
+		return
+	}
+	settings[models.SettingLeaderboardMode] = mode
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for key, value := range settings {
+		err := models.SetSetting(tx, key, value)
+		if err != nil {
+			log.Println("保存系统设置失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "设置已保存",
+			Refresh: true,
+		})
+	} else {
+		// 保存成功后重定向回设置页面
+		http.Redirect(w, r, "/settings", http.StatusFound)
+	}
+}
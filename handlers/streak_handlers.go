@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 连续打卡管理页面处理器
+func StreaksHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/streaks.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	milestones, err := models.GetStreakMilestones()
+	if err != nil {
+		log.Println("查询连续打卡里程碑失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	streaks, err := models.GetAllStreaks()
+	if err != nil {
+		log.Println("查询连续打卡记录失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	players, err := models.GetAllPlayers()
+	if err != nil {
+		log.Println("查询玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 每个玩家持有的保护卡数量
+	streakFreezes := make(map[int]int)
+	for _, player := range players {
+		count, err := models.GetStreakFreezes(player.ID)
+		if err != nil {
+			log.Println("查询保护卡数量失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		streakFreezes[player.ID] = count
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Milestones":    milestones,
+		"Streaks":       streaks,
+		"Players":       players,
+		"StreakFreezes": streakFreezes,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 保存连续打卡里程碑处理器，天数已存在时更新奖励，奖励为0表示不再发放
+func SaveStreakMilestoneHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 2 || days > 366 {
+		http.Error(w, "连续天数必须是2到366之间的整数", http.StatusBadRequest)
+		return
+	}
+
+	bonus, err := strconv.Atoi(r.FormValue("bonus"))
+	if err != nil || bonus < 0 {
+		http.Error(w, "奖励必须是非负整数", http.StatusBadRequest)
+		return
+	}
+
+	err = models.SetStreakMilestone(days, bonus)
+	if err != nil {
+		log.Println("保存连续打卡里程碑失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "里程碑已保存",
+			Refresh: true,
+		})
+	} else {
+		// 保存成功后重定向回连续打卡页面
+		http.Redirect(w, r, "/streaks", http.StatusFound)
+	}
+}
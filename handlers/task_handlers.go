@@ -10,13 +10,21 @@ import (
 	"time"
 
 	"minecraft-exchange/models"
+	"minecraft-exchange/recurrence"
+	"minecraft-exchange/scheduler"
 	"minecraft-exchange/utils"
 )
 
 // 获取任务数据的JSON接口
 func GetTasksDataHandler(w http.ResponseWriter, r *http.Request) {
-	// 获取可用任务
-	tasks, err := models.GetAvailableTasks()
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	// 获取指派给当前玩家或所有人的可用任务
+	tasks, err := models.GetAvailableTasks(player.ID)
 	if err != nil {
 		log.Println("查询任务失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
@@ -27,9 +35,9 @@ func GetTasksDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 获取玩家已领取任务
-	playerID, err := models.GetFirstPlayerID()
+	claimedTasks, err := models.GetPlayerClaimedTasks(player.ID)
 	if err != nil {
-		log.Println("获取玩家ID失败:", err)
+		log.Println("查询已领取任务失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
 			Success: false,
 			Message: "服务器错误",
@@ -37,9 +45,10 @@ func GetTasksDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claimedTasks, err := models.GetPlayerClaimedTasks(playerID)
+	// 去掉处于玩家假期中的日常任务
+	tasks, err = filterPlayerBlackoutTasks(tasks, player.ID)
 	if err != nil {
-		log.Println("查询已领取任务失败:", err)
+		log.Println("查询玩家假期失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
 			Success: false,
 			Message: "服务器错误",
@@ -48,7 +57,7 @@ func GetTasksDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 获取即将开始的任务
-	upcomingTasks, err := models.GetUpcomingTasks()
+	upcomingTasks, err := models.GetUpcomingTasks(player.ID)
 	if err != nil {
 		log.Println("查询即将开始任务失败:", err)
 		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
@@ -58,6 +67,48 @@ func GetTasksDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 获取可领取和已领取任务的清单
+	checklists, err := models.GetPlayerTaskChecklists(player.ID)
+	if err != nil {
+		log.Println("查询任务清单失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	// 获取玩家的任务线进度
+	quests, err := models.GetPlayerQuestProgress(player.ID)
+	if err != nil {
+		log.Println("查询任务线进度失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
+	// 获取玩家的连续打卡记录和保护卡数量
+	streaks, err := models.GetPlayerStreaks(player.ID)
+	if err != nil {
+		log.Println("查询连续打卡记录失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+	streakFreezes, err := models.GetStreakFreezes(player.ID)
+	if err != nil {
+		log.Println("查询保护卡数量失败:", err)
+		utils.SendJSONResponse(w, http.StatusInternalServerError, utils.JSONResponse{
+			Success: false,
+			Message: "服务器错误",
+		})
+		return
+	}
+
 	// 返回JSON响应
 	utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
 		Success: true,
@@ -65,6 +116,10 @@ func GetTasksDataHandler(w http.ResponseWriter, r *http.Request) {
 			"AvailableTasks": tasks,
 			"ClaimedTasks":   claimedTasks,
 			"UpcomingTasks":  upcomingTasks,
+			"Checklists":     checklists,
+			"Quests":         quests,
+			"Streaks":        streaks,
+			"StreakFreezes":  streakFreezes,
 		},
 	})
 }
@@ -83,8 +138,14 @@ func TasksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取可用任务
-	tasks, err := models.GetAvailableTasks()
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	// 获取指派给当前玩家或所有人的可用任务
+	tasks, err := models.GetAvailableTasks(player.ID)
 	if err != nil {
 		log.Println("查询任务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
@@ -92,30 +153,53 @@ func TasksHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 获取玩家已领取任务
-	playerID, err := models.GetFirstPlayerID()
+	claimedTasks, err := models.GetPlayerClaimedTasks(player.ID)
 	if err != nil {
-		log.Println("获取玩家ID失败:", err)
+		log.Println("查询已领取任务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	claimedTasks, err := models.GetPlayerClaimedTasks(playerID)
+	// 去掉处于玩家假期中的日常任务
+	tasks, err = filterPlayerBlackoutTasks(tasks, player.ID)
 	if err != nil {
-		log.Println("查询已领取任务失败:", err)
+		log.Println("查询玩家假期失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
 	// 获取即将开始的任务
-	upcomingTasks, err := models.GetUpcomingTasks()
+	upcomingTasks, err := models.GetUpcomingTasks(player.ID)
 	if err != nil {
 		log.Println("查询即将开始任务失败:", err)
 	}
 
-	// 获取玩家信息
-	player, err := models.GetPlayerInfo(playerID)
+	// 获取可领取和已领取任务的清单
+	checklists, err := models.GetPlayerTaskChecklists(player.ID)
+	if err != nil {
+		log.Println("查询任务清单失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 获取玩家的任务线进度
+	quests, err := models.GetPlayerQuestProgress(player.ID)
+	if err != nil {
+		log.Println("查询任务线进度失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 获取玩家的连续打卡记录和保护卡数量
+	streaks, err := models.GetPlayerStreaks(player.ID)
+	if err != nil {
+		log.Println("查询连续打卡记录失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	streakFreezes, err := models.GetStreakFreezes(player.ID)
 	if err != nil {
-		log.Println("查询玩家信息失败:", err)
+		log.Println("查询保护卡数量失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
@@ -127,6 +211,10 @@ func TasksHandler(w http.ResponseWriter, r *http.Request) {
 		"Tasks":         tasks,
 		"UpcomingTasks": upcomingTasks,
 		"ClaimedTasks":  claimedTasks,
+		"Checklists":    checklists,
+		"Quests":        quests,
+		"Streaks":       streaks,
+		"StreakFreezes": streakFreezes,
 	}
 
 	// 执行模板渲染
@@ -141,6 +229,12 @@ func ClaimTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
 	// 获取任务ID
 	taskIDStr := r.FormValue("task_id")
 	if taskIDStr == "" {
@@ -189,17 +283,36 @@ func ClaimTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取第一个玩家ID
-	playerID, err := models.GetFirstPlayerID()
+	// 只能领取指派给自己或所有人的任务
+	visible, err := models.IsTaskVisibleToPlayer(taskID, player.ID)
+	if err != nil {
+		log.Println("查询任务指派失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		http.Error(w, "这个任务没有指派给你", http.StatusForbidden)
+		return
+	}
+
+	// 玩家在假期中不能领取这一天的日常任务
+	blackouts, err := models.GetPlayerBlackoutDates(player.ID)
 	if err != nil {
-		log.Println("获取玩家ID失败:", err)
+		log.Println("查询玩家假期失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
+	if isTaskInPlayerBlackout(task, blackouts) {
+		http.Error(w, "假期中不能领取这一天的日常任务", http.StatusBadRequest)
+		return
+	}
 
 	// 使用models包中的ClaimTask函数
-	err = models.ClaimTask(taskID, playerID)
-	if err != nil {
+	err = models.ClaimTask(taskID, player.ID)
+	if err == models.ErrTaskNotAvailable {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		log.Println("领取任务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
@@ -226,6 +339,14 @@ func CompleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 解析表单，可以附带照片作为完成凭证
+	err := parseEvidenceForm(w, r)
+	if err != nil {
+		log.Println("解析表单失败:", err)
+		http.Error(w, "上传的图片太大或表单格式错误", http.StatusBadRequest)
+		return
+	}
+
 	// 获取任务ID
 	taskIDStr := r.FormValue("task_id")
 	if taskIDStr == "" {
@@ -241,13 +362,12 @@ func CompleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取第一个玩家ID
-	currentPlayerID, err := models.GetFirstPlayerID()
-	if err != nil {
-		log.Println("获取玩家ID失败:", err)
-		http.Error(w, "服务器错误", http.StatusInternalServerError)
+	// 获取当前玩家
+	currentPlayer, ok := requireCurrentPlayer(w, r)
+	if !ok {
 		return
 	}
+	currentPlayerID := currentPlayer.ID
 
 	// 先获取任务信息，检查状态和所有权
 	task, err := models.GetTaskByID(taskID)
@@ -263,14 +383,95 @@ func CompleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 使用models包中的CompleteTask函数
-	err = models.CompleteTask(taskID)
+	// 检查上传的图片
+	uploads, err := readEvidenceFiles(r)
+	if isEvidenceUserError(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("读取上传图片失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 事务处理提交任务和审核记录
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 清单中的必做步骤全部勾选后才能提交
+	unchecked, err := models.CountUncheckedRequiredItems(tx, taskID)
 	if err != nil {
+		log.Println("查询任务清单失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if unchecked > 0 {
+		http.Error(w, fmt.Sprintf("还有%d个必做步骤没有勾选", unchecked), http.StatusBadRequest)
+		return
+	}
+
+	// 需要计时的任务先停止计时，记录的时间达到要求后才能提交
+	if task.RequiredMinutes > 0 {
+		err = models.StopTaskTimer(tx, taskID, time.Now())
+		if err != nil {
+			log.Println("停止任务计时失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		elapsed, err := models.GetTaskElapsedSeconds(tx, taskID)
+		if err != nil {
+			log.Println("查询任务计时失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		if remaining := task.RequiredSeconds() - elapsed; remaining > 0 {
+			http.Error(w, "还需要计时"+models.FormatDuration(remaining)+"才能提交", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 使用models包中的CompleteTask函数
+	err = models.CompleteTask(tx, taskID, currentPlayerID)
+	if err == models.ErrTaskNotClaimed {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		log.Println("完成任务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
+	// 记录提交
+	err = models.AddTaskReview(tx, taskID, models.ReviewSubmitted, "", playerActor(currentPlayer))
+	if err != nil {
+		log.Println("记录任务提交失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 保存图片凭证
+	savedFiles, err := saveEvidenceFiles(tx, taskID, uploads)
+	if err != nil {
+		utils.RemoveUploadedFiles(savedFiles...)
+		log.Println("保存任务凭证失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 提交事务
+	err = tx.Commit()
+	if err != nil {
+		utils.RemoveUploadedFiles(savedFiles...)
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
 	// 记录成功日志
 	log.Printf("任务提交成功: ID=%d, 状态已更新为completed", taskID)
 
@@ -278,7 +479,7 @@ func CompleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if task.TemplateID != nil && *task.TemplateID > 0 {
 		log.Printf("任务 %d 有模板ID %d，尝试创建新的任务实例", taskID, *task.TemplateID)
 		// 调用创建任务实例的函数
-		err = utils.CreateTaskInstancesFromTemplate(*task.TemplateID, "", "")
+		err = scheduler.Default.CreateTaskInstancesFromTemplate(*task.TemplateID, "", "")
 		if err != nil {
 			// 创建失败不会影响任务完成流程，只记录日志
 			log.Printf("根据模板 %d 创建新任务实例失败: %v", *task.TemplateID, err)
@@ -303,18 +504,7 @@ func CompleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 // 验证任务完成并发放奖励处理器
 func VerifyTaskHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，检查是否为AJAX请求
-		if utils.IsAJAXRequest(r) {
-			utils.SendJSONResponse(w, http.StatusUnauthorized, utils.JSONResponse{
-				Success:  false,
-				Message:  "未登录，请先登录",
-				Redirect: "/login",
-			})
-		} else {
-			http.Redirect(w, r, "/login", http.StatusFound)
-		}
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -339,6 +529,26 @@ func VerifyTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 获取评分（百分比），未提供时按100%发放
+	gradePercent := 100
+	if gradeStr := r.FormValue("grade"); gradeStr != "" {
+		gradePercent, err = strconv.Atoi(gradeStr)
+		if err != nil || gradePercent < 0 || gradePercent > 100 {
+			http.Error(w, "评分必须是0到100之间的整数", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 获取额外奖励，未提供时为0
+	bonus := 0
+	if bonusStr := r.FormValue("bonus"); bonusStr != "" {
+		bonus, err = strconv.Atoi(bonusStr)
+		if err != nil || bonus < 0 {
+			http.Error(w, "额外奖励必须是非负整数", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// 先获取任务信息，检查状态
 	task, err := models.GetTaskByID(taskID)
 	if err != nil {
@@ -366,36 +576,138 @@ func VerifyTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取玩家当前绿宝石数量
-	player, err := models.GetPlayerInfo(*task.PlayerID)
+	actor := adminActor(r)
+
+	// 根据评分计算实际发放的奖励
+	paidReward := models.CalculateGradedReward(task.Reward, gradePercent, bonus)
+	reviewComment := fmt.Sprintf("评分 %d%%，额外奖励 %d，实发 %d", gradePercent, bonus, paidReward)
+
+	// 事务处理验证任务和发放奖励
+	tx, err := models.DB.Begin()
 	if err != nil {
-		log.Println("查询玩家信息失败:", err)
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 验证任务，只有已完成状态的任务会被更新，避免重复发放奖励
+	err = models.VerifyTask(tx, taskID, gradePercent, bonus, paidReward)
+	if err == models.ErrTaskNotCompleted {
+		http.Error(w, "任务状态已变化，请刷新页面", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println("验证任务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 增加玩家绿宝石数量
-	newEmeralds := player.Emeralds + task.Reward
-	err = models.UpdatePlayerEmeralds(*task.PlayerID, newEmeralds)
+	// 增加玩家绿宝石数量，记入流水
+	_, err = models.AddEmeraldTransaction(tx, *task.PlayerID, paidReward, models.ReasonTaskReward, &task.ID, nil, actor, task.Title+"（"+reviewComment+"）")
 	if err != nil {
 		log.Println("增加绿宝石失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
-	// 验证任务
-	err = models.VerifyTask(taskID)
+	// 记录确认
+	err = models.AddTaskReview(tx, taskID, models.ReviewVerified, reviewComment, actor)
 	if err != nil {
-		log.Println("验证任务失败:", err)
+		log.Println("记录任务确认失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("任务验证成功，已发放 %d 绿宝石", paidReward)
+
+	// 按难度和评分增加经验，经验只增不减
+	xp, err := models.TaskXP(tx, task.Difficulty, gradePercent)
+	if err != nil {
+		log.Println("计算任务经验失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	levelUps, err := models.AddPlayerXP(tx, *task.PlayerID, xp, &task.ID)
+	if err != nil {
+		log.Println("增加经验失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if xp > 0 {
+		message += fmt.Sprintf("，获得 %d 经验", xp)
+	}
+	if len(levelUps) > 0 {
+		message += fmt.Sprintf("，升到了 %d 级", levelUps[len(levelUps)-1])
+	}
+
+	// 推进任务线进度，完成整条任务线时发放额外奖励
+	completedQuest, err := models.AdvanceQuest(tx, task)
+	if err != nil {
+		log.Println("推进任务线进度失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if completedQuest != nil {
+		message += fmt.Sprintf("，完成了任务线「%s」", completedQuest.Title)
+		if completedQuest.Bonus > 0 {
+			_, err = models.AddEmeraldTransaction(tx, *task.PlayerID, completedQuest.Bonus, models.ReasonQuestBonus, &task.ID, nil, actor, "完成任务线："+completedQuest.Title)
+			if err != nil {
+				log.Println("发放任务线奖励失败:", err)
+				http.Error(w, "服务器错误", http.StatusInternalServerError)
+				return
+			}
+			message += fmt.Sprintf("，额外奖励 %d 绿宝石", completedQuest.Bonus)
+		}
+	}
+
+	// 日常任务达到连续打卡里程碑时发放额外奖励
+	milestone, err := models.ReachStreakMilestone(tx, task)
+	if err != nil {
+		log.Println("检查连续打卡里程碑失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if milestone != nil {
+		_, err = models.AddEmeraldTransaction(tx, *task.PlayerID, milestone.Bonus, models.ReasonStreakBonus, &task.ID, nil, actor, fmt.Sprintf("连续完成%s %d天", task.Title, milestone.Days))
+		if err != nil {
+			log.Println("发放连续打卡奖励失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+		message += fmt.Sprintf("，连续完成 %d 天，额外奖励 %d 绿宝石", milestone.Days, milestone.Bonus)
+	}
+
+	// 检查玩家新解锁的成就
+	achievementMessage, err := unlockAchievements(tx, *task.PlayerID)
+	if err != nil {
+		log.Println("检查成就失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	message += achievementMessage
+
+	// 提交事务
+	err = tx.Commit()
+	if err != nil {
+		log.Println("提交事务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
 
+	// 任务线步骤确认后解锁下一步
+	if task.QuestID != nil {
+		err = scheduler.Default.UnlockQuestSteps()
+		if err != nil {
+			// 解锁失败不影响确认结果，下次定时刷新时会重试
+			log.Println("解锁任务线步骤失败:", err)
+		}
+	}
+
 	// 检查是否为AJAX请求
 	if utils.IsAJAXRequest(r) {
 		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
 			Success: true,
-			Message: "任务验证成功，奖励已发放",
+			Message: message,
 			Refresh: true,
 		})
 	} else {
@@ -404,13 +716,140 @@ func VerifyTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// 退回任务处理器，任务回到已领取状态，玩家可以根据家长留言重新完成
+func RejectTaskHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取任务ID和留言
+	taskIDStr := r.FormValue("task_id")
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if taskIDStr == "" {
+		http.Error(w, "任务ID不能为空", http.StatusBadRequest)
+		return
+	}
+	if comment == "" {
+		http.Error(w, "请填写需要重做的原因", http.StatusBadRequest)
+		return
+	}
+
+	// 转换任务ID为整数
+	taskID, err := strconv.Atoi(taskIDStr)
+	if err != nil {
+		log.Println("任务ID格式错误:", err)
+		http.Error(w, "任务ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	actor := adminActor(r)
+
+	// 事务处理退回任务和审核记录
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// 退回任务，只有已完成状态的任务可以退回
+	err = models.RejectTask(tx, taskID, comment)
+	if err == models.ErrTaskNotCompleted {
+		http.Error(w, "任务状态已变化，请刷新页面", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println("退回任务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 记录退回
+	err = models.AddTaskReview(tx, taskID, models.ReviewRejected, comment, actor)
+	if err != nil {
+		log.Println("记录任务退回失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 提交事务
+	err = tx.Commit()
+	if err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: "任务已退回，等待玩家重做",
+			Refresh: true,
+		})
+	} else {
+		// 退回后重定向回管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 任务审核记录页面处理器
+func TaskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 转换任务ID为整数
+	taskID, err := strconv.Atoi(r.URL.Query().Get("task_id"))
+	if err != nil {
+		http.Error(w, "任务ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/task_history.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	// 查询任务信息
+	task, err := models.GetTaskByID(taskID)
+	if err != nil {
+		log.Println("查询任务信息失败:", err)
+		http.Error(w, "任务不存在", http.StatusNotFound)
+		return
+	}
+
+	// 查询审核记录
+	reviews, err := models.GetTaskReviews(taskID)
+	if err != nil {
+		log.Println("查询任务审核记录失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Task":    task,
+		"Reviews": reviews,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
 // 创建任务模板处理器
 func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，重定向到登录页面
-		http.Redirect(w, r, "/login", http.StatusFound)
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -431,7 +870,7 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("接收到创建任务请求: title=%s, type=%s, startTime=%s, expiryTime=%s", title, taskType, startTime, expiryTime)
 	// 获取日常任务的重复周期
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		log.Println("解析表单失败:", err)
 	}
@@ -447,10 +886,16 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 日常任务需要设置重复周期
-	if taskType == "daily" && repeatDays == "" {
-		http.Error(w, "日常任务必须设置重复周期", http.StatusBadRequest)
-		return
+	// 日常任务需要设置重复规则
+	var recurrenceRule, recurrenceStart string
+	if taskType == "daily" {
+		rule, err := recurrenceRuleFromForm(r)
+		if err != nil {
+			http.Error(w, "重复规则错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		recurrenceRule = rule.String()
+		recurrenceStart = time.Now().Format(recurrence.DateLayout)
 	}
 
 	// 转换奖励值为整数
@@ -461,6 +906,20 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 读取指派的玩家
+	assigneeIDs, err := assigneeIDsFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 读取需要计时的分钟数
+	requiredMinutes, err := requiredMinutesFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 创建任务模板结构体
 	template := models.TaskTemplate{
 		Title:       title,
@@ -469,10 +928,17 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		Type:        taskType,
 		Reward:      reward,
 		RepeatDays:  repeatDays,
+
+		RecurrenceRule:  recurrenceRule,
+		RecurrenceStart: recurrenceStart,
+		PerPlayer:       r.FormValue("per_player") == "1",
+		AssigneeIDs:     assigneeIDs,
+		Checklist:       models.ParseChecklist(r.FormValue("checklist")),
+		RequiredMinutes: requiredMinutes,
 	}
 
 	// 使用models包中的CreateTaskTemplate函数
-	templateID, err := models.CreateTaskTemplate(template)
+	templateID, err := models.CreateTaskTemplate(template, adminActor(r))
 	if err != nil {
 		log.Println("创建任务模板失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
@@ -480,7 +946,7 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 调用创建任务实例的函数，根据模板类型和规则生成相应的任务实例
-	err = CreateTaskInstancesFromTemplate(int(templateID), expiryTime, startTime)
+	err = scheduler.Default.CreateTaskInstancesFromTemplate(int(templateID), expiryTime, startTime)
 	if err != nil {
 		log.Println("创建任务实例失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
@@ -491,166 +957,10 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin", http.StatusFound)
 }
 
-// 创建任务实例的函数，可被createTaskHandler和定时任务调用
-func CreateTaskInstancesFromTemplate(templateID int, expiryTimeForLimited string, startTimeForLimited string) error {
-	log.Printf("创建任务实例: templateID=%d, expiryTime=%s, startTime=%s", templateID, expiryTimeForLimited, startTimeForLimited)
-	// 查询模板信息
-	var title, description, difficulty, taskType, repeatDays string
-	var reward int
-	query := "SELECT title, description, difficulty, type, reward, repeat_days FROM task_templates WHERE id = ?"
-	err := models.DB.QueryRow(query, templateID).Scan(&title, &description, &difficulty, &taskType, &reward, &repeatDays)
-	if err != nil {
-		return fmt.Errorf("查询任务模板失败: %w", err)
-	}
-
-	// 根据任务类型处理
-	if taskType == "daily" {
-		// 检查是否存在状态为'available'或'claimed'的派生实例
-		var activeCount int
-		activeQuery := "SELECT COUNT(*) FROM tasks WHERE template_id = ? AND status IN ('available', 'claimed')"
-		err = models.DB.QueryRow(activeQuery, templateID).Scan(&activeCount)
-		if err != nil {
-			return fmt.Errorf("查询活跃任务实例失败: %w", err)
-		}
-
-		// 如果没有活跃实例，根据重复周几设置创建新实例
-		if activeCount == 0 {
-			// 获取当前时间
-			now := time.Now()
-
-			days := strings.Split(repeatDays, ",")
-
-			// 查找未来7天内下一个符合重复周期的日期
-			var targetDate time.Time
-			found := false
-
-			for i := 0; i < 7; i++ {
-				checkDate := now.AddDate(0, 0, i)
-				checkWeekday := int(checkDate.Weekday())
-				checkWeekdayStr := strconv.Itoa(checkWeekday)
-
-				for _, day := range days {
-					if day == checkWeekdayStr {
-						targetDate = checkDate
-						found = true
-						break
-					}
-				}
-				if found {
-					break
-				}
-			}
-
-			if found {
-				// 设置任务过期时间为目标日期的23:59:59
-				expiryTime := time.Date(targetDate.Year(), targetDate.Month(), targetDate.Day(), 23, 59, 59, 0, targetDate.Location())
-				expiryTimeStr := expiryTime.Format("2006-01-02 15:04:05")
-
-				// 设置任务开始时间为目标日期的00:00:00
-				startTime := time.Date(targetDate.Year(), targetDate.Month(), targetDate.Day(), 0, 0, 0, 0, targetDate.Location())
-				startTimeStr := startTime.Format("2006-01-02 15:04:05")
-
-				// 创建任务结构体
-				task := models.Task{
-					Title:       title,
-					Description: description,
-					Difficulty:  difficulty,
-					Type:        "daily",
-					Reward:      reward,
-					ExpiryTime:  expiryTimeStr,
-					Status:      "available",
-					TemplateID:  &templateID,
-					CreatedAt:   time.Now(),
-					StartTime:   startTimeStr,
-				}
-
-				// 使用models包中的CreateTask函数
-				err = models.CreateTask(task)
-				if err != nil {
-					return fmt.Errorf("创建日常任务实例失败: %w", err)
-				}
-				log.Printf("成功创建日常任务 '%s' 实例，开始时间: %s", title, startTimeStr)
-			}
-		}
-	} else if taskType == "limited" {
-		// 限时任务：如果没有派生实例，则创建
-		var count int
-		sqlQuery := "SELECT COUNT(*) FROM tasks WHERE template_id = ?"
-		err = models.DB.QueryRow(sqlQuery, templateID).Scan(&count)
-		if err != nil {
-			return fmt.Errorf("查询任务实例失败: %w", err)
-		}
-
-		if count == 0 {
-			// 为限时任务创建一个任务实例，包含created_at和start_time字段
-			var startTimeStr string
-			if startTimeForLimited != "" {
-				// 使用用户设置的开始时间，格式化成2006-01-02 15:04:05类型
-				// 尝试解析用户输入的时间字符串
-				log.Printf("尝试解析用户提交的开始时间: %s", startTimeForLimited)
-
-				// 尝试多种常见格式解析
-				parsedTime, err := time.Parse("2006-01-02 15:04:05", startTimeForLimited)
-				if err != nil {
-					// 尝试带T的ISO格式
-					parsedTime, err = time.Parse("2006-01-02T15:04:05", startTimeForLimited)
-				}
-				if err != nil {
-					// 尝试datetime-local格式（不带秒）
-					parsedTime, err = time.Parse("2006-01-02T15:04", startTimeForLimited)
-				}
-
-				if err != nil {
-					// 如果所有解析都失败，记录详细错误信息
-					log.Printf("解析开始时间失败: %v, 原始值: %s", err, startTimeForLimited)
-					// 这里不自动使用当前时间，而是返回错误，确保用户知道开始时间设置有问题
-					return fmt.Errorf("解析开始时间失败: %w, 原始值: %s", err, startTimeForLimited)
-				} else {
-					// 解析成功，格式化为标准格式
-					startTimeStr = parsedTime.Format("2006-01-02 15:04:05")
-				}
-				log.Printf("成功解析并格式化用户设置的开始时间: %s", startTimeStr)
-			} else {
-				// 如果没有设置开始时间，则使用当前时间
-				startTimeStr = time.Now().Format("2006-01-02 15:04:05")
-				log.Printf("没有设置开始时间，使用当前时间: %s", startTimeStr)
-			}
-
-			log.Printf("准备创建限时任务: title=%s, start_time=%s, expiry_time=%s", title, startTimeStr, expiryTimeForLimited)
-
-			// 创建任务结构体
-			task := models.Task{
-				Title:       title,
-				Description: description,
-				Difficulty:  difficulty,
-				Type:        taskType,
-				Reward:      reward,
-				ExpiryTime:  expiryTimeForLimited,
-				Status:      "available",
-				TemplateID:  &templateID,
-				CreatedAt:   time.Now(),
-				StartTime:   startTimeStr,
-			}
-
-			// 使用models包中的CreateTask函数
-			err = models.CreateTask(task)
-			if err != nil {
-				return fmt.Errorf("创建限时任务实例失败: %w", err)
-			}
-			log.Printf("成功创建限时任务 '%s' 实例，开始时间: %s", title, startTimeStr)
-		}
-	}
-
-	return nil
-}
-
 // 删除任务处理器
 func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，重定向到登录页面
-		http.Redirect(w, r, "/login", http.StatusFound)
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -676,12 +986,14 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 使用models包中的DeleteTask函数
-	err = models.DeleteTask(taskID)
+	files, err := models.DeleteTask(taskID)
 	if err != nil {
 		log.Println("删除任务失败:", err)
 		http.Error(w, "服务器错误", http.StatusInternalServerError)
 		return
 	}
+	// 数据库记录删除后再删除凭证图片
+	utils.RemoveUploadedFiles(files...)
 
 	// 删除成功后重定向回管理员页面
 	http.Redirect(w, r, "/admin", http.StatusFound)
@@ -690,10 +1002,7 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 // 删除任务模板处理器
 func DeleteTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
 	// 检查是否已登录
-	cookie, err := r.Cookie("session_token")
-	if err != nil || cookie.Value == "" {
-		// 未登录，重定向到登录页面
-		http.Redirect(w, r, "/login", http.StatusFound)
+	if !requireAdmin(w, r) {
 		return
 	}
 
@@ -718,6 +1027,18 @@ func DeleteTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 属于任务线的模板需要先删除任务线，否则玩家会卡在这一步
+	inQuest, err := models.IsQuestTemplate(templateID)
+	if err != nil {
+		log.Println("查询任务线失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	if inQuest {
+		http.Error(w, "该模板属于任务线，请先删除任务线", http.StatusBadRequest)
+		return
+	}
+
 	// 使用models包中的DeleteTaskTemplate函数
 	err = models.DeleteTaskTemplate(templateID)
 	if err != nil {
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/recurrence"
+	"minecraft-exchange/scheduler"
+	"minecraft-exchange/utils"
+)
+
+// 编辑任务模板处理器
+// apply_to为available时同时更新该模板生成的、仍可领取的任务，否则只影响之后生成的任务
+func UpdateTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Println("解析表单失败:", err)
+	}
+
+	templateID, err := strconv.Atoi(r.FormValue("template_id"))
+	if err != nil {
+		http.Error(w, "任务模板ID格式错误", http.StatusBadRequest)
+		return
+	}
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		http.Error(w, "任务模板版本格式错误", http.StatusBadRequest)
+		return
+	}
+
+	taskTemplate, err := models.GetTaskTemplateByID(templateID)
+	if err != nil {
+		log.Println("查询任务模板失败:", err)
+		http.Error(w, "任务模板不存在", http.StatusNotFound)
+		return
+	}
+
+	// 获取表单数据
+	title := r.FormValue("title")
+	difficulty := r.FormValue("difficulty")
+	if title == "" || difficulty == "" {
+		http.Error(w, "请填写所有必要字段", http.StatusBadRequest)
+		return
+	}
+	reward, err := strconv.Atoi(r.FormValue("reward"))
+	if err != nil || reward <= 0 {
+		http.Error(w, "奖励必须是正整数", http.StatusBadRequest)
+		return
+	}
+
+	taskTemplate.Title = title
+	taskTemplate.Description = r.FormValue("description")
+	taskTemplate.Difficulty = difficulty
+	taskTemplate.Reward = reward
+	taskTemplate.Version = version
+
+	// 日常任务可以修改重复规则，模板类型和重复起始日期保持不变
+	if taskTemplate.Type == "daily" {
+		rule, err := recurrenceRuleFromForm(r)
+		if err != nil {
+			http.Error(w, "重复规则错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		taskTemplate.RecurrenceRule = rule.String()
+		taskTemplate.RepeatDays = strings.Join(r.Form["repeat_days"], ",")
+	}
+
+	// 指派的玩家只影响之后生成的任务
+	taskTemplate.AssigneeIDs, err = assigneeIDsFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	taskTemplate.PerPlayer = r.FormValue("per_player") == "1"
+
+	// 清单和模板内容一样，选择同时更新时会替换可领取任务的清单
+	taskTemplate.Checklist = models.ParseChecklist(r.FormValue("checklist"))
+	taskTemplate.RequiredMinutes, err = requiredMinutesFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	applyToAvailable := r.FormValue("apply_to") == "available"
+	actor := adminActor(r)
+
+	tx, err := models.DB.Begin()
+	if err != nil {
+		log.Println("开始事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	newVersion, err := models.UpdateTaskTemplate(tx, taskTemplate)
+	if errors.Is(err, models.ErrTemplateVersionConflict) || errors.Is(err, models.ErrPerPlayerInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("更新任务模板失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+	taskTemplate.Version = newVersion
+
+	err = models.SetTemplateAssignees(tx, taskTemplate.ID, taskTemplate.AssigneeIDs)
+	if err != nil {
+		log.Println("更新模板指派玩家失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	err = models.SetTemplateChecklist(tx, taskTemplate.ID, taskTemplate.Checklist)
+	if err != nil {
+		log.Println("更新模板清单失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	err = models.AddTaskTemplateVersion(tx, taskTemplate, applyToAvailable, actor)
+	if err != nil {
+		log.Println("记录任务模板版本失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	var updatedTasks int64
+	if applyToAvailable {
+		updatedTasks, err = models.ApplyTemplateToAvailableTasks(tx, taskTemplate)
+		if err != nil {
+			log.Println("更新可领取任务失败:", err)
+			http.Error(w, "服务器错误", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("提交事务失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	message := fmt.Sprintf("任务模板已更新为第%d版", newVersion)
+	if applyToAvailable {
+		message += fmt.Sprintf("，同时更新了%d个可领取的任务", updatedTasks)
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: message,
+			Refresh: true,
+		})
+	} else {
+		// 更新成功后重定向回管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 任务模板版本历史页面处理器
+func TaskTemplateHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	templateID, err := strconv.Atoi(r.URL.Query().Get("template_id"))
+	if err != nil {
+		http.Error(w, "任务模板ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	taskTemplate, err := models.GetTaskTemplateByID(templateID)
+	if err != nil {
+		log.Println("查询任务模板失败:", err)
+		http.Error(w, "任务模板不存在", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/template_history.html")
+	if err != nil {
+		http.Error(w, "无法加载模板", http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := models.GetTaskTemplateVersions(templateID)
+	if err != nil {
+		log.Println("查询任务模板版本失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 准备传递给模板的数据
+	data := map[string]interface{}{
+		"Template": taskTemplate,
+		"Versions": versions,
+	}
+
+	// 执行模板渲染
+	tmpl.Execute(w, data)
+}
+
+// 设置任务模板状态处理器，用于暂停、恢复和归档模板
+// 暂停时可以通过paused_until指定暂停到哪一天，为空表示一直暂停到手动恢复
+func SetTaskTemplateStatusHandler(w http.ResponseWriter, r *http.Request) {
+	// 检查是否已登录
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	templateID, err := strconv.Atoi(r.FormValue("template_id"))
+	if err != nil {
+		http.Error(w, "任务模板ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	status := r.FormValue("status")
+	pausedUntil := r.FormValue("paused_until")
+	var message string
+	switch status {
+	case models.TemplateActive:
+		message = "任务模板已恢复"
+	case models.TemplateArchived:
+		message = "任务模板已归档"
+	case models.TemplatePaused:
+		message = "任务模板已暂停"
+		if pausedUntil != "" {
+			until, err := time.ParseInLocation(recurrence.DateLayout, pausedUntil, time.Local)
+			if err != nil {
+				http.Error(w, "暂停日期格式错误", http.StatusBadRequest)
+				return
+			}
+			if until.Format(recurrence.DateLayout) < time.Now().Format(recurrence.DateLayout) {
+				http.Error(w, "暂停日期不能早于今天", http.StatusBadRequest)
+				return
+			}
+			message = "任务模板已暂停至" + pausedUntil
+		}
+	default:
+		http.Error(w, "无效的模板状态", http.StatusBadRequest)
+		return
+	}
+
+	err = models.SetTaskTemplateStatus(templateID, status, pausedUntil)
+	if err != nil {
+		log.Println("设置任务模板状态失败:", err)
+		http.Error(w, "任务模板不存在", http.StatusNotFound)
+		return
+	}
+
+	// 恢复日常任务模板后立即补充接下来的任务实例
+	if status == models.TemplateActive {
+		taskTemplate, err := models.GetTaskTemplateByID(templateID)
+		if err == nil && taskTemplate.Type == "daily" {
+			err = scheduler.Default.CreateRecurringTaskInstances(taskTemplate)
+		}
+		if err != nil {
+			log.Println("创建任务实例失败:", err)
+		}
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: message,
+			Refresh: true,
+		})
+	} else {
+		// 设置成功后重定向回管理员页面
+		http.Redirect(w, r, "/admin", http.StatusFound)
+	}
+}
+
+// 读取表单中指派的玩家ID，没有选择表示所有玩家
+func assigneeIDsFromForm(r *http.Request) ([]int, error) {
+	var playerIDs []int
+	for _, value := range r.Form["assignee_ids"] {
+		playerID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("玩家ID格式错误")
+		}
+		playerIDs = append(playerIDs, playerID)
+	}
+	return playerIDs, nil
+}
+
+// 读取表单中需要计时的分钟数，为空表示不需要计时
+func requiredMinutesFromForm(r *http.Request) (int, error) {
+	value := r.FormValue("required_minutes")
+	if value == "" {
+		return 0, nil
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 0 || minutes > 24*60 {
+		return 0, errors.New("计时分钟数必须是0到1440之间的整数")
+	}
+	return minutes, nil
+}
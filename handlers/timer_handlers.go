@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/utils"
+)
+
+// 任务计时处理器
+// action为start时开始或继续计时，为pause时暂停计时，计时记录在服务器上，刷新页面不会丢失
+func TaskTimerHandler(w http.ResponseWriter, r *http.Request) {
+	// 确保是POST请求
+	if r.Method != "POST" {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取当前玩家
+	player, ok := requireCurrentPlayer(w, r)
+	if !ok {
+		return
+	}
+
+	taskID, err := strconv.Atoi(r.FormValue("task_id"))
+	if err != nil {
+		http.Error(w, "任务ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	task, err := models.GetTaskByID(taskID)
+	if err != nil {
+		log.Println("查询任务信息失败:", err)
+		http.Error(w, "任务不存在", http.StatusNotFound)
+		return
+	}
+	if task.PlayerID == nil || *task.PlayerID != player.ID {
+		http.Error(w, "只能为自己的任务计时", http.StatusBadRequest)
+		return
+	}
+	if task.RequiredMinutes <= 0 {
+		http.Error(w, "这个任务不需要计时", http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	switch r.FormValue("action") {
+	case "start":
+		err = models.StartTaskTimer(taskID, time.Now())
+		message = "开始计时"
+	case "pause":
+		err = models.StopTaskTimer(models.DB, taskID, time.Now())
+		message = "计时已暂停"
+	default:
+		http.Error(w, "无效的计时操作", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, models.ErrTimerNotAllowed) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("更新任务计时失败:", err)
+		http.Error(w, "服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	// 检查是否为AJAX请求
+	if utils.IsAJAXRequest(r) {
+		utils.SendJSONResponse(w, http.StatusOK, utils.JSONResponse{
+			Success: true,
+			Message: message,
+			Refresh: true,
+		})
+	} else {
+		// 操作成功后重定向回任务页面
+		http.Redirect(w, r, "/tasks", http.StatusFound)
+	}
+}
@@ -0,0 +1,172 @@
+// ics包解析iCalendar（.ics）文件中的事件，用于导入假期日历
+// 只支持导入假期需要的部分：VEVENT的UID、SUMMARY、DTSTART和DTEND，不展开RRULE
+package ics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// 日期格式，与重复规则中的日期格式一致
+const DateLayout = "2006-01-02"
+
+// 日历事件，Start和End都是日期，End包括在内
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// 文件中没有找到事件
+var ErrNoEvents = errors.New("日历文件中没有找到事件")
+
+// 解析iCalendar文件，返回其中的所有事件
+// 全天事件的DTEND不包括在内，转换后的End为最后一天
+func Parse(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current *Event
+	var endValue string
+	var endParams map[string]string
+	for i, line := range lines {
+		name, params, value := splitLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			current = &Event{}
+			endValue = ""
+			endParams = nil
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if current == nil {
+				return nil, fmt.Errorf("第%d行: END:VEVENT没有对应的BEGIN", i+1)
+			}
+			if current.Start.IsZero() {
+				return nil, fmt.Errorf("第%d行: 事件缺少DTSTART", i+1)
+			}
+			current.End = current.Start
+			if endValue != "" {
+				end, allDay, err := parseDate(endValue, endParams, loc)
+				if err != nil {
+					return nil, fmt.Errorf("第%d行: %w", i+1, err)
+				}
+				// DTEND不包括在内：全天事件和零点结束的事件减去一天
+				if allDay || end.Equal(dayStart(end)) {
+					end = end.AddDate(0, 0, -1)
+				}
+				if !end.Before(current.Start) {
+					current.End = dayStart(end)
+				}
+			}
+			events = append(events, *current)
+			current = nil
+		case current == nil:
+			continue
+		case name == "UID":
+			current.UID = value
+		case name == "SUMMARY":
+			current.Summary = unescape(value)
+		case name == "DTSTART":
+			start, _, err := parseDate(value, params, loc)
+			if err != nil {
+				return nil, fmt.Errorf("第%d行: %w", i+1, err)
+			}
+			current.Start = dayStart(start)
+		case name == "DTEND":
+			endValue = value
+			endParams = params
+		}
+	}
+
+	if len(events) == 0 {
+		return nil, ErrNoEvents
+	}
+	return events, nil
+}
+
+// 读取所有行并合并折叠的行（以空格或制表符开头的行是上一行的延续）
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// 拆分属性行，例如 DTSTART;VALUE=DATE:20240101
+func splitLine(line string) (name string, params map[string]string, value string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	name = strings.ToUpper(parts[0])
+	params = make(map[string]string)
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return name, params, value
+}
+
+// 解析DTSTART或DTEND的值，返回时间以及是否为全天日期
+func parseDate(value string, params map[string]string, loc *time.Location) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return t, false, fmt.Errorf("日期格式错误: %s", value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return t, false, fmt.Errorf("时间格式错误: %s", value)
+		}
+		return t.In(loc), false, nil
+	}
+
+	// 带TZID的时间按指定时区解析，找不到时区时使用本地时区
+	zone := loc
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			zone = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, zone)
+	if err != nil {
+		return t, false, fmt.Errorf("时间格式错误: %s", value)
+	}
+	return t.In(loc), false, nil
+}
+
+// 当天零点
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// 还原文本中转义的字符
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
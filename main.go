@@ -6,15 +6,15 @@ import (
 
 	"minecraft-exchange/handlers"
 	"minecraft-exchange/models"
-	"minecraft-exchange/utils"
+	"minecraft-exchange/scheduler"
 )
 
 func main() {
 	// 初始化数据库
 	models.InitDB()
 
-	// 启动日常任务自动刷新机制
-	utils.StartDailyTaskRefresh()
+	// 启动定时任务，补跑停机期间错过的日常任务刷新
+	scheduler.Default.StartJobs()
 
 	// 设置静态文件服务
 	fs := http.FileServer(http.Dir("static"))
@@ -26,22 +26,64 @@ func main() {
 	http.HandleFunc("/tasks_data", handlers.GetTasksDataHandler)
 	http.HandleFunc("/claim_task", handlers.ClaimTaskHandler)
 	http.HandleFunc("/complete_task", handlers.CompleteTaskHandler)
+	http.HandleFunc("/check_checklist_item", handlers.CheckChecklistItemHandler)
+	http.HandleFunc("/task_timer", handlers.TaskTimerHandler)
 	http.HandleFunc("/verify_task", handlers.VerifyTaskHandler)
+	http.HandleFunc("/reject_task", handlers.RejectTaskHandler)
+	http.HandleFunc("/task_history", handlers.TaskHistoryHandler)
+	http.HandleFunc("/evidence", handlers.EvidenceHandler)
+	http.HandleFunc("/preview_recurrence", handlers.PreviewRecurrenceHandler)
 	http.HandleFunc("/shop", handlers.ShopHandler)
 	http.HandleFunc("/shop_data", handlers.GetShopDataHandler)
 	http.HandleFunc("/exchange", handlers.ExchangeHandler)
 	http.HandleFunc("/exchange_reward", handlers.ExchangeRewardHandler)
+	http.HandleFunc("/approve_exchange", handlers.ApproveExchangeHandler)
+	http.HandleFunc("/reject_exchange", handlers.RejectExchangeHandler)
+	http.HandleFunc("/refund_exchange", handlers.RefundExchangeHandler)
+	http.HandleFunc("/cancel_exchange", handlers.CancelExchangeHandler)
+	http.HandleFunc("/select_player", handlers.SelectPlayerHandler)
+	http.HandleFunc("/statement", handlers.StatementHandler)
+	http.HandleFunc("/leaderboard", handlers.LeaderboardHandler)
+	http.HandleFunc("/leaderboard_data", handlers.GetLeaderboardDataHandler)
+	http.HandleFunc("/create_player", handlers.CreatePlayerHandler)
+	http.HandleFunc("/update_player", handlers.UpdatePlayerHandler)
+	http.HandleFunc("/delete_player", handlers.DeletePlayerHandler)
 	http.HandleFunc("/login", handlers.LoginHandler)
+	http.HandleFunc("/logout", handlers.LogoutHandler)
+	http.HandleFunc("/setup", handlers.SetupHandler)
+	http.HandleFunc("/change_password", handlers.ChangePasswordHandler)
+	http.HandleFunc("/create_admin", handlers.CreateAdminHandler)
+	http.HandleFunc("/delete_admin", handlers.DeleteAdminHandler)
+	http.HandleFunc("/revoke_session", handlers.RevokeSessionHandler)
 	http.HandleFunc("/admin", handlers.AdminHandler)
 	http.HandleFunc("/admin_data", handlers.GetAdminDataHandler)
 	http.HandleFunc("/test_create_task", handlers.TestCreateTaskHandler)
 	http.HandleFunc("/delete_task", handlers.DeleteTaskHandler)
 	http.HandleFunc("/delete_task_template", handlers.DeleteTaskTemplateHandler)
 	http.HandleFunc("/create_task", handlers.CreateTaskHandler)
+	http.HandleFunc("/update_task_template", handlers.UpdateTaskTemplateHandler)
+	http.HandleFunc("/task_template_history", handlers.TaskTemplateHistoryHandler)
+	http.HandleFunc("/set_task_template_status", handlers.SetTaskTemplateStatusHandler)
+	http.HandleFunc("/blackout_dates", handlers.BlackoutDatesHandler)
+	http.HandleFunc("/create_blackout_date", handlers.CreateBlackoutDateHandler)
+	http.HandleFunc("/delete_blackout_date", handlers.DeleteBlackoutDateHandler)
+	http.HandleFunc("/import_blackout_dates", handlers.ImportBlackoutDatesHandler)
+	http.HandleFunc("/quests", handlers.QuestsHandler)
+	http.HandleFunc("/create_quest", handlers.CreateQuestHandler)
+	http.HandleFunc("/delete_quest", handlers.DeleteQuestHandler)
+	http.HandleFunc("/streaks", handlers.StreaksHandler)
+	http.HandleFunc("/save_streak_milestone", handlers.SaveStreakMilestoneHandler)
+	http.HandleFunc("/achievements", handlers.AchievementsHandler)
+	http.HandleFunc("/create_achievement", handlers.CreateAchievementHandler)
+	http.HandleFunc("/update_achievement_reward", handlers.UpdateAchievementRewardHandler)
+	http.HandleFunc("/delete_achievement", handlers.DeleteAchievementHandler)
+	http.HandleFunc("/settings", handlers.SettingsHandler)
+	http.HandleFunc("/save_settings", handlers.SaveSettingsHandler)
 	http.HandleFunc("/create_item", handlers.CreateItemHandler)
 	http.HandleFunc("/update_item", handlers.UpdateItemHandler)
 	http.HandleFunc("/delete_item", handlers.DeleteItemHandler)
 	http.HandleFunc("/refresh_daily_tasks", handlers.RefreshDailyTasksHandler)
+	http.HandleFunc("/jobs", handlers.JobsHandler)
 
 	// 启动HTTP服务器
 	log.Println("服务器启动在 http://localhost:8080")
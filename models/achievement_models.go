@@ -0,0 +1,346 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// 内置成就不能删除，启动时会重新补充
+var ErrBuiltinAchievement = errors.New("内置成就不能删除，可以把奖励设为0")
+
+// 成就的解锁规则，玩家对应的统计值达到门槛时解锁
+const (
+	AchievementTasksVerified     = "tasks_verified"      // 确认完成的任务数量
+	AchievementHardTasksVerified = "hard_tasks_verified" // 确认完成的困难任务数量
+	AchievementEmeraldsEarned    = "emeralds_earned"     // 通过任务累计获得的绿宝石
+	AchievementPurchases         = "purchases"           // 兑换物品的次数，不包括等待批准、被拒绝、取消和退款的兑换
+	AchievementQuestsCompleted   = "quests_completed"    // 完成的任务线数量
+)
+
+// 成就规则的说明，用于管理页面选择规则
+var AchievementRules = []struct {
+	Rule string
+	Name string
+}{
+	{AchievementTasksVerified, "完成任务数量"},
+	{AchievementHardTasksVerified, "完成困难任务数量"},
+	{AchievementEmeraldsEarned, "通过任务累计获得绿宝石"},
+	{AchievementPurchases, "兑换物品次数"},
+	{AchievementQuestsCompleted, "完成任务线数量"},
+}
+
+// 内置的成就，以code区分，启动时补充缺少的成就
+var defaultAchievements = []Achievement{
+	{Code: "first_task", Title: "石器时代", Description: "第一次完成任务并经家长确认", Icon: "🪨", Rule: AchievementTasksVerified, Threshold: 1, Reward: 2},
+	{Code: "emeralds_100", Title: "绿宝石收藏家", Description: "通过任务累计获得100绿宝石", Icon: "💎", Rule: AchievementEmeraldsEarned, Threshold: 100, Reward: 10},
+	{Code: "hard_tasks_10", Title: "钻石镐", Description: "完成10个困难任务", Icon: "⛏️", Rule: AchievementHardTasksVerified, Threshold: 10, Reward: 15},
+	{Code: "first_purchase", Title: "成交！", Description: "第一次在商店兑换物品", Icon: "🛒", Rule: AchievementPurchases, Threshold: 1, Reward: 0},
+	{Code: "first_quest", Title: "冒险时间", Description: "完成一条任务线", Icon: "🗺️", Rule: AchievementQuestsCompleted, Threshold: 1, Reward: 5},
+}
+
+// 成就结构体
+type Achievement struct {
+	ID          int
+	Code        string // 内置成就的标识，家长创建的成就为空
+	Title       string
+	Description string
+	Icon        string
+	Rule        string // 解锁规则，见Achievement*常量
+	Threshold   int    // 解锁门槛
+	Reward      int    // 解锁时一次性奖励的绿宝石，0表示没有奖励
+}
+
+// 判断是否为支持的解锁规则
+func IsAchievementRule(rule string) bool {
+	for _, r := range AchievementRules {
+		if r.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// 规则的说明
+func (a Achievement) RuleName() string {
+	for _, rule := range AchievementRules {
+		if rule.Rule == a.Rule {
+			return rule.Name
+		}
+	}
+	return a.Rule
+}
+
+// 玩家的成就，包括尚未解锁的成就
+type PlayerAchievement struct {
+	Achievement
+	UnlockedAt string // 解锁时间，为空表示尚未解锁
+	Progress   int    // 玩家当前的统计值，不超过门槛
+}
+
+// 成就的解锁记录
+type AchievementUnlock struct {
+	AchievementID int
+	PlayerName    string
+	UnlockedAt    string
+}
+
+// 计算玩家各个规则的统计值
+func achievementStats(exec Executor, playerID int) (map[string]int, error) {
+	queries := map[string]string{
+		AchievementTasksVerified:     "SELECT COUNT(*) FROM tasks WHERE player_id = ? AND status = 'verified'",
+		AchievementHardTasksVerified: "SELECT COUNT(*) FROM tasks WHERE player_id = ? AND status = 'verified' AND difficulty = 'hard'",
+		AchievementEmeraldsEarned:    "SELECT COALESCE(SUM(amount), 0) FROM emerald_transactions WHERE player_id = ? AND amount > 0 AND reason IN " + earnedEmeraldReasons,
+		AchievementPurchases:         "SELECT COUNT(*) FROM exchange_records WHERE player_id = ? AND status IN ('" + ExchangeApproved + "', '" + ExchangeFulfilled + "')",
+		AchievementQuestsCompleted:   "SELECT COUNT(*) FROM quest_progress WHERE player_id = ? AND completed_at IS NOT NULL",
+	}
+
+	stats := make(map[string]int)
+	for rule, query := range queries {
+		var value int
+		err := exec.QueryRow(query, playerID).Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		stats[rule] = value
+	}
+	return stats, nil
+}
+
+// 检查并记录玩家新解锁的成就，在确认任务和兑换物品的事务中调用
+// 返回新解锁的成就，奖励由调用方发放
+func UnlockAchievements(exec Executor, playerID int) ([]Achievement, error) {
+	stats, err := achievementStats(exec, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	achievements, err := queryAchievements(exec, `
+		SELECT id, COALESCE(code, ''), title, COALESCE(description, ''), COALESCE(icon, ''), rule, threshold, reward
+		FROM achievements
+		WHERE id NOT IN (SELECT achievement_id FROM player_achievements WHERE player_id = ?)
+		ORDER BY id
+	`, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	var unlocked []Achievement
+	for _, achievement := range achievements {
+		if stats[achievement.Rule] < achievement.Threshold {
+			continue
+		}
+		_, err := exec.Exec("INSERT INTO player_achievements (player_id, achievement_id, unlocked_at, reward) VALUES (?, ?, ?, ?)", playerID, achievement.ID, localTime, achievement.Reward)
+		if err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, achievement)
+	}
+	return unlocked, nil
+}
+
+// 兑换被取消或退款后，撤销兑换次数已经不满足门槛的成就，并收回解锁时发放的奖励
+// 玩家的绿宝石不够时只收回剩余的部分，在取消或退款的事务中调用
+// 返回被撤销的成就
+func RevokePurchaseAchievements(exec Executor, playerID int, actor string) ([]Achievement, error) {
+	stats, err := achievementStats(exec, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := exec.Query(`
+		SELECT a.id, a.title, p.reward
+		FROM player_achievements p
+		JOIN achievements a ON a.id = p.achievement_id
+		WHERE p.player_id = ? AND a.rule = ? AND a.threshold > ?
+		ORDER BY a.id
+	`, playerID, AchievementPurchases, stats[AchievementPurchases])
+	if err != nil {
+		return nil, err
+	}
+	var revoked []Achievement
+	for rows.Next() {
+		var achievement Achievement
+		err := rows.Scan(&achievement.ID, &achievement.Title, &achievement.Reward)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		revoked = append(revoked, achievement)
+	}
+	rows.Close()
+
+	for _, achievement := range revoked {
+		_, err := exec.Exec("DELETE FROM player_achievements WHERE player_id = ? AND achievement_id = ?", playerID, achievement.ID)
+		if err != nil {
+			return nil, err
+		}
+		if achievement.Reward <= 0 {
+			continue
+		}
+
+		var emeralds int
+		err = exec.QueryRow("SELECT emeralds FROM players WHERE id = ?", playerID).Scan(&emeralds)
+		if err != nil {
+			return nil, err
+		}
+		amount := achievement.Reward
+		if amount > emeralds {
+			amount = emeralds
+		}
+		if amount <= 0 {
+			continue
+		}
+		_, err = AddEmeraldTransaction(exec, playerID, -amount, ReasonAchievement, nil, nil, actor, "撤销成就："+achievement.Title)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return revoked, nil
+}
+
+// 获取玩家的所有成就和进度，已解锁的成就排在前面
+func GetPlayerAchievements(playerID int) ([]PlayerAchievement, error) {
+	stats, err := achievementStats(DB, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT a.id, COALESCE(a.code, ''), a.title, COALESCE(a.description, ''), COALESCE(a.icon, ''), a.rule, a.threshold, a.reward, COALESCE(p.unlocked_at, '')
+		FROM achievements a
+		LEFT JOIN player_achievements p ON p.achievement_id = a.id AND p.player_id = ?
+		ORDER BY p.unlocked_at IS NULL, p.unlocked_at, a.id
+	`, playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var achievements []PlayerAchievement
+	for rows.Next() {
+		var a PlayerAchievement
+		err := rows.Scan(&a.ID, &a.Code, &a.Title, &a.Description, &a.Icon, &a.Rule, &a.Threshold, &a.Reward, &a.UnlockedAt)
+		if err != nil {
+			log.Println("扫描成就数据失败:", err)
+			continue
+		}
+		a.Progress = stats[a.Rule]
+		if a.Progress > a.Threshold {
+			a.Progress = a.Threshold
+		}
+		achievements = append(achievements, a)
+	}
+	return achievements, nil
+}
+
+// 获取所有成就
+func GetAllAchievements() ([]Achievement, error) {
+	return queryAchievements(DB, "SELECT id, COALESCE(code, ''), title, COALESCE(description, ''), COALESCE(icon, ''), rule, threshold, reward FROM achievements ORDER BY id")
+}
+
+// 获取所有成就的解锁记录，按成就ID分组
+func GetAllAchievementUnlocks() (map[int][]AchievementUnlock, error) {
+	rows, err := DB.Query(`
+		SELECT pa.achievement_id, p.name, pa.unlocked_at
+		FROM player_achievements pa
+		JOIN players p ON pa.player_id = p.id
+		ORDER BY pa.unlocked_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unlocks := make(map[int][]AchievementUnlock)
+	for rows.Next() {
+		var unlock AchievementUnlock
+		err := rows.Scan(&unlock.AchievementID, &unlock.PlayerName, &unlock.UnlockedAt)
+		if err != nil {
+			log.Println("扫描成就解锁记录失败:", err)
+			continue
+		}
+		unlocks[unlock.AchievementID] = append(unlocks[unlock.AchievementID], unlock)
+	}
+	return unlocks, nil
+}
+
+// 创建成就，已经满足条件的玩家在下次确认任务或兑换物品时解锁
+func CreateAchievement(achievement Achievement) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec(
+		"INSERT INTO achievements (title, description, icon, rule, threshold, reward, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		achievement.Title, achievement.Description, achievement.Icon, achievement.Rule, achievement.Threshold, achievement.Reward, localTime,
+	)
+	return err
+}
+
+// 修改成就的奖励，只影响之后解锁的玩家
+func UpdateAchievementReward(achievementID, reward int) error {
+	_, err := DB.Exec("UPDATE achievements SET reward = ? WHERE id = ?", reward, achievementID)
+	return err
+}
+
+// 删除家长创建的成就和玩家的解锁记录，已发放的奖励保留在流水中
+func DeleteAchievement(achievementID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var code string
+	err = tx.QueryRow("SELECT COALESCE(code, '') FROM achievements WHERE id = ?", achievementID).Scan(&code)
+	if err != nil {
+		return err
+	}
+	if code != "" {
+		return ErrBuiltinAchievement
+	}
+
+	_, err = tx.Exec("DELETE FROM player_achievements WHERE achievement_id = ?", achievementID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM achievements WHERE id = ?", achievementID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 补充缺少的内置成就，已有的成就不会被修改
+func initAchievements() error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	for _, a := range defaultAchievements {
+		_, err := DB.Exec(
+			"INSERT OR IGNORE INTO achievements (code, title, description, icon, rule, threshold, reward, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			a.Code, a.Title, a.Description, a.Icon, a.Rule, a.Threshold, a.Reward, localTime,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryAchievements(exec Executor, query string, args ...interface{}) ([]Achievement, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var achievements []Achievement
+	for rows.Next() {
+		var a Achievement
+		err := rows.Scan(&a.ID, &a.Code, &a.Title, &a.Description, &a.Icon, &a.Rule, &a.Threshold, &a.Reward)
+		if err != nil {
+			log.Println("扫描成就数据失败:", err)
+			continue
+		}
+		achievements = append(achievements, a)
+	}
+	return achievements, nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import "testing"
+
+func TestRevokePurchaseAchievements(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	var achievementID, emeralds int
+	err := DB.QueryRow("SELECT id FROM achievements WHERE rule = ?", AchievementPurchases).Scan(&achievementID)
+	if err != nil {
+		t.Fatal("查询成就失败:", err)
+	}
+	err = DB.QueryRow("SELECT emeralds FROM players WHERE id = ?", playerID).Scan(&emeralds)
+	if err != nil {
+		t.Fatal("查询玩家失败:", err)
+	}
+
+	// 解锁时发放的奖励比玩家现在的绿宝石多，只收回剩余的部分
+	_, err = DB.Exec("INSERT INTO player_achievements (player_id, achievement_id, unlocked_at, reward) VALUES (?, ?, '2024-01-01 00:00:00', ?)", playerID, achievementID, emeralds+10)
+	if err != nil {
+		t.Fatal("保存成就失败:", err)
+	}
+
+	revoked, err := RevokePurchaseAchievements(DB, playerID, "test")
+	if err != nil {
+		t.Fatal("撤销成就失败:", err)
+	}
+	if len(revoked) != 1 || revoked[0].ID != achievementID {
+		t.Errorf("撤销的成就为 %v，期望成就 %d", revoked, achievementID)
+	}
+	var balance, unlocked int
+	err = DB.QueryRow("SELECT emeralds, (SELECT COUNT(*) FROM player_achievements WHERE player_id = players.id) FROM players WHERE id = ?", playerID).Scan(&balance, &unlocked)
+	if err != nil {
+		t.Fatal("查询玩家失败:", err)
+	}
+	if balance != 0 || unlocked != 0 {
+		t.Errorf("绿宝石为 %d，已解锁 %d 个成就，期望都为 0", balance, unlocked)
+	}
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"log"
+)
+
+// 任务对玩家可见的查询条件：没有指派玩家的任务所有人可见，否则只有被指派的玩家可见
+// 使用时需要传入一个玩家ID参数，查询中的任务表不能使用别名
+const taskVisibleToPlayer = "(NOT EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = tasks.id) OR EXISTS (SELECT 1 FROM task_assignees a WHERE a.task_id = tasks.id AND a.player_id = ?))"
+
+// 设置任务模板指派的玩家，playerIDs为空表示所有玩家
+func SetTemplateAssignees(exec Executor, templateID int, playerIDs []int) error {
+	_, err := exec.Exec("DELETE FROM template_assignees WHERE template_id = ?", templateID)
+	if err != nil {
+		return err
+	}
+	for _, playerID := range playerIDs {
+		_, err := exec.Exec("INSERT OR IGNORE INTO template_assignees (template_id, player_id) VALUES (?, ?)", templateID, playerID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取任务模板指派的玩家ID
+func GetTemplateAssignees(templateID int) ([]int, error) {
+	rows, err := DB.Query("SELECT player_id FROM template_assignees WHERE template_id = ? ORDER BY player_id", templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playerIDs []int
+	for rows.Next() {
+		var playerID int
+		if err := rows.Scan(&playerID); err != nil {
+			return nil, err
+		}
+		playerIDs = append(playerIDs, playerID)
+	}
+	return playerIDs, rows.Err()
+}
+
+// 获取所有任务模板指派的玩家，按模板ID分组，用于管理页面展示
+func GetAllTemplateAssignees() (map[int][]Player, error) {
+	return queryAssignees("SELECT a.template_id, p.id, p.name FROM template_assignees a JOIN players p ON a.player_id = p.id ORDER BY p.id")
+}
+
+// 获取所有任务指派的玩家，按任务ID分组，用于管理页面展示
+func GetAllTaskAssignees() (map[int][]Player, error) {
+	return queryAssignees("SELECT a.task_id, p.id, p.name FROM task_assignees a JOIN players p ON a.player_id = p.id ORDER BY p.id")
+}
+
+// 判断玩家是否可以看到和领取任务
+func IsTaskVisibleToPlayer(taskID int, playerID int) (bool, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE id = ? AND "+taskVisibleToPlayer, taskID, playerID).Scan(&count)
+	return count > 0, err
+}
+
+func queryAssignees(query string) (map[int][]Player, error) {
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignees := make(map[int][]Player)
+	for rows.Next() {
+		var id int
+		var player Player
+		err := rows.Scan(&id, &player.ID, &player.Name)
+		if err != nil {
+			log.Println("扫描指派玩家数据失败:", err)
+			continue
+		}
+		assignees[id] = append(assignees[id], player)
+	}
+	return assignees, nil
+}
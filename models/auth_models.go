@@ -0,0 +1,294 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 家长（管理员）账号结构体
+type Admin struct {
+	ID        int
+	Username  string
+	CreatedAt string
+}
+
+// 管理员会话结构体
+type Session struct {
+	ID            int
+	AdminID       int
+	AdminUsername string
+	CreatedAt     string
+	ExpiresAt     string
+	LastSeenAt    string
+	UserAgent     string
+	IPAddress     string
+}
+
+// 密码最短长度
+const MinPasswordLength = 6
+
+var (
+	// 用户名或密码错误
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	// 密码太短
+	ErrPasswordTooShort = errors.New("密码长度不能少于6位")
+	// 不能删除最后一个管理员账号
+	ErrLastAdmin = errors.New("至少需要保留一个管理员账号")
+)
+
+// 根据环境变量ADMIN_USERNAME和ADMIN_PASSWORD创建第一个管理员账号
+// 仅在数据库中还没有任何管理员账号时生效
+func InitAdminFromEnv() {
+	username := os.Getenv("ADMIN_USERNAME")
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return
+	}
+	if username == "" {
+		username = "admin"
+	}
+
+	count, err := CountAdmins()
+	if err != nil {
+		log.Fatal("查询管理员账号失败:", err)
+	}
+	if count > 0 {
+		return
+	}
+
+	_, err = CreateAdmin(username, password)
+	if err != nil {
+		log.Fatal("创建管理员账号失败:", err)
+	}
+	log.Printf("已根据环境变量创建管理员账号: %s", username)
+}
+
+// 获取管理员账号数量
+func CountAdmins() (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM admins").Scan(&count)
+	return count, err
+}
+
+// 计算密码的bcrypt哈希
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// 创建管理员账号
+func CreateAdmin(username, password string) (int64, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := DB.Exec(
+		"INSERT INTO admins (username, password_hash, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		username, hash, localTime, localTime,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// 验证用户名和密码，成功时返回管理员账号
+func AuthenticateAdmin(username, password string) (Admin, error) {
+	var admin Admin
+	var hash string
+	err := DB.QueryRow("SELECT id, username, created_at, password_hash FROM admins WHERE username = ?", username).Scan(&admin.ID, &admin.Username, &admin.CreatedAt, &hash)
+	if err == sql.ErrNoRows {
+		return admin, ErrInvalidCredentials
+	}
+	if err != nil {
+		return admin, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return admin, ErrInvalidCredentials
+	}
+	return admin, nil
+}
+
+// 修改管理员密码，需要验证旧密码
+func ChangeAdminPassword(adminID int, oldPassword, newPassword string) error {
+	var hash string
+	err := DB.QueryRow("SELECT password_hash FROM admins WHERE id = ?", adminID).Scan(&hash)
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(oldPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	newHash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err = DB.Exec("UPDATE admins SET password_hash = ?, updated_at = ? WHERE id = ?", newHash, localTime, adminID)
+	return err
+}
+
+// 获取所有管理员账号
+func GetAllAdmins() ([]Admin, error) {
+	rows, err := DB.Query("SELECT id, username, created_at FROM admins ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var admins []Admin
+	for rows.Next() {
+		var admin Admin
+		err := rows.Scan(&admin.ID, &admin.Username, &admin.CreatedAt)
+		if err != nil {
+			log.Println("扫描管理员数据失败:", err)
+			continue
+		}
+		admins = append(admins, admin)
+	}
+	return admins, nil
+}
+
+// 删除管理员账号及其所有会话
+func DeleteAdmin(adminID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM admins").Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastAdmin
+	}
+
+	_, err = tx.Exec("DELETE FROM sessions WHERE admin_id = ?", adminID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM admins WHERE id = ?", adminID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// 计算会话token的哈希值，数据库中不保存明文token
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 创建管理员会话
+func CreateSession(token string, adminID int, expiresAt time.Time, userAgent, ipAddress string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec(
+		"INSERT INTO sessions (token_hash, admin_id, created_at, expires_at, last_seen_at, user_agent, ip_address) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		hashSessionToken(token), adminID, localTime, expiresAt.Format("2006-01-02 15:04:05"), localTime, userAgent, ipAddress,
+	)
+	return err
+}
+
+// 验证会话token是否存在、未过期且属于现有管理员账号，有效时更新最后访问时间
+func ValidateSession(token string) (bool, error) {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := DB.Exec("UPDATE sessions SET last_seen_at = ? WHERE token_hash = ? AND expires_at > ? AND admin_id IN (SELECT id FROM admins)", localTime, hashSessionToken(token), localTime)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// 根据token获取会话信息
+func GetSessionByToken(token string) (Session, error) {
+	var session Session
+	err := DB.QueryRow(`
+		SELECT s.id, s.admin_id, a.username, s.created_at, s.expires_at, s.last_seen_at
+		FROM sessions s
+		JOIN admins a ON s.admin_id = a.id
+		WHERE s.token_hash = ?
+	`, hashSessionToken(token)).Scan(&session.ID, &session.AdminID, &session.AdminUsername, &session.CreatedAt, &session.ExpiresAt, &session.LastSeenAt)
+	return session, err
+}
+
+// 根据token删除会话（退出登录）
+func DeleteSessionByToken(token string) error {
+	_, err := DB.Exec("DELETE FROM sessions WHERE token_hash = ?", hashSessionToken(token))
+	return err
+}
+
+// 根据ID删除会话（撤销会话）
+func DeleteSession(sessionID int) error {
+	_, err := DB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
+	return err
+}
+
+// 删除管理员除指定会话外的所有会话，用于修改密码后让其他设备重新登录
+func DeleteOtherSessions(adminID int, keepSessionID int) error {
+	_, err := DB.Exec("DELETE FROM sessions WHERE admin_id = ? AND id != ?", adminID, keepSessionID)
+	return err
+}
+
+// 删除所有已过期的会话
+func DeleteExpiredSessions() error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec("DELETE FROM sessions WHERE expires_at <= ?", localTime)
+	return err
+}
+
+// 获取所有未过期的会话
+func GetActiveSessions() ([]Session, error) {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	rows, err := DB.Query(`
+		SELECT s.id, s.admin_id, a.username, s.created_at, s.expires_at, s.last_seen_at, COALESCE(s.user_agent, ''), COALESCE(s.ip_address, '')
+		FROM sessions s
+		JOIN admins a ON s.admin_id = a.id
+		WHERE s.expires_at > ?
+		ORDER BY s.last_seen_at DESC
+	`, localTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.AdminID, &session.AdminUsername, &session.CreatedAt, &session.ExpiresAt, &session.LastSeenAt, &session.UserAgent, &session.IPAddress)
+		if err != nil {
+			log.Println("扫描会话数据失败:", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// 假期（停发日期）结构体，日期范围内不生成日常任务
+// TemplateID和PlayerID为空时对所有模板、所有玩家生效
+type BlackoutDate struct {
+	ID           int
+	StartDate    string // 开始日期，格式为2006-01-02
+	EndDate      string // 结束日期，包括这一天
+	TemplateID   *int   // 只对指定模板生效
+	PlayerID     *int   // 只对指定玩家生效
+	Reason       string
+	Source       string // manual（手动添加）或 ics（从日历导入）
+	SourceUID    string // 日历事件的UID，用于避免重复导入
+	CreatedAt    string
+	TemplateName string // 关联模板的标题，用于页面展示
+	PlayerName   string // 关联玩家的名称，用于页面展示
+}
+
+// 判断假期是否覆盖指定模板在某一天的任务，day格式为2006-01-02
+func (b BlackoutDate) Covers(templateID *int, day string) bool {
+	if day < b.StartDate || day > b.EndDate {
+		return false
+	}
+	if b.TemplateID == nil {
+		return true
+	}
+	return templateID != nil && *templateID == *b.TemplateID
+}
+
+// 添加假期，来自日历的假期UID已存在时不会重复添加，返回false
+func CreateBlackoutDate(exec Executor, blackout BlackoutDate) (bool, error) {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	var uid interface{}
+	if blackout.SourceUID != "" {
+		uid = blackout.SourceUID
+	}
+	result, err := exec.Exec(
+		"INSERT OR IGNORE INTO blackout_dates (start_date, end_date, template_id, player_id, reason, source, source_uid, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		blackout.StartDate, blackout.EndDate, blackout.TemplateID, blackout.PlayerID, blackout.Reason, blackout.Source, uid, localTime,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// 删除假期
+func DeleteBlackoutDate(blackoutID int) error {
+	_, err := DB.Exec("DELETE FROM blackout_dates WHERE id = ?", blackoutID)
+	return err
+}
+
+// 获取所有假期，按开始日期排序
+func GetAllBlackoutDates() ([]BlackoutDate, error) {
+	return queryBlackoutDates(`
+		SELECT b.id, b.start_date, b.end_date, b.template_id, b.player_id, COALESCE(b.reason, ''), b.source, COALESCE(b.source_uid, ''), b.created_at, COALESCE(t.title, ''), COALESCE(p.name, '')
+		FROM blackout_dates b
+		LEFT JOIN task_templates t ON b.template_id = t.id
+		LEFT JOIN players p ON b.player_id = p.id
+		ORDER BY b.start_date DESC, b.id DESC
+	`)
+}
+
+// 获取对某个玩家生效的假期（只包括指定了该玩家的假期）
+func GetPlayerBlackoutDates(playerID int) ([]BlackoutDate, error) {
+	return queryBlackoutDates(`
+		SELECT id, start_date, end_date, template_id, player_id, COALESCE(reason, ''), source, COALESCE(source_uid, ''), created_at, '', ''
+		FROM blackout_dates
+		WHERE player_id = ?
+		ORDER BY start_date
+	`, playerID)
+}
+
+// 判断模板在某一天是否处于对所有玩家生效的假期中，day格式为2006-01-02
+func IsTemplateBlackedOut(templateID int, day string) (bool, error) {
+	var count int
+	err := DB.QueryRow(
+		"SELECT COUNT(*) FROM blackout_dates WHERE player_id IS NULL AND start_date <= ? AND end_date >= ? AND (template_id IS NULL OR template_id = ?)",
+		day, day, templateID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// 判断模板在某一天是否处于指定玩家的假期中，只检查指定了该玩家的假期
+func IsPlayerBlackedOut(templateID int, playerID int, day string) (bool, error) {
+	var count int
+	err := DB.QueryRow(
+		"SELECT COUNT(*) FROM blackout_dates WHERE player_id = ? AND start_date <= ? AND end_date >= ? AND (template_id IS NULL OR template_id = ?)",
+		playerID, day, day, templateID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func queryBlackoutDates(query string, args ...interface{}) ([]BlackoutDate, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blackouts []BlackoutDate
+	for rows.Next() {
+		var b BlackoutDate
+		err := rows.Scan(&b.ID, &b.StartDate, &b.EndDate, &b.TemplateID, &b.PlayerID, &b.Reason, &b.Source, &b.SourceUID, &b.CreatedAt, &b.TemplateName, &b.PlayerName)
+		if err != nil {
+			log.Println("扫描假期数据失败:", err)
+			continue
+		}
+		blackouts = append(blackouts, b)
+	}
+	return blackouts, nil
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	// 清单步骤不存在或不属于该任务
+	ErrChecklistItemNotFound = errors.New("清单步骤不存在")
+	// 任务不是该玩家已领取、尚未提交的状态
+	ErrChecklistLocked = errors.New("只能勾选自己正在进行的任务")
+)
+
+// 清单步骤结构体，模板和任务实例的清单共用
+type ChecklistItem struct {
+	ID        int
+	Position  int
+	Title     string
+	Required  bool   // 是否为必做步骤，必做步骤全部勾选后才能提交任务
+	Checked   bool   // 玩家是否已勾选，只对任务实例的清单有效
+	CheckedAt string // 勾选时间
+}
+
+// 任务或模板的清单，按步骤顺序排列
+type Checklist []ChecklistItem
+
+// 已勾选的步骤数量
+func (c Checklist) CheckedCount() int {
+	count := 0
+	for _, item := range c {
+		if item.Checked {
+			count++
+		}
+	}
+	return count
+}
+
+// 转换为编辑用的文本，每行一个步骤，可选步骤以?开头
+func (c Checklist) Text() string {
+	lines := make([]string, 0, len(c))
+	for _, item := range c {
+		if item.Required {
+			lines = append(lines, item.Title)
+		} else {
+			lines = append(lines, "?"+item.Title)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 解析编辑用的清单文本，每行一个步骤，以?或？开头的为可选步骤，空行会被忽略
+func ParseChecklist(text string) Checklist {
+	var checklist Checklist
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		required := true
+		if strings.HasPrefix(line, "?") || strings.HasPrefix(line, "？") {
+			required = false
+			line = strings.TrimSpace(strings.TrimLeft(line, "?？"))
+		}
+		if line == "" {
+			continue
+		}
+		checklist = append(checklist, ChecklistItem{
+			Position: len(checklist) + 1,
+			Title:    line,
+			Required: required,
+		})
+	}
+	return checklist
+}
+
+// 设置任务模板的清单，只影响之后生成的任务
+func SetTemplateChecklist(exec Executor, templateID int, checklist Checklist) error {
+	_, err := exec.Exec("DELETE FROM template_checklist_items WHERE template_id = ?", templateID)
+	if err != nil {
+		return err
+	}
+	for i, item := range checklist {
+		_, err := exec.Exec(
+			"INSERT INTO template_checklist_items (template_id, position, title, required) VALUES (?, ?, ?, ?)",
+			templateID, i+1, item.Title, item.Required,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 将模板的清单复制到任务实例，任务已有的清单会被替换
+func copyTemplateChecklist(exec Executor, templateID int, taskID int64) error {
+	_, err := exec.Exec("DELETE FROM task_checklist_items WHERE task_id = ?", taskID)
+	if err != nil {
+		return err
+	}
+	_, err = exec.Exec(`
+		INSERT INTO task_checklist_items (task_id, position, title, required)
+		SELECT ?, position, title, required FROM template_checklist_items WHERE template_id = ?
+	`, taskID, templateID)
+	return err
+}
+
+// 获取所有任务模板的清单，按模板ID分组
+func GetAllTemplateChecklists() (map[int]Checklist, error) {
+	return queryChecklists("SELECT template_id, id, position, title, required, 0, '' FROM template_checklist_items ORDER BY template_id, position")
+}
+
+// 获取所有任务的清单，按任务ID分组，用于管理页面展示
+func GetAllTaskChecklists() (map[int]Checklist, error) {
+	return queryChecklists("SELECT task_id, id, position, title, required, checked, COALESCE(checked_at, '') FROM task_checklist_items ORDER BY task_id, position")
+}
+
+// 获取玩家可领取和已领取任务的清单，按任务ID分组
+func GetPlayerTaskChecklists(playerID int) (map[int]Checklist, error) {
+	return queryChecklists(`
+		SELECT c.task_id, c.id, c.position, c.title, c.required, c.checked, COALESCE(c.checked_at, '')
+		FROM task_checklist_items c
+		JOIN tasks t ON c.task_id = t.id
+		WHERE t.status = 'available' OR t.player_id = ?
+		ORDER BY c.task_id, c.position
+	`, playerID)
+}
+
+// 勾选或取消勾选任务清单中的一个步骤，只能修改该玩家已领取、尚未提交的任务
+// 步骤不存在时返回ErrChecklistItemNotFound，任务状态不对时返回ErrChecklistLocked
+func SetChecklistItemChecked(taskID, itemID, playerID int, checked bool) error {
+	var checkedAt interface{}
+	if checked {
+		checkedAt = time.Now().Format("2006-01-02 15:04:05")
+	}
+	result, err := DB.Exec(
+		"UPDATE task_checklist_items SET checked = ?, checked_at = ? WHERE id = ? AND task_id IN (SELECT id FROM tasks WHERE id = ? AND status = 'claimed' AND player_id = ?)",
+		checked, checkedAt, itemID, taskID, playerID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = DB.QueryRow("SELECT EXISTS (SELECT 1 FROM task_checklist_items WHERE id = ? AND task_id = ?)", itemID, taskID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrChecklistItemNotFound
+	}
+	return ErrChecklistLocked
+}
+
+// 统计任务清单中尚未勾选的必做步骤数量
+func CountUncheckedRequiredItems(exec Executor, taskID int) (int, error) {
+	var count int
+	err := exec.QueryRow("SELECT COUNT(*) FROM task_checklist_items WHERE task_id = ? AND required = 1 AND checked = 0", taskID).Scan(&count)
+	return count, err
+}
+
+func queryChecklists(query string, args ...interface{}) (map[int]Checklist, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checklists := make(map[int]Checklist)
+	for rows.Next() {
+		var id int
+		var item ChecklistItem
+		err := rows.Scan(&id, &item.ID, &item.Position, &item.Title, &item.Required, &item.Checked, &item.CheckedAt)
+		if err != nil {
+			log.Println("扫描清单数据失败:", err)
+			continue
+		}
+		checklists[id] = append(checklists[id], item)
+	}
+	return checklists, nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import "testing"
+
+func TestSetChecklistItemChecked(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	var taskID int
+	err := DB.QueryRow("SELECT MIN(id) FROM tasks").Scan(&taskID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+	result, err := DB.Exec("INSERT INTO task_checklist_items (task_id, position, title, required) VALUES (?, 1, '步骤', 1)", taskID)
+	if err != nil {
+		t.Fatal("保存清单失败:", err)
+	}
+	itemID64, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	itemID := int(itemID64)
+
+	tests := []struct {
+		name     string
+		status   string
+		itemID   int
+		playerID int
+		want     error
+	}{
+		{"自己已领取的任务", "claimed", itemID, playerID, nil},
+		{"其他玩家的任务", "claimed", itemID, playerID + 1, ErrChecklistLocked},
+		{"已经提交的任务", "completed", itemID, playerID, ErrChecklistLocked},
+		{"还没有领取的任务", "available", itemID, playerID, ErrChecklistLocked},
+		{"不存在的步骤", "claimed", itemID + 100, playerID, ErrChecklistItemNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DB.Exec("UPDATE tasks SET status = ?, player_id = ? WHERE id = ?", tt.status, playerID, taskID)
+			if err != nil {
+				t.Fatal("修改任务状态失败:", err)
+			}
+			if err := SetChecklistItemChecked(taskID, tt.itemID, tt.playerID, true); err != tt.want {
+				t.Errorf("返回 %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
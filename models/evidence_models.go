@@ -0,0 +1,55 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// 任务完成凭证（照片）结构体
+type TaskEvidence struct {
+	ID          int
+	TaskID      int
+	FileName    string // 原图文件名
+	ThumbName   string // 缩略图文件名
+	ContentType string
+	Size        int
+	CreatedAt   string
+}
+
+// 保存一张任务凭证记录
+func AddTaskEvidence(exec Executor, evidence TaskEvidence) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := exec.Exec(
+		"INSERT INTO task_evidence (task_id, file_name, thumb_name, content_type, size, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		evidence.TaskID, evidence.FileName, evidence.ThumbName, evidence.ContentType, evidence.Size, localTime,
+	)
+	return err
+}
+
+// 根据ID获取任务凭证
+func GetTaskEvidenceByID(evidenceID int) (TaskEvidence, error) {
+	var evidence TaskEvidence
+	err := DB.QueryRow("SELECT id, task_id, file_name, thumb_name, content_type, size, created_at FROM task_evidence WHERE id = ?", evidenceID).Scan(&evidence.ID, &evidence.TaskID, &evidence.FileName, &evidence.ThumbName, &evidence.ContentType, &evidence.Size, &evidence.CreatedAt)
+	return evidence, err
+}
+
+// 获取所有任务凭证，按任务ID分组，用于管理页面展示
+func GetTaskEvidenceByTask() (map[int][]TaskEvidence, error) {
+	rows, err := DB.Query("SELECT id, task_id, file_name, thumb_name, content_type, size, created_at FROM task_evidence ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	evidenceByTask := make(map[int][]TaskEvidence)
+	for rows.Next() {
+		var evidence TaskEvidence
+		err := rows.Scan(&evidence.ID, &evidence.TaskID, &evidence.FileName, &evidence.ThumbName, &evidence.ContentType, &evidence.Size, &evidence.CreatedAt)
+		if err != nil {
+			log.Println("扫描任务凭证数据失败:", err)
+			continue
+		}
+		evidenceByTask[evidence.TaskID] = append(evidenceByTask[evidence.TaskID], evidence)
+	}
+	return evidenceByTask, nil
+}
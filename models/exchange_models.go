@@ -0,0 +1,262 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 兑换记录的状态
+const (
+	ExchangeRequested = "requested" // 已申请，绿宝石冻结中，等待家长批准
+	ExchangeApproved  = "approved"  // 已批准，等待家长发放
+	ExchangeFulfilled = "fulfilled" // 已发放
+	ExchangeRejected  = "rejected"  // 家长拒绝了申请，绿宝石和库存已退回
+	ExchangeRefunded  = "refunded"  // 家长退款，绿宝石和库存已退回
+	ExchangeCancelled = "cancelled" // 玩家在发放前自行取消，绿宝石和库存已退回；玩家被删除时也会取消，只退回库存
+)
+
+// 兑换记录的状态已经变化，不能再执行该操作
+var ErrExchangeStatus = errors.New("兑换记录的状态已经变化，请刷新后重试")
+
+// 已经超过了玩家可以自行取消兑换的时间
+var ErrCancelWindow = errors.New("已经超过可以取消兑换的时间，请联系家长退款")
+
+// 兑换状态的说明
+func (r ExchangeRecord) StatusName() string {
+	switch r.Status {
+	case ExchangeRequested:
+		return "等待批准"
+	case ExchangeApproved:
+		return "等待发放"
+	case ExchangeFulfilled:
+		return "已发放"
+	case ExchangeRejected:
+		return "已拒绝"
+	case ExchangeRefunded:
+		return "已退款"
+	case ExchangeCancelled:
+		return "已取消"
+	}
+	return r.Status
+}
+
+// 查询兑换记录，condition为WHERE和ORDER BY子句，表别名为er
+// 物品被删除后仍然保留兑换记录
+func queryExchangeRecords(exec Executor, condition string, args ...interface{}) ([]ExchangeRecord, error) {
+	rows, err := exec.Query(`
+		SELECT er.id, er.player_id, COALESCE(p.name, ''), er.item_id, COALESCE(i.name, '已删除的物品'), er.cost, er.timestamp, er.exchanged, er.status, COALESCE(er.updated_at, ''), COALESCE(er.status_reason, '')
+		FROM exchange_records er
+		LEFT JOIN items i ON er.item_id = i.id
+		LEFT JOIN players p ON er.player_id = p.id
+		`+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ExchangeRecord
+	for rows.Next() {
+		var record ExchangeRecord
+		err := rows.Scan(&record.ID, &record.PlayerID, &record.PlayerName, &record.ItemID, &record.ItemName, &record.Cost, &record.Timestamp, &record.Exchanged, &record.Status, &record.UpdatedAt, &record.StatusReason)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// 获取玩家最近的兑换记录
+func GetPlayerExchangeRecords(playerID int, limit int) ([]ExchangeRecord, error) {
+	return queryExchangeRecords(DB, "WHERE er.player_id = ? ORDER BY er.timestamp DESC, er.id DESC LIMIT ?", playerID, limit)
+}
+
+// 获取一条兑换记录
+func GetExchangeRecord(exec Executor, exchangeID int) (ExchangeRecord, error) {
+	records, err := queryExchangeRecords(exec, "WHERE er.id = ?", exchangeID)
+	if err != nil {
+		return ExchangeRecord{}, err
+	}
+	if len(records) == 0 {
+		return ExchangeRecord{}, sql.ErrNoRows
+	}
+	return records[0], nil
+}
+
+// 获取玩家等待批准的兑换冻结的绿宝石，已经从余额中扣除
+func GetHeldEmeralds(playerID int) (int, error) {
+	var held int
+	err := DB.QueryRow("SELECT COALESCE(SUM(cost), 0) FROM exchange_records WHERE player_id = ? AND status = ?", playerID, ExchangeRequested).Scan(&held)
+	return held, err
+}
+
+// 修改兑换记录的状态，当前状态不是from时返回ErrExchangeStatus，兑换记录不存在时返回sql.ErrNoRows
+func changeExchangeStatus(exec Executor, exchangeID int, from, to string) error {
+	return changeExchangeStatusWithReason(exec, exchangeID, from, to, "")
+}
+
+// 修改兑换记录的状态并记录原因
+func changeExchangeStatusWithReason(exec Executor, exchangeID int, from, to, reason string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := exec.Exec("UPDATE exchange_records SET status = ?, updated_at = ?, status_reason = ? WHERE id = ? AND status = ?", to, localTime, reason, exchangeID, from)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var exists bool
+		err = exec.QueryRow("SELECT EXISTS (SELECT 1 FROM exchange_records WHERE id = ?)", exchangeID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return ErrExchangeStatus
+	}
+	return nil
+}
+
+// 批准兑换申请，冻结的绿宝石正式扣除，等待家长发放
+func ApproveExchange(exec Executor, exchangeID int) (ExchangeRecord, error) {
+	err := changeExchangeStatus(exec, exchangeID, ExchangeRequested, ExchangeApproved)
+	if err != nil {
+		return ExchangeRecord{}, err
+	}
+	return GetExchangeRecord(exec, exchangeID)
+}
+
+// 拒绝兑换申请，退回冻结的绿宝石并恢复库存
+func RejectExchange(exec Executor, exchangeID int, reason, actor string) (ExchangeRecord, error) {
+	return closeExchange(exec, exchangeID, ExchangeRequested, ExchangeRejected, reason, actor)
+}
+
+// 玩家取消尚未发放的兑换，只能在兑换后window时间内取消
+func CancelExchange(exec Executor, exchangeID, playerID int, window time.Duration, reason, actor string) (ExchangeRecord, error) {
+	record, err := GetExchangeRecord(exec, exchangeID)
+	if err != nil {
+		return ExchangeRecord{}, err
+	}
+	if record.PlayerID != playerID {
+		return ExchangeRecord{}, sql.ErrNoRows
+	}
+	if record.Status != ExchangeRequested && record.Status != ExchangeApproved {
+		return ExchangeRecord{}, ErrExchangeStatus
+	}
+	if !record.CanCancel(window, time.Now()) {
+		return ExchangeRecord{}, ErrCancelWindow
+	}
+	return closeExchange(exec, exchangeID, record.Status, ExchangeCancelled, reason, actor)
+}
+
+// 家长为已批准或已发放的兑换退款
+func RefundExchange(exec Executor, exchangeID int, reason, actor string) (ExchangeRecord, error) {
+	record, err := GetExchangeRecord(exec, exchangeID)
+	if err != nil {
+		return ExchangeRecord{}, err
+	}
+	if record.Status != ExchangeApproved && record.Status != ExchangeFulfilled {
+		return ExchangeRecord{}, ErrExchangeStatus
+	}
+	return closeExchange(exec, exchangeID, record.Status, ExchangeRefunded, reason, actor)
+}
+
+// 判断玩家现在是否还可以取消这次兑换，只有尚未发放的兑换可以取消
+func (r ExchangeRecord) CanCancel(window time.Duration, now time.Time) bool {
+	if window <= 0 || (r.Status != ExchangeRequested && r.Status != ExchangeApproved) {
+		return false
+	}
+	// timestamp列由数据库以UTC时间写入
+	exchangedAt, err := time.Parse(time.RFC3339, r.Timestamp)
+	if err != nil {
+		exchangedAt, err = time.Parse("2006-01-02 15:04:05", r.Timestamp)
+		if err != nil {
+			return false
+		}
+	}
+	return now.Before(exchangedAt.Add(window))
+}
+
+// 结束一次兑换，退回绿宝石和库存并记录原因
+// 这次兑换解锁的兑换次数成就会一起撤销
+func closeExchange(exec Executor, exchangeID int, from, to, reason, actor string) (ExchangeRecord, error) {
+	err := changeExchangeStatusWithReason(exec, exchangeID, from, to, reason)
+	if err != nil {
+		return ExchangeRecord{}, err
+	}
+	record, err := GetExchangeRecord(exec, exchangeID)
+	if err != nil {
+		return ExchangeRecord{}, err
+	}
+	err = returnExchange(exec, record, from, actor)
+	if err != nil {
+		return record, err
+	}
+	_, err = RevokePurchaseAchievements(exec, record.PlayerID, actor)
+	return record, err
+}
+
+// 退回兑换的绿宝石和库存，物品已删除时只退回绿宝石
+// 已发放的保护卡如果还没有用掉会一起收回
+func returnExchange(exec Executor, record ExchangeRecord, from, actor string) error {
+	_, err := exec.Exec("UPDATE items SET stock = stock + 1 WHERE id = ?", record.ItemID)
+	if err != nil {
+		return err
+	}
+	if from == ExchangeFulfilled {
+		_, err = exec.Exec(`
+			UPDATE players SET streak_freezes = streak_freezes - 1
+			WHERE id = ? AND streak_freezes > 0
+				AND EXISTS (SELECT 1 FROM items WHERE id = ? AND effect = ?)
+		`, record.PlayerID, record.ItemID, ItemEffectStreakFreeze)
+		if err != nil {
+			return err
+		}
+	}
+	if record.Cost <= 0 {
+		return nil
+	}
+	note := record.ItemName
+	if record.StatusReason != "" {
+		note += "：" + record.StatusReason
+	}
+	_, err = AddEmeraldTransaction(exec, record.PlayerID, record.Cost, ReasonRefund, nil, &record.ID, actor, note)
+	return err
+}
+
+// 发放已批准的兑换
+func FulfillExchange(exec Executor, exchangeID int) error {
+	err := changeExchangeStatus(exec, exchangeID, ExchangeApproved, ExchangeFulfilled)
+	if err != nil {
+		return err
+	}
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err = exec.Exec("UPDATE exchange_records SET exchanged = 1, exchanged_at = ? WHERE id = ?", localTime, exchangeID)
+	return err
+}
+
+// 为旧版本数据库中的兑换记录补充状态和实际支付的绿宝石
+// 旧记录兑换时已经扣除了绿宝石，已兑换的记录为已发放，其余为等待发放
+func backfillExchangeRecords() error {
+	_, err := DB.Exec(`
+		UPDATE exchange_records SET
+			status = CASE WHEN exchanged THEN '` + ExchangeFulfilled + `' ELSE '` + ExchangeApproved + `' END,
+			updated_at = COALESCE(exchanged_at, timestamp)
+		WHERE status IS NULL
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		UPDATE exchange_records SET cost = COALESCE(
+			(SELECT -amount FROM emerald_transactions t WHERE t.exchange_id = exchange_records.id AND t.reason = '` + ReasonPurchase + `' ORDER BY t.id LIMIT 1),
+			(SELECT cost FROM items WHERE items.id = exchange_records.item_id),
+			0)
+		WHERE cost IS NULL
+	`)
+	return err
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestDeletePlayerCancelsOpenExchanges(t *testing.T) {
+	setupTestDB(t)
+
+	playerID64, err := CreatePlayer("测试玩家", 0, "test")
+	if err != nil {
+		t.Fatal("创建玩家失败:", err)
+	}
+	playerID := int(playerID64)
+	err = CreateItem(Item{Name: "玩具", Cost: 5, Stock: 3})
+	if err != nil {
+		t.Fatal("创建物品失败:", err)
+	}
+	var itemID int
+	err = DB.QueryRow("SELECT MAX(id) FROM items").Scan(&itemID)
+	if err != nil {
+		t.Fatal("查询物品失败:", err)
+	}
+
+	// 两条尚未发放的兑换和一条已发放的兑换，库存已经扣除
+	var exchangeIDs []int
+	for _, status := range []string{ExchangeRequested, ExchangeApproved, ExchangeFulfilled} {
+		id, err := CreateExchangeRecord(DB, playerID, itemID, 5, status)
+		if err != nil {
+			t.Fatal("创建兑换记录失败:", err)
+		}
+		exchangeIDs = append(exchangeIDs, int(id))
+	}
+	_, err = DB.Exec("UPDATE items SET stock = 0 WHERE id = ?", itemID)
+	if err != nil {
+		t.Fatal("修改库存失败:", err)
+	}
+
+	if err := DeletePlayer(playerID); err != nil {
+		t.Fatal("删除玩家失败:", err)
+	}
+
+	var stock int
+	err = DB.QueryRow("SELECT stock FROM items WHERE id = ?", itemID).Scan(&stock)
+	if err != nil {
+		t.Fatal("查询库存失败:", err)
+	}
+	if stock != 2 {
+		t.Errorf("库存为 %d，期望退回到 2", stock)
+	}
+	for i, want := range []string{ExchangeCancelled, ExchangeCancelled, ExchangeFulfilled} {
+		record, err := GetExchangeRecord(DB, exchangeIDs[i])
+		if err != nil {
+			t.Fatal("查询兑换记录失败:", err)
+		}
+		if record.Status != want {
+			t.Errorf("兑换记录 %d 的状态为 %s，期望 %s", record.ID, record.Status, want)
+		}
+	}
+
+	// 已经取消的申请不能再拒绝，也不会给已删除的玩家退款
+	if _, err := RejectExchange(DB, exchangeIDs[0], "", "test"); err != ErrExchangeStatus {
+		t.Errorf("拒绝已取消的兑换返回 %v，期望 %v", err, ErrExchangeStatus)
+	}
+	if _, err := RejectExchange(DB, 9999, "", "test"); err != sql.ErrNoRows {
+		t.Errorf("拒绝不存在的兑换返回 %v，期望 %v", err, sql.ErrNoRows)
+	}
+}
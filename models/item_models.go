@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// 物品还没有到上架时间
+	ErrItemNotYetAvailable = errors.New("物品还没有上架，请到时间后再来兑换")
+	// 物品已经过了下架时间
+	ErrItemExpired = errors.New("物品已经下架，不能再兑换")
+)
+
+// 判断物品在now时是否还没有上架
+// 上下架时间以"2006-01-02 15:04:05"格式的本地时间保存，可以直接按字符串比较
+func (i Item) IsUpcoming(now time.Time) bool {
+	return i.AvailableFrom != "" && i.AvailableFrom > now.Format("2006-01-02 15:04:05")
+}
+
+// 判断物品在now时是否已经下架
+func (i Item) IsExpired(now time.Time) bool {
+	return i.ExpiryTime != "" && i.ExpiryTime <= now.Format("2006-01-02 15:04:05")
+}
+
+// 检查物品在now时是否可以兑换，不检查库存
+func (i Item) CheckAvailable(now time.Time) error {
+	if i.IsUpcoming(now) {
+		return ErrItemNotYetAvailable
+	}
+	if i.IsExpired(now) {
+		return ErrItemExpired
+	}
+	return nil
+}
+
+// 查询物品，condition为WHERE和ORDER BY子句
+func queryItems(condition string, args ...interface{}) ([]Item, error) {
+	rows, err := DB.Query("SELECT id, name, description, cost, stock, COALESCE(expiry_time, ''), COALESCE(available_from, ''), COALESCE(effect, ''), min_level, requires_approval FROM items "+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		err := rows.Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.Stock, &item.ExpiryTime, &item.AvailableFrom, &item.Effect, &item.MinLevel, &item.RequiresApproval)
+		if err != nil {
+			log.Println("扫描物品数据失败:", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 获取商店中现在可以兑换的物品，已售完、已下架和尚未上架的物品不显示
+func GetShopItems(now time.Time) ([]Item, error) {
+	currentTime := now.Format("2006-01-02 15:04:05")
+	return queryItems(`
+		WHERE stock > 0
+			AND COALESCE(available_from, '') <= ?
+			AND (COALESCE(expiry_time, '') = '' OR expiry_time > ?)
+		ORDER BY created_at DESC`, currentTime, currentTime)
+}
+
+// 获取即将上架的物品，按上架时间排列
+// 上架前就已经下架的物品不显示
+func GetUpcomingItems(now time.Time) ([]Item, error) {
+	currentTime := now.Format("2006-01-02 15:04:05")
+	return queryItems(`
+		WHERE stock > 0
+			AND available_from > ?
+			AND (COALESCE(expiry_time, '') = '' OR expiry_time > available_from)
+		ORDER BY available_from ASC`, currentTime)
+}
+
+// 旧版本直接保存了表单中datetime-local格式的过期时间，如"2006-01-02T15:04"
+// 统一转换为"2006-01-02 15:04:05"格式，保证按字符串比较时间的结果正确
+func backfillItemTimes() error {
+	for _, column := range []string{"expiry_time", "available_from"} {
+		_, err := DB.Exec(`
+			UPDATE items SET ` + column + ` = REPLACE(` + column + `, 'T', ' ') || CASE WHEN LENGTH(` + column + `) = 16 THEN ':00' ELSE '' END
+			WHERE ` + column + ` LIKE '____-__-__T%'
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
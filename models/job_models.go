@@ -0,0 +1,71 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// 定时任务运行状态结构体
+type Job struct {
+	Name        string
+	LastRun     string // 最近一次运行的时间
+	LastSuccess string // 最近一次成功运行对应的计划时间
+	LastError   string // 最近一次运行的错误信息，成功后清空
+	NextRun     string // 下一次计划运行的时间
+	UpdatedAt   string
+}
+
+// 根据名称获取定时任务状态，不存在时返回sql.ErrNoRows
+func GetJob(name string) (Job, error) {
+	var job Job
+	err := DB.QueryRow("SELECT name, COALESCE(last_run, ''), COALESCE(last_success, ''), COALESCE(last_error, ''), next_run, updated_at FROM jobs WHERE name = ?", name).Scan(&job.Name, &job.LastRun, &job.LastSuccess, &job.LastError, &job.NextRun, &job.UpdatedAt)
+	return job, err
+}
+
+// 获取所有定时任务状态
+func GetAllJobs() ([]Job, error) {
+	rows, err := DB.Query("SELECT name, COALESCE(last_run, ''), COALESCE(last_success, ''), COALESCE(last_error, ''), next_run, updated_at FROM jobs ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var job Job
+		err := rows.Scan(&job.Name, &job.LastRun, &job.LastSuccess, &job.LastError, &job.NextRun, &job.UpdatedAt)
+		if err != nil {
+			log.Println("扫描定时任务数据失败:", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// 第一次启动时登记定时任务及其下一次运行时间，已存在时不做修改
+func EnsureJob(name string, nextRun string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec("INSERT OR IGNORE INTO jobs (name, next_run, updated_at) VALUES (?, ?, ?)", name, nextRun, localTime)
+	return err
+}
+
+// 记录定时任务成功运行，period为本次运行对应的计划时间
+func RecordJobSuccess(name string, period string, nextRun string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec(
+		"UPDATE jobs SET last_run = ?, last_success = ?, last_error = NULL, next_run = ?, updated_at = ? WHERE name = ?",
+		localTime, period, nextRun, localTime, name,
+	)
+	return err
+}
+
+// 记录定时任务运行失败，下一次运行时间不变，以便稍后重试
+func RecordJobFailure(name string, runErr error) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec(
+		"UPDATE jobs SET last_run = ?, last_error = ?, updated_at = ? WHERE name = ?",
+		localTime, runErr.Error(), localTime, name,
+	)
+	return err
+}
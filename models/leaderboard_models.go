@@ -0,0 +1,313 @@
+package models
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// 排行榜的显示方式，在系统设置中修改
+const (
+	LeaderboardVisible      = "visible"       // 所有玩家一起排名
+	LeaderboardPersonalBest = "personal_best" // 只显示自己的成绩和个人最好成绩，适合年龄较小的孩子
+	LeaderboardHidden       = "hidden"        // 关闭排行榜
+)
+
+// 排行榜的统计周期
+const (
+	PeriodWeek  = "week"  // 本周，从周一开始
+	PeriodMonth = "month" // 本月
+	PeriodAll   = "all"   // 全部时间
+)
+
+// 排行榜的排名指标
+const (
+	MetricEmeralds = "emeralds" // 获得的绿宝石
+	MetricTasks    = "tasks"    // 完成的任务数量
+	MetricStreak   = "streak"   // 最长连续打卡天数
+)
+
+// 排行榜显示方式的说明，用于设置页面选择
+var LeaderboardModes = []struct {
+	Mode string
+	Name string
+}{
+	{LeaderboardVisible, "显示排行榜"},
+	{LeaderboardPersonalBest, "只显示个人最好成绩"},
+	{LeaderboardHidden, "关闭排行榜"},
+}
+
+// 统计周期的说明
+var LeaderboardPeriods = []struct {
+	Period string
+	Name   string
+}{
+	{PeriodWeek, "本周"},
+	{PeriodMonth, "本月"},
+	{PeriodAll, "全部"},
+}
+
+// 排名指标的说明
+var LeaderboardMetrics = []struct {
+	Metric string
+	Name   string
+}{
+	{MetricEmeralds, "获得绿宝石"},
+	{MetricTasks, "完成任务"},
+	{MetricStreak, "连续打卡"},
+}
+
+// 判断是否为支持的显示方式
+func IsLeaderboardMode(mode string) bool {
+	for _, m := range LeaderboardModes {
+		if m.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断是否为支持的统计周期
+func IsLeaderboardPeriod(period string) bool {
+	for _, p := range LeaderboardPeriods {
+		if p.Period == period {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断是否为支持的排名指标
+func IsLeaderboardMetric(metric string) bool {
+	for _, m := range LeaderboardMetrics {
+		if m.Metric == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// 玩家在一个统计周期内的成绩
+type LeaderboardEntry struct {
+	Rank           int // 名次，成绩相同的玩家名次相同
+	PlayerID       int
+	PlayerName     string
+	EmeraldsEarned int // 通过任务、任务线和连续打卡获得的绿宝石，不扣除兑换的花费
+	TasksVerified  int // 经家长确认的任务数量
+	BestStreak     int // 周期内最长的连续打卡天数
+}
+
+// 按指标取成绩
+func (e LeaderboardEntry) Value(metric string) int {
+	switch metric {
+	case MetricTasks:
+		return e.TasksVerified
+	case MetricStreak:
+		return e.BestStreak
+	}
+	return e.EmeraldsEarned
+}
+
+// 玩家的个人成绩，用于只显示个人最好成绩的模式
+type PersonalRecord struct {
+	Week          LeaderboardEntry // 本周
+	Month         LeaderboardEntry // 本月
+	All           LeaderboardEntry // 全部时间
+	BestWeek      LeaderboardEntry // 历史上最好的一周，每个指标分别取最大值
+	BestMonth     LeaderboardEntry // 历史上最好的一个月，每个指标分别取最大值
+	CurrentStreak int              // 当前的连续打卡天数
+}
+
+// 玩家获得绿宝石或完成任务的记录
+type activity struct {
+	at       time.Time
+	emeralds int
+	tasks    int
+}
+
+// 统计周期的开始时间，全部时间返回零值
+func PeriodStart(period string, now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case PeriodWeek:
+		// 以周一作为一周的开始
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	case PeriodMonth:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+// 查询所有玩家获得绿宝石和完成任务的记录，按玩家ID分组
+// 任务的完成时间取家长确认的时间，旧数据没有审核记录时取任务的更新时间
+func queryActivities() (map[int][]activity, error) {
+	queries := []string{
+		"SELECT player_id, created_at, amount, 0 FROM emerald_transactions WHERE amount > 0 AND reason IN " + earnedEmeraldReasons,
+		`SELECT t.player_id, COALESCE(MAX(r.created_at), t.updated_at), 0, 1
+		FROM tasks t
+		LEFT JOIN task_reviews r ON r.task_id = t.id AND r.action = '` + ReviewVerified + `'
+		WHERE t.status = 'verified' AND t.player_id IS NOT NULL
+		GROUP BY t.id`,
+	}
+
+	activities := make(map[int][]activity)
+	for _, query := range queries {
+		rows, err := DB.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var playerID int
+			var at string
+			var a activity
+			if err := rows.Scan(&playerID, &at, &a.emeralds, &a.tasks); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if len(at) > 19 {
+				at = at[:19]
+			}
+			a.at, err = time.ParseInLocation("2006-01-02 15:04:05", at, time.Local)
+			if err != nil {
+				log.Println("解析记录时间失败:", err)
+				continue
+			}
+			activities[playerID] = append(activities[playerID], a)
+		}
+		rows.Close()
+	}
+	return activities, nil
+}
+
+// 计算玩家从start开始的成绩，start为零值时统计全部时间
+func playerEntry(player Player, activities []activity, days map[int][]streakDay, start time.Time) LeaderboardEntry {
+	entry := LeaderboardEntry{PlayerID: player.ID, PlayerName: player.Name}
+	for _, a := range activities {
+		if a.at.Before(start) {
+			continue
+		}
+		entry.EmeraldsEarned += a.emeralds
+		entry.TasksVerified += a.tasks
+	}
+
+	startDay := ""
+	if !start.IsZero() {
+		startDay = start.Format("2006-01-02")
+	}
+	for _, templateDays := range days {
+		var inPeriod []streakDay
+		for _, d := range templateDays {
+			if d.day >= startDay {
+				inPeriod = append(inPeriod, d)
+			}
+		}
+		if _, best := walkStreak(inPeriod); best > entry.BestStreak {
+			entry.BestStreak = best
+		}
+	}
+	return entry
+}
+
+// 获取排行榜，按指标从高到低排列
+func GetLeaderboard(period, metric string, now time.Time) ([]LeaderboardEntry, error) {
+	players, err := GetAllPlayers()
+	if err != nil {
+		return nil, err
+	}
+	activities, err := queryActivities()
+	if err != nil {
+		return nil, err
+	}
+	start := PeriodStart(period, now)
+
+	entries := make([]LeaderboardEntry, 0, len(players))
+	for _, player := range players {
+		days, err := queryStreakDays(DB, player.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, playerEntry(player, activities[player.ID], days, start))
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Value(metric) > entries[j].Value(metric)
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Value(metric) == entries[i-1].Value(metric) {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries, nil
+}
+
+// 获取玩家的个人成绩和历史上最好的一周、一个月
+func GetPersonalRecord(player Player, now time.Time) (PersonalRecord, error) {
+	activities, err := queryActivities()
+	if err != nil {
+		return PersonalRecord{}, err
+	}
+	days, err := queryStreakDays(DB, player.ID, 0)
+	if err != nil {
+		return PersonalRecord{}, err
+	}
+	playerActivities := activities[player.ID]
+
+	record := PersonalRecord{
+		Week:  playerEntry(player, playerActivities, days, PeriodStart(PeriodWeek, now)),
+		Month: playerEntry(player, playerActivities, days, PeriodStart(PeriodMonth, now)),
+		All:   playerEntry(player, playerActivities, days, time.Time{}),
+	}
+	record.BestWeek = bestPeriod(player, playerActivities, days, PeriodWeek)
+	record.BestMonth = bestPeriod(player, playerActivities, days, PeriodMonth)
+	for _, templateDays := range days {
+		if current, _ := walkStreak(templateDays); current > record.CurrentStreak {
+			record.CurrentStreak = current
+		}
+	}
+	return record, nil
+}
+
+// 按周或按月分组，每个指标分别取成绩最好的一个周期
+func bestPeriod(player Player, activities []activity, days map[int][]streakDay, period string) LeaderboardEntry {
+	best := LeaderboardEntry{PlayerID: player.ID, PlayerName: player.Name}
+	totals := make(map[time.Time]*LeaderboardEntry)
+	for _, a := range activities {
+		start := PeriodStart(period, a.at)
+		total, ok := totals[start]
+		if !ok {
+			total = &LeaderboardEntry{}
+			totals[start] = total
+		}
+		total.EmeraldsEarned += a.emeralds
+		total.TasksVerified += a.tasks
+	}
+	for _, total := range totals {
+		if total.EmeraldsEarned > best.EmeraldsEarned {
+			best.EmeraldsEarned = total.EmeraldsEarned
+		}
+		if total.TasksVerified > best.TasksVerified {
+			best.TasksVerified = total.TasksVerified
+		}
+	}
+
+	// 连续打卡按周期分段计算，跨周期的连续打卡从周期开始重新计算
+	for _, templateDays := range days {
+		segment := make(map[string][]streakDay)
+		for _, d := range templateDays {
+			day, err := time.ParseInLocation("2006-01-02", d.day, time.Local)
+			if err != nil {
+				continue
+			}
+			key := PeriodStart(period, day).Format("2006-01-02")
+			segment[key] = append(segment[key], d)
+		}
+		for _, periodDays := range segment {
+			if _, streak := walkStreak(periodDays); streak > best.BestStreak {
+				best.BestStreak = streak
+			}
+		}
+	}
+	return best
+}
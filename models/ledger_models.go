@@ -0,0 +1,172 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// 绿宝石流水的变动原因
+const (
+	ReasonOpeningBalance = "opening_balance" // 启用流水前的原有余额
+	ReasonInitialGrant   = "initial_grant"   // 创建玩家时的初始绿宝石
+	ReasonTaskReward     = "task_reward"     // 任务奖励
+	ReasonPurchase       = "purchase"        // 兑换物品
+	ReasonAdjustment     = "adjustment"      // 对账调整
+	ReasonQuestBonus     = "quest_bonus"     // 完成任务线的额外奖励
+	ReasonStreakBonus    = "streak_bonus"    // 连续打卡达到里程碑的额外奖励
+	ReasonAchievement    = "achievement"     // 解锁成就的一次性奖励
+	ReasonRefund         = "refund"          // 兑换被拒绝或退款时退回的绿宝石
+)
+
+// 通过完成任务获得绿宝石的变动原因，用于SQL的IN条件
+// 成就奖励不计入，避免成就奖励反过来解锁成就
+const earnedEmeraldReasons = "('" + ReasonTaskReward + "', '" + ReasonQuestBonus + "', '" + ReasonStreakBonus + "')"
+
+// 系统自动操作的操作人
+const ActorSystem = "system"
+
+var (
+	// 绿宝石不足
+	ErrInsufficientEmeralds = errors.New("绿宝石不足")
+	// 玩家不存在
+	ErrPlayerNotFound = errors.New("玩家不存在")
+)
+
+// 绿宝石流水结构体
+type EmeraldTransaction struct {
+	ID           int
+	PlayerID     int
+	Amount       int
+	BalanceAfter int
+	Reason       string
+	TaskID       *int
+	ExchangeID   *int
+	Actor        string
+	Note         string
+	CreatedAt    string
+}
+
+// 记录一笔绿宝石流水并同步更新玩家余额
+// amount为正数表示收入，负数表示支出，余额不足时返回ErrInsufficientEmeralds，玩家不存在时返回ErrPlayerNotFound
+// 余额更新和流水写入需要保持一致，调用方应传入事务
+func AddEmeraldTransaction(tx Executor, playerID, amount int, reason string, taskID, exchangeID *int, actor, note string) (int64, error) {
+	result, err := tx.Exec("UPDATE players SET emeralds = emeralds + ? WHERE id = ? AND emeralds + ? >= 0", amount, playerID, amount)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM players WHERE id = ?)", playerID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, ErrPlayerNotFound
+		}
+		return 0, ErrInsufficientEmeralds
+	}
+
+	var balance int
+	err = tx.QueryRow("SELECT emeralds FROM players WHERE id = ?", playerID).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err = tx.Exec(
+		"INSERT INTO emerald_transactions (player_id, amount, balance_after, reason, task_id, exchange_id, actor, note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		playerID, amount, balance, reason, taskID, exchangeID, actor, note, localTime,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// 获取玩家的绿宝石流水，按时间倒序
+func GetPlayerEmeraldTransactions(playerID int) ([]EmeraldTransaction, error) {
+	rows, err := DB.Query("SELECT id, player_id, amount, balance_after, reason, task_id, exchange_id, COALESCE(actor, ''), COALESCE(note, ''), created_at FROM emerald_transactions WHERE player_id = ? ORDER BY id DESC", playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []EmeraldTransaction
+	for rows.Next() {
+		var t EmeraldTransaction
+		var taskID, exchangeID sql.NullInt64
+		err := rows.Scan(&t.ID, &t.PlayerID, &t.Amount, &t.BalanceAfter, &t.Reason, &taskID, &exchangeID, &t.Actor, &t.Note, &t.CreatedAt)
+		if err != nil {
+			log.Println("扫描绿宝石流水数据失败:", err)
+			continue
+		}
+		if taskID.Valid {
+			id := int(taskID.Int64)
+			t.TaskID = &id
+		}
+		if exchangeID.Valid {
+			id := int(exchangeID.Int64)
+			t.ExchangeID = &id
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, nil
+}
+
+// 核对玩家余额与流水合计，不一致时补记一笔调整流水
+// 旧版本数据库中没有任何流水的玩家，其现有余额记为期初余额
+func ReconcileEmeraldBalances() error {
+	rows, err := DB.Query(`
+		SELECT p.id, p.emeralds, COALESCE(SUM(t.amount), 0), COUNT(t.id)
+		FROM players p
+		LEFT JOIN emerald_transactions t ON t.player_id = p.id
+		GROUP BY p.id
+	`)
+	if err != nil {
+		return err
+	}
+
+	type mismatch struct {
+		playerID int
+		balance  int
+		diff     int
+		reason   string
+	}
+	var mismatches []mismatch
+	for rows.Next() {
+		var playerID, balance, ledgerSum, count int
+		if err := rows.Scan(&playerID, &balance, &ledgerSum, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		if balance == ledgerSum {
+			continue
+		}
+		reason := ReasonAdjustment
+		if count == 0 {
+			reason = ReasonOpeningBalance
+		}
+		mismatches = append(mismatches, mismatch{playerID, balance, balance - ledgerSum, reason})
+	}
+	rows.Close()
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	for _, m := range mismatches {
+		_, err := DB.Exec(
+			"INSERT INTO emerald_transactions (player_id, amount, balance_after, reason, actor, note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			m.playerID, m.diff, m.balance, m.reason, ActorSystem, "余额与流水核对", localTime,
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("玩家 %d 的余额与流水不一致，已补记 %d 绿宝石 (%s)", m.playerID, m.diff, m.reason)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// 使用临时数据库初始化DB，测试结束后关闭
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	t.Setenv("ADMIN_USERNAME", "")
+	t.Setenv("ADMIN_PASSWORD", "")
+	InitDB()
+	t.Cleanup(func() { DB.Close() })
+}
+
+func TestAddEmeraldTransactionErrors(t *testing.T) {
+	setupTestDB(t)
+
+	tests := []struct {
+		name     string
+		playerID int
+		amount   int
+		want     error
+	}{
+		{"余额不足", 1, -1000, ErrInsufficientEmeralds},
+		{"玩家不存在", 9999, -1, ErrPlayerNotFound},
+		{"不存在的玩家收入", 9999, 5, ErrPlayerNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AddEmeraldTransaction(DB, tt.playerID, tt.amount, ReasonAdjustment, nil, nil, "test", "")
+			if err != tt.want {
+				t.Errorf("返回 %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
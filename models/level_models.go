@@ -0,0 +1,233 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 玩家的等级信息
+type LevelInfo struct {
+	Level       int
+	XP          int
+	LevelXP     int // 达到当前等级需要的累计经验
+	NextLevelXP int // 升到下一级需要的累计经验，已经是最高级时为0
+}
+
+// 升级记录
+type LevelUp struct {
+	Level     int
+	CreatedAt string
+}
+
+// 是否已经是最高等级
+func (l LevelInfo) MaxLevel() bool {
+	return l.NextLevelXP == 0
+}
+
+// 升到下一级还需要的经验，已经是最高级时为0
+func (l LevelInfo) XPToNext() int {
+	if l.MaxLevel() {
+		return 0
+	}
+	return l.NextLevelXP - l.XP
+}
+
+// 当前等级的进度百分比
+func (l LevelInfo) ProgressPercent() int {
+	if l.MaxLevel() {
+		return 100
+	}
+	return (l.XP - l.LevelXP) * 100 / (l.NextLevelXP - l.LevelXP)
+}
+
+// 解析等级门槛，格式为逗号分隔的严格递增正整数，第N个数是升到N+1级需要的累计经验
+func ParseLevelThresholds(text string) ([]int, error) {
+	var thresholds []int
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		threshold, err := strconv.Atoi(part)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("等级门槛必须是正整数: %s", part)
+		}
+		if len(thresholds) > 0 && threshold <= thresholds[len(thresholds)-1] {
+			return nil, errors.New("等级门槛必须从小到大排列")
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	if len(thresholds) == 0 {
+		return nil, errors.New("至少需要一个等级门槛")
+	}
+	return thresholds, nil
+}
+
+// 获取当前设置的等级门槛
+func GetLevelThresholds(exec Executor) ([]int, error) {
+	text, err := GetSetting(exec, SettingLevelThresholds)
+	if err != nil {
+		return nil, err
+	}
+	thresholds, err := ParseLevelThresholds(text)
+	if err != nil {
+		// 保存的设置无效时使用默认值，保存时已经校验过，正常不会出现
+		return ParseLevelThresholds(defaultSettings[SettingLevelThresholds])
+	}
+	return thresholds, nil
+}
+
+// 根据累计经验计算等级，从1级开始
+func LevelForXP(xp int, thresholds []int) LevelInfo {
+	info := LevelInfo{Level: 1, XP: xp}
+	for _, threshold := range thresholds {
+		if xp < threshold {
+			info.NextLevelXP = threshold
+			break
+		}
+		info.Level++
+		info.LevelXP = threshold
+	}
+	return info
+}
+
+// 获取玩家的等级信息
+func GetPlayerLevel(player Player) (LevelInfo, error) {
+	thresholds, err := GetLevelThresholds(DB)
+	if err != nil {
+		return LevelInfo{}, err
+	}
+	return LevelForXP(player.XP, thresholds), nil
+}
+
+// 计算确认任务获得的经验，按难度设置的经验乘以评分，四舍五入
+func TaskXP(exec Executor, difficulty string, gradePercent int) (int, error) {
+	key := SettingXPEasy
+	switch difficulty {
+	case "medium":
+		key = SettingXPMedium
+	case "hard":
+		key = SettingXPHard
+	}
+	xp, err := GetIntSetting(exec, key)
+	if err != nil {
+		return 0, err
+	}
+	return (xp*gradePercent + 50) / 100, nil
+}
+
+// 增加玩家经验并记录经验流水，经验只增不减
+// 返回增加经验后新达到的等级，没有升级时为空，调用方应传入事务
+func AddPlayerXP(exec Executor, playerID, xp int, taskID *int) ([]int, error) {
+	if xp <= 0 {
+		return nil, nil
+	}
+
+	var before int
+	err := exec.QueryRow("SELECT xp FROM players WHERE id = ?", playerID).Scan(&before)
+	if err != nil {
+		return nil, err
+	}
+	_, err = exec.Exec("UPDATE players SET xp = xp + ? WHERE id = ?", xp, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err = exec.Exec(
+		"INSERT INTO xp_events (player_id, amount, xp_after, task_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		playerID, xp, before+xp, taskID, localTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds, err := GetLevelThresholds(exec)
+	if err != nil {
+		return nil, err
+	}
+	var levels []int
+	for level := LevelForXP(before, thresholds).Level + 1; level <= LevelForXP(before+xp, thresholds).Level; level++ {
+		// 修改等级门槛后可能重新达到已经记录过的等级，只记录第一次
+		result, err := exec.Exec("INSERT OR IGNORE INTO level_ups (player_id, level, task_id, created_at) VALUES (?, ?, ?, ?)", playerID, level, taskID, localTime)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if affected > 0 {
+			levels = append(levels, level)
+		}
+	}
+	return levels, nil
+}
+
+// 获取玩家最近的升级记录，按时间倒序
+func GetPlayerLevelUps(playerID int, limit int) ([]LevelUp, error) {
+	rows, err := DB.Query("SELECT level, created_at FROM level_ups WHERE player_id = ? ORDER BY created_at DESC, level DESC LIMIT ?", playerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var levelUps []LevelUp
+	for rows.Next() {
+		var levelUp LevelUp
+		if err := rows.Scan(&levelUp.Level, &levelUp.CreatedAt); err != nil {
+			return nil, err
+		}
+		levelUps = append(levelUps, levelUp)
+	}
+	return levelUps, rows.Err()
+}
+
+// 为旧版本数据库中已确认、还没有经验流水的任务补发经验
+func backfillTaskXP() error {
+	rows, err := DB.Query(`
+		SELECT t.id, t.player_id, t.difficulty, COALESCE(t.grade_percent, 100)
+		FROM tasks t
+		JOIN players p ON t.player_id = p.id
+		WHERE t.status = 'verified' AND t.id NOT IN (SELECT task_id FROM xp_events WHERE task_id IS NOT NULL)
+		ORDER BY t.id
+	`)
+	if err != nil {
+		return err
+	}
+	type verifiedTask struct {
+		id, playerID, gradePercent int
+		difficulty                 string
+	}
+	var tasks []verifiedTask
+	for rows.Next() {
+		var task verifiedTask
+		if err := rows.Scan(&task.id, &task.playerID, &task.difficulty, &task.gradePercent); err != nil {
+			rows.Close()
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, task := range tasks {
+		xp, err := TaskXP(tx, task.difficulty, task.gradePercent)
+		if err != nil {
+			return err
+		}
+		taskID := task.id
+		if _, err := AddPlayerXP(tx, task.playerID, xp, &taskID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
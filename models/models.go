@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -11,18 +13,28 @@ import (
 
 // 任务结构体
 type Task struct {
-	ID          int
-	Title       string
-	Description string
-	Difficulty  string // easy, medium, hard
-	Type        string // daily, limited
-	Reward      int
-	ExpiryTime  string
-	Status      string // available, claimed, completed, verified
-	PlayerID    *int
-	TemplateID  *int      // 关联的任务模板ID
-	CreatedAt   time.Time // 创建时间
-	StartTime   string    // 任务开始时间
+	ID            int
+	Title         string
+	Description   string
+	Difficulty    string // easy, medium, hard
+	Type          string // daily, limited
+	Reward        int
+	ExpiryTime    string
+	Status        string // available, claimed, completed, verified
+	PlayerID      *int
+	TemplateID    *int      // 关联的任务模板ID
+	CreatedAt     time.Time // 创建时间
+	StartTime     string    // 任务开始时间
+	ReviewComment string    // 家长退回任务时的留言
+	GradePercent  int       // 家长确认时的评分（百分比）
+	Bonus         int       // 家长确认时给予的额外奖励
+	PaidReward    int       // 实际发放的绿宝石数量，确认后才有效
+
+	TemplateVersion int    // 生成任务时模板的版本号
+	RequiredMinutes int    // 需要计时的分钟数，0表示不需要计时
+	TimerStartedAt  string // 本次计时的开始时间，为空表示没有在计时
+	ElapsedSeconds  int    // 已记录的计时秒数，不包括正在进行的计时
+	QuestID         *int   // 任务所属的任务线，只有任务线步骤生成的任务才有
 }
 
 // 任务模板结构体
@@ -36,6 +48,16 @@ type TaskTemplate struct {
 	RepeatDays  string // 用于存储日常任务的重复周期，格式为逗号分隔的星期几，如"1,2,3,4,5"
 	CreatedAt   string
 	UpdatedAt   string
+
+	RecurrenceRule  string    // 日常任务的重复规则（RRULE子集），为空时使用RepeatDays
+	RecurrenceStart string    // 重复规则的起始日期，用于计算重复间隔
+	Version         int       // 模板当前版本号，每次编辑后加一
+	Status          string    // active, paused, archived
+	PausedUntil     string    // 暂停的最后一天，为空表示一直暂停到手动恢复
+	PerPlayer       bool      // 是否为每个指派的玩家分别生成任务实例
+	AssigneeIDs     []int     // 指派的玩家ID，为空表示所有玩家，只在创建和编辑模板时使用
+	Checklist       Checklist // 复制到每个任务实例的清单，只在创建和编辑模板时使用
+	RequiredMinutes int       // 任务需要计时的分钟数，0表示不需要计时
 }
 
 // 物品结构体
@@ -45,18 +67,29 @@ type Item struct {
 	Description string
 	Cost        int
 	Stock       int
-	ExpiryTime  string
+	ExpiryTime  string // 下架时间，为空表示一直可以兑换
+	Effect      string // 物品效果，为空表示普通物品，streak_freeze表示连续打卡保护卡
+	MinLevel    int    // 兑换需要达到的等级，0表示没有限制
+
+	AvailableFrom string // 上架时间，为空表示立即上架，之前在商店中显示为即将上架
+
+	RequiresApproval bool // 兑换后需要家长批准，批准前绿宝石处于冻结状态
 }
 
 // 兑换记录结构体
 type ExchangeRecord struct {
-	ID        int
-	PlayerID  int
-	ItemID    int
-	ItemName  string
-	Cost      int
-	Timestamp string
-	Exchanged bool
+	ID         int
+	PlayerID   int
+	PlayerName string
+	ItemID     int
+	ItemName   string
+	Cost       int // 兑换时实际支付的绿宝石
+	Timestamp  string
+	Exchanged  bool
+	Status     string // 兑换状态，见Exchange*常量
+	UpdatedAt  string // 最后一次变更状态的时间
+
+	StatusReason string // 拒绝、取消或退款的原因
 }
 
 // 玩家结构体
@@ -64,10 +97,30 @@ type Player struct {
 	ID       int
 	Name     string
 	Emeralds int
+	XP       int // 累计经验，只增不减，用于计算等级
 }
 
 var DB *sql.DB
 
+// 数据库执行器，*sql.DB和*sql.Tx都实现了该接口
+// 需要在事务中执行的数据库操作接收Executor参数，由调用方决定是否放在事务中
+type Executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var (
+	// 物品库存不足
+	ErrOutOfStock = errors.New("物品库存不足")
+	// 任务不是待验证状态
+	ErrTaskNotCompleted = errors.New("该任务未完成，无法验证")
+	// 任务不是当前玩家已领取的状态
+	ErrTaskNotClaimed = errors.New("任务状态已变化，请刷新页面")
+	// 任务已经被领取或不能由该玩家领取
+	ErrTaskNotAvailable = errors.New("该任务已被领取或无法领取，请刷新页面")
+)
+
 // 初始化数据库
 func InitDB() {
 	var err error
@@ -89,7 +142,9 @@ func InitDB() {
 	}
 
 	// 连接数据库
-	DB, err = sql.Open("sqlite3", DBPath)
+	// _txlock=immediate让事务开始时就获取写锁，避免并发事务在读后写时互相死锁
+	// _busy_timeout让并发写入等待锁释放，而不是直接返回database is locked
+	DB, err = sql.Open("sqlite3", DBPath+"?_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		log.Fatal("无法连接到数据库:", err)
 	}
@@ -97,8 +152,29 @@ func InitDB() {
 	// 创建表
 	CreateTables()
 
+	// 为旧版本数据库补充新增的列
+	MigrateTables()
+
+	// 根据环境变量初始化管理员账号
+	InitAdminFromEnv()
+
 	// 初始化一些示例数据
 	InitSampleData()
+
+	// 补充默认的连续打卡里程碑
+	if err := initStreakMilestones(); err != nil {
+		log.Fatal("初始化连续打卡里程碑失败:", err)
+	}
+
+	// 补充内置的成就
+	if err := initAchievements(); err != nil {
+		log.Fatal("初始化成就失败:", err)
+	}
+
+	// 核对玩家余额与绿宝石流水
+	if err := ReconcileEmeraldBalances(); err != nil {
+		log.Fatal("核对绿宝石余额失败:", err)
+	}
 }
 
 // 创建数据库表
@@ -108,7 +184,9 @@ func CreateTables() {
 		`CREATE TABLE IF NOT EXISTS players (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			name TEXT NOT NULL,
-			emeralds INTEGER DEFAULT 0
+			emeralds INTEGER DEFAULT 0,
+			streak_freezes INTEGER NOT NULL DEFAULT 0,
+			xp INTEGER NOT NULL DEFAULT 0
 		);`,
 		// 任务表
 		`CREATE TABLE IF NOT EXISTS tasks (
@@ -123,6 +201,16 @@ func CreateTables() {
 			status TEXT DEFAULT 'available',
 			player_id INTEGER,
 			template_id INTEGER,
+			review_comment TEXT,
+			grade_percent INTEGER,
+			bonus INTEGER DEFAULT 0,
+			paid_reward INTEGER,
+			occurrence_key TEXT,
+			template_version INTEGER,
+			required_minutes INTEGER NOT NULL DEFAULT 0,
+			timer_started_at TEXT,
+			elapsed_seconds INTEGER NOT NULL DEFAULT 0,
+			quest_id INTEGER,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (player_id) REFERENCES players(id)
@@ -136,9 +224,177 @@ func CreateTables() {
 			type TEXT NOT NULL,
 			reward INTEGER NOT NULL,
 			repeat_days TEXT,
+			recurrence_rule TEXT,
+			recurrence_start TEXT,
+			version INTEGER NOT NULL DEFAULT 1,
+			status TEXT NOT NULL DEFAULT 'active',
+			paused_until TEXT,
+			per_player INTEGER NOT NULL DEFAULT 0,
+			required_minutes INTEGER NOT NULL DEFAULT 0,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);`,
+		// 任务模板版本表，保存模板每个版本的内容
+		`CREATE TABLE IF NOT EXISTS task_template_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			template_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT,
+			difficulty TEXT NOT NULL,
+			type TEXT NOT NULL,
+			reward INTEGER NOT NULL,
+			repeat_days TEXT,
+			recurrence_rule TEXT,
+			recurrence_start TEXT,
+			applied_to_available INTEGER NOT NULL DEFAULT 0,
+			checklist TEXT,
+			required_minutes INTEGER NOT NULL DEFAULT 0,
+			actor TEXT,
+			created_at TEXT NOT NULL,
+			UNIQUE (template_id, version)
+		);`,
+		// 任务模板指派的玩家，没有记录表示所有玩家
+		`CREATE TABLE IF NOT EXISTS template_assignees (
+			template_id INTEGER NOT NULL,
+			player_id INTEGER NOT NULL,
+			PRIMARY KEY (template_id, player_id),
+			FOREIGN KEY (template_id) REFERENCES task_templates(id),
+			FOREIGN KEY (player_id) REFERENCES players(id)
+		);`,
+		// 任务指派的玩家，没有记录表示所有玩家都可以领取
+		`CREATE TABLE IF NOT EXISTS task_assignees (
+			task_id INTEGER NOT NULL,
+			player_id INTEGER NOT NULL,
+			PRIMARY KEY (task_id, player_id),
+			FOREIGN KEY (task_id) REFERENCES tasks(id),
+			FOREIGN KEY (player_id) REFERENCES players(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_task_assignees_player ON task_assignees(player_id);`,
+		// 任务模板的清单步骤，生成任务时复制到任务实例
+		`CREATE TABLE IF NOT EXISTS template_checklist_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			template_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			required INTEGER NOT NULL DEFAULT 1,
+			FOREIGN KEY (template_id) REFERENCES task_templates(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_template_checklist_items_template ON template_checklist_items(template_id);`,
+		// 任务实例的清单步骤，玩家逐项勾选
+		`CREATE TABLE IF NOT EXISTS task_checklist_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			required INTEGER NOT NULL DEFAULT 1,
+			checked INTEGER NOT NULL DEFAULT 0,
+			checked_at TEXT,
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_task_checklist_items_task ON task_checklist_items(task_id);`,
+		// 任务线表，由多个按顺序解锁的任务模板组成
+		`CREATE TABLE IF NOT EXISTS quests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			description TEXT,
+			bonus INTEGER NOT NULL DEFAULT 0,
+			step_days INTEGER NOT NULL DEFAULT 7,
+			created_at TEXT NOT NULL
+		);`,
+		// 任务线的步骤，每个模板只能属于一条任务线
+		`CREATE TABLE IF NOT EXISTS quest_steps (
+			quest_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			template_id INTEGER NOT NULL UNIQUE,
+			PRIMARY KEY (quest_id, position),
+			FOREIGN KEY (quest_id) REFERENCES quests(id),
+			FOREIGN KEY (template_id) REFERENCES task_templates(id)
+		);`,
+		// 玩家在任务线上的进度，没有记录表示从第一步开始
+		`CREATE TABLE IF NOT EXISTS quest_progress (
+			quest_id INTEGER NOT NULL,
+			player_id INTEGER NOT NULL,
+			current_step INTEGER NOT NULL DEFAULT 1,
+			completed_at TEXT,
+			updated_at TEXT NOT NULL,
+			PRIMARY KEY (quest_id, player_id),
+			FOREIGN KEY (quest_id) REFERENCES quests(id),
+			FOREIGN KEY (player_id) REFERENCES players(id)
+		);`,
+		// 连续打卡里程碑表，连续完成同一个日常任务达到天数时发放额外奖励
+		`CREATE TABLE IF NOT EXISTS streak_milestones (
+			days INTEGER PRIMARY KEY,
+			bonus INTEGER NOT NULL
+		);`,
+		// 连续打卡保护卡使用记录表，使用了保护卡的日期不会中断连续打卡
+		`CREATE TABLE IF NOT EXISTS streak_freezes (
+			player_id INTEGER NOT NULL,
+			template_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (player_id, template_id, day),
+			FOREIGN KEY (player_id) REFERENCES players(id),
+			FOREIGN KEY (template_id) REFERENCES task_templates(id)
+		);`,
+		// 连续打卡奖励发放记录表，同一个模板同一天只发放一次
+		`CREATE TABLE IF NOT EXISTS streak_rewards (
+			player_id INTEGER NOT NULL,
+			template_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			days INTEGER NOT NULL,
+			bonus INTEGER NOT NULL,
+			task_id INTEGER,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (player_id, template_id, day),
+			FOREIGN KEY (player_id) REFERENCES players(id),
+			FOREIGN KEY (template_id) REFERENCES task_templates(id),
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);`,
+		// 成就表，code为内置成就的标识，家长创建的成就为空
+		`CREATE TABLE IF NOT EXISTS achievements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT UNIQUE,
+			title TEXT NOT NULL,
+			description TEXT,
+			icon TEXT,
+			rule TEXT NOT NULL,
+			threshold INTEGER NOT NULL,
+			reward INTEGER NOT NULL DEFAULT 0,
+			created_at TEXT NOT NULL
+		);`,
+		// 玩家成就解锁记录表
+		`CREATE TABLE IF NOT EXISTS player_achievements (
+			player_id INTEGER NOT NULL,
+			achievement_id INTEGER NOT NULL,
+			unlocked_at TEXT NOT NULL,
+			reward INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (player_id, achievement_id),
+			FOREIGN KEY (player_id) REFERENCES players(id),
+			FOREIGN KEY (achievement_id) REFERENCES achievements(id)
+		);`,
+		// 经验流水表，只追加不修改
+		`CREATE TABLE IF NOT EXISTS xp_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_id INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			xp_after INTEGER NOT NULL,
+			task_id INTEGER,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY (player_id) REFERENCES players(id),
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_xp_events_player ON xp_events(player_id);`,
+		// 升级记录表，每个等级只记录第一次达到的时间
+		`CREATE TABLE IF NOT EXISTS level_ups (
+			player_id INTEGER NOT NULL,
+			level INTEGER NOT NULL,
+			task_id INTEGER,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (player_id, level),
+			FOREIGN KEY (player_id) REFERENCES players(id),
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);`,
 		// 物品表
 		`CREATE TABLE IF NOT EXISTS items (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -147,6 +403,10 @@ func CreateTables() {
 			cost INTEGER NOT NULL,
 			stock INTEGER NOT NULL,
 			expiry_time TEXT,
+			available_from TEXT,
+			effect TEXT,
+			min_level INTEGER NOT NULL DEFAULT 0,
+			requires_approval INTEGER NOT NULL DEFAULT 0,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);`,
 		// 兑换记录表
@@ -157,9 +417,103 @@ func CreateTables() {
 			timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			exchanged BOOLEAN DEFAULT FALSE,
 			exchanged_at TIMESTAMP,
+			status TEXT,
+			cost INTEGER,
+			updated_at TEXT,
+			status_reason TEXT,
 			FOREIGN KEY (player_id) REFERENCES players(id),
 			FOREIGN KEY (item_id) REFERENCES items(id)
 		);`,
+		// 管理员会话表，只保存token的哈希值
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL,
+			last_seen_at TEXT NOT NULL,
+			user_agent TEXT,
+			ip_address TEXT,
+			admin_id INTEGER,
+			FOREIGN KEY (admin_id) REFERENCES admins(id)
+		);`,
+		// 绿宝石流水表，只追加不修改
+		`CREATE TABLE IF NOT EXISTS emerald_transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			player_id INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			balance_after INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			task_id INTEGER,
+			exchange_id INTEGER,
+			actor TEXT,
+			note TEXT,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY (player_id) REFERENCES players(id),
+			FOREIGN KEY (task_id) REFERENCES tasks(id),
+			FOREIGN KEY (exchange_id) REFERENCES exchange_records(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_emerald_transactions_player ON emerald_transactions(player_id);`,
+		// 任务审核记录表，记录每一次提交、退回和确认
+		`CREATE TABLE IF NOT EXISTS task_reviews (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			comment TEXT,
+			actor TEXT,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_task_reviews_task ON task_reviews(task_id);`,
+		// 任务完成凭证表，图片文件保存在上传目录中
+		`CREATE TABLE IF NOT EXISTS task_evidence (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id INTEGER NOT NULL,
+			file_name TEXT NOT NULL,
+			thumb_name TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY (task_id) REFERENCES tasks(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_task_evidence_task ON task_evidence(task_id);`,
+		// 假期表，日期范围内不生成日常任务，可以只针对某个模板或某个玩家
+		`CREATE TABLE IF NOT EXISTS blackout_dates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			start_date TEXT NOT NULL,
+			end_date TEXT NOT NULL,
+			template_id INTEGER,
+			player_id INTEGER,
+			reason TEXT,
+			source TEXT NOT NULL DEFAULT 'manual',
+			source_uid TEXT UNIQUE,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY (template_id) REFERENCES task_templates(id),
+			FOREIGN KEY (player_id) REFERENCES players(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_blackout_dates_range ON blackout_dates(start_date, end_date);`,
+		// 定时任务运行状态表，用于重启后补跑错过的周期
+		`CREATE TABLE IF NOT EXISTS jobs (
+			name TEXT PRIMARY KEY,
+			last_run TEXT,
+			last_success TEXT,
+			last_error TEXT,
+			next_run TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);`,
+		// 系统设置表，没有保存的设置使用代码中的默认值
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);`,
+		// 家长（管理员）账号表
+		`CREATE TABLE IF NOT EXISTS admins (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);`,
 	}
 
 	for _, table := range tables {
@@ -170,6 +524,118 @@ func CreateTables() {
 	}
 }
 
+// 为已存在的表补充新增的列
+func MigrateTables() {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"sessions", "admin_id", "INTEGER"},
+		{"tasks", "review_comment", "TEXT"},
+		{"tasks", "grade_percent", "INTEGER"},
+		{"tasks", "bonus", "INTEGER DEFAULT 0"},
+		{"tasks", "paid_reward", "INTEGER"},
+		{"task_templates", "recurrence_rule", "TEXT"},
+		{"task_templates", "recurrence_start", "TEXT"},
+		{"tasks", "occurrence_key", "TEXT"},
+		{"task_templates", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"tasks", "template_version", "INTEGER"},
+		{"task_templates", "status", "TEXT NOT NULL DEFAULT 'active'"},
+		{"task_templates", "paused_until", "TEXT"},
+		{"task_templates", "per_player", "INTEGER NOT NULL DEFAULT 0"},
+		{"task_template_versions", "checklist", "TEXT"},
+		{"task_templates", "required_minutes", "INTEGER NOT NULL DEFAULT 0"},
+		{"task_template_versions", "required_minutes", "INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "required_minutes", "INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "timer_started_at", "TEXT"},
+		{"tasks", "elapsed_seconds", "INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "quest_id", "INTEGER"},
+		{"players", "streak_freezes", "INTEGER NOT NULL DEFAULT 0"},
+		{"items", "effect", "TEXT"},
+		{"players", "xp", "INTEGER NOT NULL DEFAULT 0"},
+		{"items", "min_level", "INTEGER NOT NULL DEFAULT 0"},
+		{"items", "requires_approval", "INTEGER NOT NULL DEFAULT 0"},
+		{"exchange_records", "status", "TEXT"},
+		{"exchange_records", "cost", "INTEGER"},
+		{"exchange_records", "updated_at", "TEXT"},
+		{"exchange_records", "status_reason", "TEXT"},
+		{"player_achievements", "reward", "INTEGER NOT NULL DEFAULT 0"},
+		{"items", "available_from", "TEXT"},
+	}
+
+	for _, c := range columns {
+		exists, err := columnExists(c.table, c.column)
+		if err != nil {
+			log.Fatal("无法读取表结构:", err)
+		}
+		if exists {
+			continue
+		}
+		_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			log.Fatal("无法升级表结构:", err)
+		}
+		log.Printf("已为表 %s 添加列 %s", c.table, c.column)
+	}
+
+	// 为已有的模板任务补充重复标识，之后通过唯一索引保证同一次重复只有一个实例
+	err := backfillOccurrenceKeys()
+	if err != nil {
+		log.Fatal("无法补充任务重复标识:", err)
+	}
+	_, err = DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_occurrence ON tasks(template_id, occurrence_key) WHERE occurrence_key IS NOT NULL")
+	if err != nil {
+		log.Fatal("无法创建任务重复标识索引:", err)
+	}
+
+	// 为已有的任务模板补充第一个版本记录
+	err = backfillTemplateVersions()
+	if err != nil {
+		log.Fatal("无法补充任务模板版本记录:", err)
+	}
+
+	// 为已确认的任务补发经验
+	err = backfillTaskXP()
+	if err != nil {
+		log.Fatal("无法补发任务经验:", err)
+	}
+
+	// 为旧的兑换记录补充状态和实际支付的绿宝石
+	err = backfillExchangeRecords()
+	if err != nil {
+		log.Fatal("无法补充兑换记录状态:", err)
+	}
+
+	// 统一物品上下架时间的格式
+	err = backfillItemTimes()
+	if err != nil {
+		log.Fatal("无法统一物品上下架时间:", err)
+	}
+}
+
+// 检查表中是否存在指定列
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 // 初始化示例数据
 func InitSampleData() {
 	// 检查是否已有玩家数据
@@ -188,12 +654,13 @@ func InitSampleData() {
 
 		// 插入任务数据
 		tasks := []struct {
-			title       string
-			description string
-			difficulty  string
-			taskType    string
-			reward      int
-			expiryTime  string
+			title           string
+			description     string
+			difficulty      string
+			taskType        string
+			reward          int
+			expiryTime      string
+			requiredMinutes int
 		}{{
 			"完成数学作业",
 			"完成今天的数学作业并检查正确",
@@ -201,6 +668,7 @@ func InitSampleData() {
 			"daily",
 			5,
 			time.Now().Add(24 * time.Hour).Format("2006-01-02 15:04:05"),
+			0,
 		}, {
 			"阅读30分钟",
 			"阅读喜欢的书籍30分钟",
@@ -208,6 +676,7 @@ func InitSampleData() {
 			"daily",
 			5,
 			time.Now().Add(24 * time.Hour).Format("2006-01-02 15:04:05"),
+			30,
 		}, {
 			"帮忙做家务",
 			"帮助家长打扫房间或洗碗",
@@ -215,6 +684,7 @@ func InitSampleData() {
 			"daily",
 			10,
 			time.Now().Add(24 * time.Hour).Format("2006-01-02 15:04:05"),
+			0,
 		}, {
 			"写一篇短文",
 			"写一篇关于你的周末的短文，至少5句话",
@@ -222,12 +692,13 @@ func InitSampleData() {
 			"limited",
 			15,
 			time.Now().Add(7 * 24 * time.Hour).Format("2006-01-02 15:04:05"),
+			0,
 		}}
 
 		for _, task := range tasks {
 			_, err = DB.Exec(
-				"INSERT INTO tasks (title, description, difficulty, type, reward, expiry_time) VALUES (?, ?, ?, ?, ?, ?)",
-				task.title, task.description, task.difficulty, task.taskType, task.reward, task.expiryTime,
+				"INSERT INTO tasks (title, description, difficulty, type, reward, expiry_time, required_minutes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				task.title, task.description, task.difficulty, task.taskType, task.reward, task.expiryTime, task.requiredMinutes,
 			)
 			if err != nil {
 				log.Fatal("插入任务数据失败:", err)
@@ -241,36 +712,48 @@ func InitSampleData() {
 			cost        int
 			stock       int
 			expiryTime  string
+			effect      string
 		}{{
 			"小玩具",
 			"一个有趣的小玩具",
 			10,
 			10,
 			time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05"),
+			"",
 		}, {
 			"漫画书",
 			"一本好看的漫画书",
 			20,
 			5,
 			time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05"),
+			"",
 		}, {
 			"游戏时间",
 			"额外30分钟游戏时间",
 			15,
 			20,
 			time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05"),
+			"",
 		}, {
 			"外出游玩",
 			"周末去公园玩耍",
 			50,
 			3,
 			time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05"),
+			"",
+		}, {
+			"连续打卡保护卡",
+			"错过一天日常任务时自动使用，连续打卡不会中断",
+			15,
+			10,
+			time.Now().Add(30 * 24 * time.Hour).Format("2006-01-02 15:04:05"),
+			ItemEffectStreakFreeze,
 		}}
 
 		for _, item := range items {
 			_, err = DB.Exec(
-				"INSERT INTO items (name, description, cost, stock, expiry_time) VALUES (?, ?, ?, ?, ?)",
-				item.name, item.description, item.cost, item.stock, item.expiryTime,
+				"INSERT INTO items (name, description, cost, stock, expiry_time, effect) VALUES (?, ?, ?, ?, ?, ?)",
+				item.name, item.description, item.cost, item.stock, item.expiryTime, item.effect,
 			)
 			if err != nil {
 				log.Fatal("插入物品数据失败:", err)
@@ -279,36 +762,161 @@ func InitSampleData() {
 	}
 }
 
-// 获取第一个玩家ID
-func GetFirstPlayerID() (int, error) {
-	var playerID int
-	err := DB.QueryRow("SELECT id FROM players ORDER BY id LIMIT 1").Scan(&playerID)
+// 获取所有玩家
+func GetAllPlayers() ([]Player, error) {
+	rows, err := DB.Query("SELECT id, name, emeralds, xp FROM players ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var players []Player
+	for rows.Next() {
+		var player Player
+		err := rows.Scan(&player.ID, &player.Name, &player.Emeralds, &player.XP)
+		if err != nil {
+			log.Println("扫描玩家数据失败:", err)
+			continue
+		}
+		players = append(players, player)
+	}
+	return players, nil
+}
+
+// 创建玩家，初始绿宝石记入流水
+func CreatePlayer(name string, emeralds int, actor string) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO players (name, emeralds) VALUES (?, 0)", name)
+	if err != nil {
+		return 0, err
+	}
+	playerID, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
-	return playerID, nil
+
+	if emeralds > 0 {
+		_, err = AddEmeraldTransaction(tx, int(playerID), emeralds, ReasonInitialGrant, nil, nil, actor, "")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return playerID, tx.Commit()
+}
+
+// 更新玩家名称
+func UpdatePlayerName(playerID int, name string) error {
+	_, err := DB.Exec("UPDATE players SET name = ? WHERE id = ?", name, playerID)
+	return err
+}
+
+// 删除玩家，并将其未确认的任务退回为可领取状态，尚未发放的兑换取消
+func DeletePlayer(playerID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 已领取和已完成的任务重新变为可领取，清单的勾选进度和计时一起清空
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err = tx.Exec("UPDATE task_checklist_items SET checked = 0, checked_at = NULL WHERE task_id IN (SELECT id FROM tasks WHERE player_id = ? AND status IN ('claimed', 'completed'))", playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE tasks SET status = 'available', player_id = NULL, timer_started_at = NULL, elapsed_seconds = 0, updated_at = ? WHERE player_id = ? AND status IN ('claimed', 'completed')", localTime, playerID)
+	if err != nil {
+		return err
+	}
+
+	// 只指派给该玩家、仍可领取的任务标记为过期，避免删除指派后变成所有人可领取
+	_, err = tx.Exec("UPDATE tasks SET status = 'expired', updated_at = ? WHERE status = 'available' AND id IN (SELECT task_id FROM task_assignees WHERE player_id = ?) AND id NOT IN (SELECT task_id FROM task_assignees WHERE player_id != ?)", localTime, playerID, playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM task_assignees WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+
+	// 玩家尚未发放的兑换直接取消并恢复库存，玩家已删除，不需要退回绿宝石
+	_, err = tx.Exec(`
+		UPDATE items SET stock = stock + (
+			SELECT COUNT(*) FROM exchange_records
+			WHERE item_id = items.id AND player_id = ? AND status IN (?, ?)
+		)
+		WHERE id IN (SELECT item_id FROM exchange_records WHERE player_id = ? AND status IN (?, ?))
+	`, playerID, ExchangeRequested, ExchangeApproved, playerID, ExchangeRequested, ExchangeApproved)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE exchange_records SET status = ?, updated_at = ?, status_reason = ? WHERE player_id = ? AND status IN (?, ?)", ExchangeCancelled, localTime, "玩家已删除", playerID, ExchangeRequested, ExchangeApproved)
+	if err != nil {
+		return err
+	}
+
+	// 只指派给该玩家的任务模板归档
+	_, err = tx.Exec("UPDATE task_templates SET status = ?, updated_at = ? WHERE id IN (SELECT template_id FROM template_assignees WHERE player_id = ?) AND id NOT IN (SELECT template_id FROM template_assignees WHERE player_id != ?)", TemplateArchived, localTime, playerID, playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM template_assignees WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM quest_progress WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM streak_freezes WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM streak_rewards WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM player_achievements WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM xp_events WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM level_ups WHERE player_id = ?", playerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM players WHERE id = ?", playerID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // 获取玩家信息
 func GetPlayerInfo(playerID int) (Player, error) {
 	var player Player
-	err := DB.QueryRow("SELECT id, name, emeralds FROM players WHERE id = ?", playerID).Scan(&player.ID, &player.Name, &player.Emeralds)
+	err := DB.QueryRow("SELECT id, name, emeralds, xp FROM players WHERE id = ?", playerID).Scan(&player.ID, &player.Name, &player.Emeralds, &player.XP)
 	if err != nil {
 		return player, err
 	}
 	return player, nil
 }
 
-// 更新玩家绿宝石数量
-func UpdatePlayerEmeralds(playerID int, emeralds int) error {
-	_, err := DB.Exec("UPDATE players SET emeralds = ? WHERE id = ?", emeralds, playerID)
-	return err
-}
-
 // 获取可用任务
-func GetAvailableTasks() ([]Task, error) {
+func GetAvailableTasks(playerID int) ([]Task, error) {
 	currentTime := time.Now().Format("2006-01-02 15:04:05")
-	rows, err := DB.Query(`SELECT id, title, description, difficulty, type, reward, expiry_time, created_at, start_time FROM tasks WHERE status = 'available' AND ((start_time IS NULL OR start_time <= ?) AND expiry_time > ?) ORDER BY created_at DESC`, currentTime, currentTime)
+	rows, err := DB.Query(`SELECT id, title, description, difficulty, type, reward, expiry_time, created_at, start_time, template_id, required_minutes FROM tasks WHERE status = 'available' AND ((start_time IS NULL OR start_time <= ?) AND expiry_time > ?) AND `+taskVisibleToPlayer+` ORDER BY created_at DESC`, currentTime, currentTime, playerID)
 	if err != nil {
 		return nil, err
 	}
@@ -318,7 +926,7 @@ func GetAvailableTasks() ([]Task, error) {
 	for rows.Next() {
 		var task Task
 		var startTime sql.NullString
-		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.CreatedAt, &startTime)
+		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.CreatedAt, &startTime, &task.TemplateID, &task.RequiredMinutes)
 		if err != nil {
 			log.Println("扫描任务数据失败:", err)
 			continue
@@ -333,7 +941,7 @@ func GetAvailableTasks() ([]Task, error) {
 
 // 获取玩家已领取的任务
 func GetPlayerClaimedTasks(playerID int) ([]Task, error) {
-	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, expiry_time, created_at, start_time, status FROM tasks WHERE status IN ('claimed', 'completed') AND player_id = ? ORDER BY updated_at DESC", playerID)
+	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, expiry_time, created_at, start_time, status, COALESCE(review_comment, ''), required_minutes, COALESCE(timer_started_at, ''), elapsed_seconds FROM tasks WHERE status IN ('claimed', 'completed') AND player_id = ? ORDER BY updated_at DESC", playerID)
 	if err != nil {
 		return nil, err
 	}
@@ -343,7 +951,7 @@ func GetPlayerClaimedTasks(playerID int) ([]Task, error) {
 	for rows.Next() {
 		var task Task
 		var startTime sql.NullString
-		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.CreatedAt, &startTime, &task.Status)
+		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.CreatedAt, &startTime, &task.Status, &task.ReviewComment, &task.RequiredMinutes, &task.TimerStartedAt, &task.ElapsedSeconds)
 		if err != nil {
 			log.Println("扫描已领取任务数据失败:", err)
 			continue
@@ -357,9 +965,9 @@ func GetPlayerClaimedTasks(playerID int) ([]Task, error) {
 }
 
 // 获取即将开始的任务
-func GetUpcomingTasks() ([]Task, error) {
+func GetUpcomingTasks(playerID int) ([]Task, error) {
 	currentTime := time.Now().Format("2006-01-02 15:04:05")
-	rows, err := DB.Query(`SELECT id, title, description, difficulty, type, reward, expiry_time, created_at, start_time FROM tasks WHERE status = 'available' AND start_time > ? ORDER BY start_time ASC`, currentTime)
+	rows, err := DB.Query(`SELECT id, title, description, difficulty, type, reward, expiry_time, created_at, start_time, required_minutes FROM tasks WHERE status = 'available' AND start_time > ? AND `+taskVisibleToPlayer+` ORDER BY start_time ASC`, currentTime, playerID)
 	if err != nil {
 		return nil, err
 	}
@@ -369,7 +977,7 @@ func GetUpcomingTasks() ([]Task, error) {
 	for rows.Next() {
 		var task Task
 		var startTime sql.NullString
-		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.CreatedAt, &startTime)
+		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.CreatedAt, &startTime, &task.RequiredMinutes)
 		if err != nil {
 			log.Println("扫描即将开始任务数据失败:", err)
 			continue
@@ -382,56 +990,19 @@ func GetUpcomingTasks() ([]Task, error) {
 	return tasks, nil
 }
 
-// 获取所有物品
+// 获取所有物品，包括已售完、已下架和尚未上架的物品，用于管理页面
 func GetAllItems() ([]Item, error) {
-	rows, err := DB.Query("SELECT id, name, description, cost, stock, expiry_time FROM items WHERE stock > 0 ORDER BY created_at DESC")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var items []Item
-	for rows.Next() {
-		var item Item
-		err := rows.Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.Stock, &item.ExpiryTime)
-		if err != nil {
-			log.Println("扫描物品数据失败:", err)
-			continue
-		}
-		items = append(items, item)
-	}
-	return items, nil
+	return queryItems("ORDER BY created_at DESC")
 }
 
 // 获取所有兑换记录
 func GetAllExchangeRecords() ([]ExchangeRecord, error) {
-	rows, err := DB.Query(`
-		SELECT er.id, er.player_id, er.item_id, i.name, i.cost, er.timestamp, er.exchanged 
-		FROM exchange_records er 
-		JOIN items i ON er.item_id = i.id 
-		ORDER BY er.timestamp DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var exchangeRecords []ExchangeRecord
-	for rows.Next() {
-		var record ExchangeRecord
-		err := rows.Scan(&record.ID, &record.PlayerID, &record.ItemID, &record.ItemName, &record.Cost, &record.Timestamp, &record.Exchanged)
-		if err != nil {
-			log.Println("扫描兑换记录数据失败:", err)
-			continue
-		}
-		exchangeRecords = append(exchangeRecords, record)
-	}
-	return exchangeRecords, nil
+	return queryExchangeRecords(DB, "ORDER BY er.timestamp DESC, er.id DESC")
 }
 
-// 获取所有任务模板
+// 获取所有未归档的任务模板
 func GetAllTaskTemplates() ([]TaskTemplate, error) {
-	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, COALESCE(repeat_days, '') as repeat_days FROM task_templates ORDER BY created_at DESC")
+	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, COALESCE(repeat_days, '') as repeat_days, COALESCE(recurrence_rule, ''), COALESCE(recurrence_start, ''), version, status, COALESCE(paused_until, ''), per_player, required_minutes FROM task_templates WHERE status != 'archived' ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -440,7 +1011,7 @@ func GetAllTaskTemplates() ([]TaskTemplate, error) {
 	var taskTemplates []TaskTemplate
 	for rows.Next() {
 		var template TaskTemplate
-		err := rows.Scan(&template.ID, &template.Title, &template.Description, &template.Difficulty, &template.Type, &template.Reward, &template.RepeatDays)
+		err := rows.Scan(&template.ID, &template.Title, &template.Description, &template.Difficulty, &template.Type, &template.Reward, &template.RepeatDays, &template.RecurrenceRule, &template.RecurrenceStart, &template.Version, &template.Status, &template.PausedUntil, &template.PerPlayer, &template.RequiredMinutes)
 		if err != nil {
 			log.Println("扫描任务模板数据失败:", err)
 			continue
@@ -454,7 +1025,7 @@ func GetAllTaskTemplates() ([]TaskTemplate, error) {
 func GetAllTasks() ([]Task, error) {
 	// 计算大前天的时间
 	threeDaysAgo := time.Now().AddDate(0, 0, -2).Format("2006-01-02 15:04:05")
-	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, expiry_time, status, player_id, COALESCE(template_id, 0) as template_id FROM tasks WHERE (expiry_time > ? OR status = 'completed') ORDER BY created_at DESC", threeDaysAgo)
+	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, expiry_time, status, player_id, COALESCE(template_id, 0) as template_id, COALESCE(grade_percent, 100), COALESCE(bonus, 0), COALESCE(paid_reward, reward), required_minutes, COALESCE(timer_started_at, ''), elapsed_seconds FROM tasks WHERE (expiry_time > ? OR status = 'completed') ORDER BY created_at DESC", threeDaysAgo)
 	if err != nil {
 		return nil, err
 	}
@@ -464,7 +1035,7 @@ func GetAllTasks() ([]Task, error) {
 	for rows.Next() {
 		var task Task
 		var templateID int
-		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.Status, &task.PlayerID, &templateID)
+		err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.Status, &task.PlayerID, &templateID, &task.GradePercent, &task.Bonus, &task.PaidReward, &task.RequiredMinutes, &task.TimerStartedAt, &task.ElapsedSeconds)
 		if err != nil {
 			log.Println("扫描任务数据失败:", err)
 			continue
@@ -478,9 +1049,9 @@ func GetAllTasks() ([]Task, error) {
 	return tasks, nil
 }
 
-// 根据任务类型获取任务模板
+// 根据任务类型获取未归档的任务模板
 func GetAllTaskTemplatesByType(taskType string) ([]TaskTemplate, error) {
-	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, COALESCE(repeat_days, '') as repeat_days FROM task_templates WHERE type = ? ORDER BY created_at DESC", taskType)
+	rows, err := DB.Query("SELECT id, title, description, difficulty, type, reward, COALESCE(repeat_days, '') as repeat_days, COALESCE(recurrence_rule, ''), COALESCE(recurrence_start, ''), version, status, COALESCE(paused_until, ''), per_player, required_minutes FROM task_templates WHERE type = ? AND status != 'archived' ORDER BY created_at DESC", taskType)
 	if err != nil {
 		return nil, err
 	}
@@ -489,7 +1060,7 @@ func GetAllTaskTemplatesByType(taskType string) ([]TaskTemplate, error) {
 	var taskTemplates []TaskTemplate
 	for rows.Next() {
 		var template TaskTemplate
-		err := rows.Scan(&template.ID, &template.Title, &template.Description, &template.Difficulty, &template.Type, &template.Reward, &template.RepeatDays)
+		err := rows.Scan(&template.ID, &template.Title, &template.Description, &template.Difficulty, &template.Type, &template.Reward, &template.RepeatDays, &template.RecurrenceRule, &template.RecurrenceStart, &template.Version, &template.Status, &template.PausedUntil, &template.PerPlayer, &template.RequiredMinutes)
 		if err != nil {
 			log.Println("扫描任务模板数据失败:", err)
 			continue
@@ -500,9 +1071,9 @@ func GetAllTaskTemplatesByType(taskType string) ([]TaskTemplate, error) {
 }
 
 // 获取物品信息
-func GetItemInfo(itemID int) (Item, error) {
+func GetItemInfo(exec Executor, itemID int) (Item, error) {
 	var item Item
-	err := DB.QueryRow("SELECT id, name, description, cost, stock FROM items WHERE id = ?", itemID).Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.Stock)
+	err := exec.QueryRow("SELECT id, name, description, cost, stock, COALESCE(expiry_time, ''), COALESCE(available_from, ''), COALESCE(effect, ''), min_level, requires_approval FROM items WHERE id = ?", itemID).Scan(&item.ID, &item.Name, &item.Description, &item.Cost, &item.Stock, &item.ExpiryTime, &item.AvailableFrom, &item.Effect, &item.MinLevel, &item.RequiresApproval)
 	if err != nil {
 		return item, err
 	}
@@ -510,11 +1081,11 @@ func GetItemInfo(itemID int) (Item, error) {
 }
 
 // 创建物品
-func CreateItem(name, description string, cost, stock int, expiryTime string) error {
+func CreateItem(item Item) error {
 	localTime := time.Now().Format("2006-01-02 15:04:05")
 	_, err := DB.Exec(
-		"INSERT INTO items (name, description, cost, stock, expiry_time, created_at) VALUES (?, ?, ?, ?, ?, ?)",
-		name, description, cost, stock, expiryTime, localTime,
+		"INSERT INTO items (name, description, cost, stock, expiry_time, available_from, effect, min_level, requires_approval, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		item.Name, item.Description, item.Cost, item.Stock, item.ExpiryTime, item.AvailableFrom, item.Effect, item.MinLevel, item.RequiresApproval, localTime,
 	)
 	return err
 }
@@ -525,50 +1096,103 @@ func DeleteItem(itemID int) error {
 	return err
 }
 
-// 更新物品库存
-func UpdateItemStock(itemID int, stock int) error {
-	_, err := DB.Exec("UPDATE items SET stock = ? WHERE id = ?", stock, itemID)
-	return err
+// 扣减一件物品库存，库存为0时返回ErrOutOfStock
+func DecrementItemStock(exec Executor, itemID int) error {
+	result, err := exec.Exec("UPDATE items SET stock = stock - 1 WHERE id = ? AND stock > 0", itemID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrOutOfStock
+	}
+	return nil
 }
 
-// 创建兑换记录
-func CreateExchangeRecord(playerID int, itemID int) error {
-	_, err := DB.Exec("INSERT INTO exchange_records (player_id, item_id) VALUES (?, ?)", playerID, itemID)
-	return err
+// 创建兑换记录，cost为实际支付的绿宝石
+func CreateExchangeRecord(exec Executor, playerID int, itemID int, cost int, status string) (int64, error) {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := exec.Exec("INSERT INTO exchange_records (player_id, item_id, cost, status, updated_at) VALUES (?, ?, ?, ?, ?)", playerID, itemID, cost, status, localTime)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
 }
 
-// 更新兑换记录状态
-func UpdateExchangeRecordStatus(recordID int, exchanged bool) error {
-	// 简化函数，只更新exchanged列，避免依赖不存在的exchanged_at列
-	if exchanged {
-		localTime := time.Now().Format("2006-01-02 15:04:05")
-		_, err := DB.Exec("UPDATE exchange_records SET exchanged = ?, exchanged_at = ? WHERE id = ?", exchanged, localTime, recordID)
+// 领取任务，任务已被领取或不能由该玩家领取时返回ErrTaskNotAvailable
+func ClaimTask(taskID int, playerID int) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := DB.Exec("UPDATE tasks SET status = 'claimed', player_id = ?, updated_at = ? WHERE id = ? AND status = 'available' AND "+taskVisibleToPlayer, playerID, localTime, taskID, playerID)
+	if err != nil {
 		return err
-	} else {
-		_, err := DB.Exec("UPDATE exchange_records SET exchanged = ? WHERE id = ?", exchanged, recordID)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return ErrTaskNotAvailable
+	}
+	return nil
 }
 
-// 领取任务
-func ClaimTask(taskID int, playerID int) error {
+// 完成任务，任务不是该玩家已领取的状态时返回ErrTaskNotClaimed，避免重复提交
+func CompleteTask(exec Executor, taskID, playerID int) error {
 	localTime := time.Now().Format("2006-01-02 15:04:05")
-	_, err := DB.Exec("UPDATE tasks SET status = 'claimed', player_id = ?, updated_at = ? WHERE id = ? AND status = 'available'", playerID, localTime, taskID)
-	return err
+	result, err := exec.Exec("UPDATE tasks SET status = 'completed', updated_at = ? WHERE id = ? AND player_id = ? AND status = 'claimed'", localTime, taskID, playerID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTaskNotClaimed
+	}
+	return nil
+}
+
+// 根据评分和额外奖励计算实际发放的绿宝石数量，评分部分四舍五入
+func CalculateGradedReward(reward, gradePercent, bonus int) int {
+	return (reward*gradePercent+50)/100 + bonus
 }
 
-// 完成任务
-func CompleteTask(taskID int) error {
+// 验证任务并记录评分和实际发放的奖励，任务不是已完成状态时返回ErrTaskNotCompleted
+func VerifyTask(exec Executor, taskID, gradePercent, bonus, paidReward int) error {
 	localTime := time.Now().Format("2006-01-02 15:04:05")
-	_, err := DB.Exec("UPDATE tasks SET status = 'completed', updated_at = ? WHERE id = ? AND status = 'claimed'", localTime, taskID)
-	return err
+	result, err := exec.Exec("UPDATE tasks SET status = 'verified', grade_percent = ?, bonus = ?, paid_reward = ?, updated_at = ? WHERE id = ? AND status = 'completed'", gradePercent, bonus, paidReward, localTime, taskID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTaskNotCompleted
+	}
+	return nil
 }
 
-// 验证任务
-func VerifyTask(taskID int) error {
+// 退回任务，任务回到已领取状态并保存家长留言，任务不是已完成状态时返回ErrTaskNotCompleted
+func RejectTask(exec Executor, taskID int, comment string) error {
 	localTime := time.Now().Format("2006-01-02 15:04:05")
-	_, err := DB.Exec("UPDATE tasks SET status = 'verified', updated_at = ? WHERE id = ? AND status = 'completed'", localTime, taskID)
-	return err
+	result, err := exec.Exec("UPDATE tasks SET status = 'claimed', review_comment = ?, updated_at = ? WHERE id = ? AND status = 'completed'", comment, localTime, taskID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTaskNotCompleted
+	}
+	return nil
 }
 
 // 创建任务
@@ -582,9 +1206,45 @@ func CreateTask(task Task) error {
 }
 
 // 删除任务
-func DeleteTask(taskID int) error {
-	_, err := DB.Exec("DELETE FROM tasks WHERE id = ?", taskID)
-	return err
+// 同时删除任务的凭证、审核记录和清单，返回需要从上传目录删除的图片文件名
+// 绿宝石流水和经验记录保留，不受影响
+func DeleteTask(taskID int) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT file_name, thumb_name FROM task_evidence WHERE task_id = ?", taskID)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for rows.Next() {
+		var fileName, thumbName string
+		if err := rows.Scan(&fileName, &thumbName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, fileName, thumbName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM task_evidence WHERE task_id = ?",
+		"DELETE FROM task_reviews WHERE task_id = ?",
+		"DELETE FROM task_checklist_items WHERE task_id = ?",
+		"DELETE FROM task_assignees WHERE task_id = ?",
+		"DELETE FROM tasks WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, taskID); err != nil {
+			return nil, err
+		}
+	}
+	return files, tx.Commit()
 }
 
 // 删除任务模板
@@ -593,23 +1253,48 @@ func DeleteTaskTemplate(templateID int) error {
 	return err
 }
 
-// 创建任务模板
-func CreateTaskTemplate(template TaskTemplate) (int64, error) {
+// 创建任务模板，同时记录模板的第一个版本、指派的玩家和清单
+func CreateTaskTemplate(template TaskTemplate, actor string) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	localTime := time.Now().Format("2006-01-02 15:04:05")
-	result, err := DB.Exec(
-		"INSERT INTO task_templates (title, description, difficulty, type, reward, repeat_days, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		template.Title, template.Description, template.Difficulty, template.Type, template.Reward, template.RepeatDays, localTime, localTime,
+	result, err := tx.Exec(
+		"INSERT INTO task_templates (title, description, difficulty, type, reward, repeat_days, recurrence_rule, recurrence_start, per_player, required_minutes, version, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)",
+		template.Title, template.Description, template.Difficulty, template.Type, template.Reward, template.RepeatDays, template.RecurrenceRule, template.RecurrenceStart, template.PerPlayer, template.RequiredMinutes, localTime, localTime,
 	)
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	templateID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	template.ID = int(templateID)
+	template.Version = 1
+	err = SetTemplateAssignees(tx, template.ID, template.AssigneeIDs)
+	if err != nil {
+		return 0, err
+	}
+	err = SetTemplateChecklist(tx, template.ID, template.Checklist)
+	if err != nil {
+		return 0, err
+	}
+	err = AddTaskTemplateVersion(tx, template, false, actor)
+	if err != nil {
+		return 0, err
+	}
+	return templateID, tx.Commit()
 }
 
 // 根据ID获取任务
 func GetTaskByID(taskID int) (Task, error) {
 	var task Task
-	err := DB.QueryRow("SELECT id, title, description, difficulty, type, reward, expiry_time, status, player_id, COALESCE(template_id, 0) as template_id FROM tasks WHERE id = ?", taskID).Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.Status, &task.PlayerID, &task.TemplateID)
+	err := DB.QueryRow("SELECT id, title, description, difficulty, type, reward, expiry_time, status, player_id, COALESCE(template_id, 0) as template_id, COALESCE(start_time, ''), required_minutes, COALESCE(timer_started_at, ''), elapsed_seconds, quest_id FROM tasks WHERE id = ?", taskID).Scan(&task.ID, &task.Title, &task.Description, &task.Difficulty, &task.Type, &task.Reward, &task.ExpiryTime, &task.Status, &task.PlayerID, &task.TemplateID, &task.StartTime, &task.RequiredMinutes, &task.TimerStartedAt, &task.ElapsedSeconds, &task.QuestID)
 	if err != nil {
 		return task, err
 	}
@@ -617,10 +1302,10 @@ func GetTaskByID(taskID int) (Task, error) {
 }
 
 // 更新物品信息
-func UpdateItem(itemID int, name, description string, cost, stock int, expiryTime string) error {
+func UpdateItem(item Item) error {
 	_, err := DB.Exec(
-		"UPDATE items SET name = ?, description = ?, cost = ?, stock = ?, expiry_time = ? WHERE id = ?",
-		name, description, cost, stock, expiryTime, itemID,
+		"UPDATE items SET name = ?, description = ?, cost = ?, stock = ?, expiry_time = ?, available_from = ?, effect = ?, min_level = ?, requires_approval = ? WHERE id = ?",
+		item.Name, item.Description, item.Cost, item.Stock, item.ExpiryTime, item.AvailableFrom, item.Effect, item.MinLevel, item.RequiresApproval, item.ID,
 	)
 	return err
 }
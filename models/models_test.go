@@ -0,0 +1,141 @@
+package models
+
+import "testing"
+
+func TestCompleteTaskOnlyOnce(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	var taskID int
+	err := DB.QueryRow("SELECT MIN(id) FROM tasks").Scan(&taskID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+	_, err = DB.Exec("UPDATE tasks SET status = 'claimed', player_id = ? WHERE id = ?", playerID, taskID)
+	if err != nil {
+		t.Fatal("修改任务状态失败:", err)
+	}
+
+	if err := CompleteTask(DB, taskID, playerID+1); err != ErrTaskNotClaimed {
+		t.Errorf("其他玩家提交返回 %v，期望 %v", err, ErrTaskNotClaimed)
+	}
+	if err := CompleteTask(DB, taskID, playerID); err != nil {
+		t.Fatalf("第一次提交失败: %v", err)
+	}
+	if err := CompleteTask(DB, taskID, playerID); err != ErrTaskNotClaimed {
+		t.Errorf("重复提交返回 %v，期望 %v", err, ErrTaskNotClaimed)
+	}
+}
+
+func TestDeleteTaskRemovesRelatedRows(t *testing.T) {
+	setupTestDB(t)
+
+	var taskID int
+	err := DB.QueryRow("SELECT MIN(id) FROM tasks").Scan(&taskID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+	err = AddTaskEvidence(DB, TaskEvidence{TaskID: taskID, FileName: "a.png", ThumbName: "a_thumb.jpg", ContentType: "image/png", Size: 1})
+	if err != nil {
+		t.Fatal("保存凭证失败:", err)
+	}
+	err = AddTaskReview(DB, taskID, ReviewSubmitted, "", "test")
+	if err != nil {
+		t.Fatal("保存审核记录失败:", err)
+	}
+	_, err = DB.Exec("INSERT INTO task_checklist_items (task_id, position, title, required) VALUES (?, 1, '步骤', 1)", taskID)
+	if err != nil {
+		t.Fatal("保存清单失败:", err)
+	}
+	_, err = DB.Exec("INSERT INTO task_assignees (task_id, player_id) VALUES (?, 1)", taskID)
+	if err != nil {
+		t.Fatal("保存指派玩家失败:", err)
+	}
+
+	files, err := DeleteTask(taskID)
+	if err != nil {
+		t.Fatal("删除任务失败:", err)
+	}
+	if len(files) != 2 || files[0] != "a.png" || files[1] != "a_thumb.jpg" {
+		t.Errorf("返回的文件为 %v，期望 [a.png a_thumb.jpg]", files)
+	}
+	for _, table := range []string{"task_evidence", "task_reviews", "task_checklist_items", "task_assignees"} {
+		var count int
+		err := DB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE task_id = ?", taskID).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%s 还有 %d 条记录", table, count)
+		}
+	}
+}
+
+func TestClaimTaskOnlyOnce(t *testing.T) {
+	setupTestDB(t)
+
+	var taskID int
+	err := DB.QueryRow("SELECT MIN(id) FROM tasks WHERE status = 'available'").Scan(&taskID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+
+	if err := ClaimTask(taskID, 1); err != nil {
+		t.Fatalf("第一次领取失败: %v", err)
+	}
+	if err := ClaimTask(taskID, 2); err != ErrTaskNotAvailable {
+		t.Errorf("其他玩家领取返回 %v，期望 %v", err, ErrTaskNotAvailable)
+	}
+	var playerID int
+	err = DB.QueryRow("SELECT player_id FROM tasks WHERE id = ?", taskID).Scan(&playerID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+	if playerID != 1 {
+		t.Errorf("任务被玩家 %d 领取，期望玩家 1", playerID)
+	}
+}
+
+func TestDeletePlayerResetsTasks(t *testing.T) {
+	setupTestDB(t)
+
+	const playerID = 1
+	var taskID int
+	err := DB.QueryRow("SELECT MIN(id) FROM tasks").Scan(&taskID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+	_, err = DB.Exec("UPDATE tasks SET status = 'claimed', player_id = ?, timer_started_at = '2024-01-01 00:00:00', elapsed_seconds = 600 WHERE id = ?", playerID, taskID)
+	if err != nil {
+		t.Fatal("修改任务状态失败:", err)
+	}
+	_, err = DB.Exec("INSERT INTO task_checklist_items (task_id, position, title, required, checked, checked_at) VALUES (?, 1, '步骤', 1, 1, '2024-01-01 00:00:00')", taskID)
+	if err != nil {
+		t.Fatal("保存清单失败:", err)
+	}
+
+	if err := DeletePlayer(playerID); err != nil {
+		t.Fatal("删除玩家失败:", err)
+	}
+
+	var status, timerStartedAt string
+	var elapsed int
+	err = DB.QueryRow("SELECT status, COALESCE(timer_started_at, ''), elapsed_seconds FROM tasks WHERE id = ?", taskID).Scan(&status, &timerStartedAt, &elapsed)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+	if status != "available" {
+		t.Errorf("任务状态为 %s，期望 available", status)
+	}
+	if timerStartedAt != "" || elapsed != 0 {
+		t.Errorf("任务计时为 %q、%d 秒，期望清空", timerStartedAt, elapsed)
+	}
+	var checked int
+	err = DB.QueryRow("SELECT COUNT(*) FROM task_checklist_items WHERE task_id = ? AND (checked = 1 OR checked_at IS NOT NULL)", taskID).Scan(&checked)
+	if err != nil {
+		t.Fatal("查询清单失败:", err)
+	}
+	if checked != 0 {
+		t.Errorf("还有 %d 个已勾选的步骤，期望清空", checked)
+	}
+}
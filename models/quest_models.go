@@ -0,0 +1,320 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// 任务模板已经属于另一条任务线
+var ErrTemplateInQuest = errors.New("任务模板已经属于另一条任务线")
+
+// 任务线中步骤的状态
+const (
+	QuestStepDone    = "done"    // 已完成并确认
+	QuestStepCurrent = "current" // 已解锁，正在进行
+	QuestStepLocked  = "locked"  // 尚未解锁
+)
+
+// 任务线结构体，由多个按顺序解锁的任务模板组成
+type Quest struct {
+	ID          int
+	Title       string
+	Description string
+	Bonus       int // 完成整条任务线后额外奖励的绿宝石
+	StepDays    int // 每一步解锁后的完成期限（天），过期后会重新生成
+	CreatedAt   string
+	Steps       []QuestStep
+}
+
+// 任务线的一个步骤
+type QuestStep struct {
+	Position      int
+	TemplateID    int
+	TemplateTitle string
+}
+
+// 玩家在一条任务线上的进度
+type QuestProgress struct {
+	Quest       Quest
+	PlayerID    int
+	PlayerName  string
+	CurrentStep int    // 正在进行的步骤序号，从1开始
+	CompletedAt string // 完成整条任务线的时间，为空表示尚未完成
+}
+
+// 步骤在该进度下的状态
+func (p QuestProgress) StepState(position int) string {
+	if position < p.CurrentStep {
+		return QuestStepDone
+	}
+	if position == p.CurrentStep && p.CompletedAt == "" {
+		return QuestStepCurrent
+	}
+	return QuestStepLocked
+}
+
+// 已完成并确认的步骤数量
+func (p QuestProgress) DoneSteps() int {
+	if p.CurrentStep-1 > len(p.Quest.Steps) {
+		return len(p.Quest.Steps)
+	}
+	return p.CurrentStep - 1
+}
+
+// 创建任务线，templateIDs为按顺序排列的步骤模板
+// 步骤模板不再单独生成任务，已生成且尚未领取的任务会被标记为过期
+func CreateQuest(quest Quest, templateIDs []int) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := tx.Exec(
+		"INSERT INTO quests (title, description, bonus, step_days, created_at) VALUES (?, ?, ?, ?, ?)",
+		quest.Title, quest.Description, quest.Bonus, quest.StepDays, localTime,
+	)
+	if err != nil {
+		return 0, err
+	}
+	questID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, templateID := range templateIDs {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM quest_steps WHERE template_id = ?", templateID).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			return 0, ErrTemplateInQuest
+		}
+		_, err = tx.Exec("INSERT INTO quest_steps (quest_id, position, template_id) VALUES (?, ?, ?)", questID, i+1, templateID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("UPDATE tasks SET status = 'expired' WHERE template_id = ? AND status = 'available' AND quest_id IS NULL", templateID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return questID, tx.Commit()
+}
+
+// 删除任务线和所有玩家的进度，尚未领取的步骤任务会被标记为过期
+// 已领取的步骤任务可以继续完成，但不再推进任务线
+func DeleteQuest(questID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"UPDATE tasks SET status = 'expired' WHERE quest_id = ? AND status = 'available'",
+		"DELETE FROM quest_progress WHERE quest_id = ?",
+		"DELETE FROM quest_steps WHERE quest_id = ?",
+		"DELETE FROM quests WHERE id = ?",
+	}
+	for _, statement := range statements {
+		_, err := tx.Exec(statement, questID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 获取所有任务线及其步骤
+func GetAllQuests() ([]Quest, error) {
+	rows, err := DB.Query("SELECT id, title, COALESCE(description, ''), bonus, step_days, created_at FROM quests ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	var quests []Quest
+	for rows.Next() {
+		var quest Quest
+		err := rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Bonus, &quest.StepDays, &quest.CreatedAt)
+		if err != nil {
+			log.Println("扫描任务线数据失败:", err)
+			continue
+		}
+		quests = append(quests, quest)
+	}
+	rows.Close()
+
+	steps, err := getQuestSteps()
+	if err != nil {
+		return nil, err
+	}
+	for i := range quests {
+		quests[i].Steps = steps[quests[i].ID]
+	}
+	return quests, nil
+}
+
+// 获取所有任务线的步骤，按任务线ID分组
+func getQuestSteps() (map[int][]QuestStep, error) {
+	rows, err := DB.Query(`
+		SELECT s.quest_id, s.position, s.template_id, COALESCE(t.title, '')
+		FROM quest_steps s
+		LEFT JOIN task_templates t ON s.template_id = t.id
+		ORDER BY s.quest_id, s.position
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := make(map[int][]QuestStep)
+	for rows.Next() {
+		var questID int
+		var step QuestStep
+		err := rows.Scan(&questID, &step.Position, &step.TemplateID, &step.TemplateTitle)
+		if err != nil {
+			log.Println("扫描任务线步骤失败:", err)
+			continue
+		}
+		steps[questID] = append(steps[questID], step)
+	}
+	return steps, nil
+}
+
+// 判断任务模板是否属于某条任务线
+func IsQuestTemplate(templateID int) (bool, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM quest_steps WHERE template_id = ?", templateID).Scan(&count)
+	return count > 0, err
+}
+
+// 获取所有任务线上所有玩家的进度，没有进度记录的玩家从第一步开始
+func GetAllQuestProgress() ([]QuestProgress, error) {
+	quests, err := GetAllQuests()
+	if err != nil {
+		return nil, err
+	}
+	players, err := GetAllPlayers()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query("SELECT quest_id, player_id, current_step, COALESCE(completed_at, '') FROM quest_progress")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type progressKey struct{ questID, playerID int }
+	saved := make(map[progressKey]QuestProgress)
+	for rows.Next() {
+		var key progressKey
+		var progress QuestProgress
+		err := rows.Scan(&key.questID, &key.playerID, &progress.CurrentStep, &progress.CompletedAt)
+		if err != nil {
+			log.Println("扫描任务线进度失败:", err)
+			continue
+		}
+		saved[key] = progress
+	}
+
+	var progresses []QuestProgress
+	for _, quest := range quests {
+		for _, player := range players {
+			progress, ok := saved[progressKey{quest.ID, player.ID}]
+			if !ok {
+				progress.CurrentStep = 1
+			}
+			progress.Quest = quest
+			progress.PlayerID = player.ID
+			progress.PlayerName = player.Name
+			progresses = append(progresses, progress)
+		}
+	}
+	return progresses, nil
+}
+
+// 获取玩家在所有任务线上的进度
+func GetPlayerQuestProgress(playerID int) ([]QuestProgress, error) {
+	progresses, err := GetAllQuestProgress()
+	if err != nil {
+		return nil, err
+	}
+	var playerProgresses []QuestProgress
+	for _, progress := range progresses {
+		if progress.PlayerID == playerID {
+			playerProgresses = append(playerProgresses, progress)
+		}
+	}
+	return playerProgresses, nil
+}
+
+// 确认任务后推进任务线进度
+// 任务是玩家当前步骤的任务时进入下一步，完成最后一步时返回完成的任务线，否则返回nil
+func AdvanceQuest(exec Executor, task Task) (*Quest, error) {
+	if task.QuestID == nil || task.TemplateID == nil || task.PlayerID == nil {
+		return nil, nil
+	}
+
+	var quest Quest
+	err := exec.QueryRow("SELECT id, title, bonus FROM quests WHERE id = ?", *task.QuestID).Scan(&quest.ID, &quest.Title, &quest.Bonus)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 任务线已被删除时不再推进
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var position, stepCount int
+	err = exec.QueryRow("SELECT COALESCE(MAX(CASE WHEN template_id = ? THEN position END), 0), COUNT(*) FROM quest_steps WHERE quest_id = ?", *task.TemplateID, quest.ID).Scan(&position, &stepCount)
+	if err != nil || position == 0 {
+		return nil, err
+	}
+
+	currentStep := 1
+	var completedAt string
+	err = exec.QueryRow("SELECT current_step, COALESCE(completed_at, '') FROM quest_progress WHERE quest_id = ? AND player_id = ?", quest.ID, *task.PlayerID).Scan(&currentStep, &completedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if completedAt != "" || position != currentStep {
+		return nil, nil
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	var questCompletedAt interface{}
+	if position == stepCount {
+		questCompletedAt = localTime
+	}
+	_, err = exec.Exec(`
+		INSERT INTO quest_progress (quest_id, player_id, current_step, completed_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (quest_id, player_id) DO UPDATE SET current_step = excluded.current_step, completed_at = excluded.completed_at, updated_at = excluded.updated_at
+	`, quest.ID, *task.PlayerID, position+1, questCompletedAt, localTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if questCompletedAt == nil {
+		return nil, nil
+	}
+	return &quest, nil
+}
+
+// 查询玩家在任务线某一步的任务
+// 返回是否已经有未结束的任务，以及最后一个任务的ID，还没有任务时为0
+func GetQuestStepTasks(questID, templateID, playerID int) (bool, int, error) {
+	var open bool
+	var lastTaskID int
+	err := DB.QueryRow(`
+		SELECT COALESCE(SUM(t.status IN ('available', 'claimed', 'completed')), 0) > 0, COALESCE(MAX(t.id), 0) FROM tasks t
+		JOIN task_assignees a ON a.task_id = t.id
+		WHERE t.quest_id = ? AND t.template_id = ? AND a.player_id = ?
+	`, questID, templateID, playerID).Scan(&open, &lastTaskID)
+	return open, lastTaskID, err
+}
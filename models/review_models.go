@@ -0,0 +1,54 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// 任务审核记录的操作类型
+const (
+	ReviewSubmitted = "submitted" // 玩家提交完成
+	ReviewRejected  = "rejected"  // 家长退回重做
+	ReviewVerified  = "verified"  // 家长确认完成
+)
+
+// 任务审核记录结构体，记录每一次提交和审核
+type TaskReview struct {
+	ID        int
+	TaskID    int
+	Action    string
+	Comment   string
+	Actor     string
+	CreatedAt string
+}
+
+// 记录一条任务审核记录
+func AddTaskReview(exec Executor, taskID int, action, comment, actor string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := exec.Exec(
+		"INSERT INTO task_reviews (task_id, action, comment, actor, created_at) VALUES (?, ?, ?, ?, ?)",
+		taskID, action, comment, actor, localTime,
+	)
+	return err
+}
+
+// 获取任务的审核记录，按时间正序
+func GetTaskReviews(taskID int) ([]TaskReview, error) {
+	rows, err := DB.Query("SELECT id, task_id, action, COALESCE(comment, ''), COALESCE(actor, ''), created_at FROM task_reviews WHERE task_id = ? ORDER BY id", taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []TaskReview
+	for rows.Next() {
+		var review TaskReview
+		err := rows.Scan(&review.ID, &review.TaskID, &review.Action, &review.Comment, &review.Actor, &review.CreatedAt)
+		if err != nil {
+			log.Println("扫描任务审核记录失败:", err)
+			continue
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, nil
+}
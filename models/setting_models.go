@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// 系统设置的键
+const (
+	SettingLevelThresholds = "level_thresholds"        // 升到每一级需要的累计经验，逗号分隔
+	SettingXPEasy          = "xp_easy"                 // 简单任务确认后获得的经验
+	SettingXPMedium        = "xp_medium"               // 中等任务确认后获得的经验
+	SettingXPHard          = "xp_hard"                 // 困难任务确认后获得的经验
+	SettingLeaderboardMode = "leaderboard_mode"        // 排行榜的显示方式，见Leaderboard*常量
+	SettingCancelMinutes   = "exchange_cancel_minutes" // 玩家兑换后可以自行取消的时间（分钟），0表示不能取消
+)
+
+// 系统设置的默认值，数据库中没有保存时使用
+var defaultSettings = map[string]string{
+	SettingLevelThresholds: "20,50,100,180,300,450,650,900,1200",
+	SettingXPEasy:          "5",
+	SettingXPMedium:        "10",
+	SettingXPHard:          "20",
+	SettingLeaderboardMode: LeaderboardVisible,
+	SettingCancelMinutes:   "30",
+}
+
+// 获取系统设置，没有保存过时返回默认值
+func GetSetting(exec Executor, key string) (string, error) {
+	var value string
+	err := exec.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultSettings[key], nil
+	}
+	return value, err
+}
+
+// 获取整数类型的系统设置，保存的值无法解析时返回默认值
+func GetIntSetting(exec Executor, key string) (int, error) {
+	value, err := GetSetting(exec, key)
+	if err != nil {
+		return 0, err
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return strconv.Atoi(defaultSettings[key])
+	}
+	return number, nil
+}
+
+// 获取所有系统设置，没有保存过的设置使用默认值
+func GetAllSettings() (map[string]string, error) {
+	settings := make(map[string]string, len(defaultSettings))
+	for key, value := range defaultSettings {
+		settings[key] = value
+	}
+
+	rows, err := DB.Query("SELECT key, value FROM settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
+
+// 保存系统设置
+func SetSetting(exec Executor, key, value string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := exec.Exec(
+		"INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at",
+		key, value, localTime,
+	)
+	return err
+}
@@ -0,0 +1,361 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// 物品效果：连续打卡保护卡，兑换后立即到账，错过日常任务时自动使用
+const ItemEffectStreakFreeze = "streak_freeze"
+
+// 默认的连续打卡里程碑，奖励可以在管理页面修改
+var defaultStreakMilestones = []StreakMilestone{
+	{Days: 3, Bonus: 5},
+	{Days: 7, Bonus: 15},
+	{Days: 30, Bonus: 50},
+}
+
+// 连续打卡里程碑，连续完成同一个日常任务达到天数时发放额外奖励
+type StreakMilestone struct {
+	Days  int
+	Bonus int // 额外奖励的绿宝石，0表示不发放
+}
+
+// 玩家在一个日常任务模板上的连续打卡记录
+type Streak struct {
+	TemplateID    int
+	TemplateTitle string
+	PlayerID      int
+	PlayerName    string
+	Current       int              // 当前连续完成的天数
+	Best          int              // 历史最长连续天数
+	NextMilestone *StreakMilestone // 下一个尚未达到的里程碑，没有时为nil
+}
+
+// 距离下一个里程碑还需要连续完成的天数
+func (s Streak) DaysToNext() int {
+	if s.NextMilestone == nil {
+		return 0
+	}
+	return s.NextMilestone.Days - s.Current
+}
+
+// 玩家某个日常任务模板在某一天的完成情况
+type streakDay struct {
+	day      string
+	verified bool // 玩家在这一天有已确认的任务
+	pending  bool // 这一天还有可以领取、正在进行或等待确认的任务
+	open     bool // 这一天有玩家可以领取或由玩家领取的任务，全部被其他玩家领取时为false
+	frozen   bool // 这一天使用了保护卡
+}
+
+// 这一天已经结束且玩家没有完成，也没有使用保护卡
+func (d streakDay) missed() bool {
+	return d.open && !d.pending && !d.verified && !d.frozen
+}
+
+// 按日期顺序计算连续天数，返回最后一天为止的连续天数和最长连续天数
+// 确认的日期加一天，错过的日期中断连续，尚未结束、被其他玩家完成或使用了保护卡的日期不影响
+func walkStreak(days []streakDay) (current, best int) {
+	for _, d := range days {
+		if d.verified {
+			current++
+			if current > best {
+				best = current
+			}
+		} else if d.missed() {
+			current = 0
+		}
+	}
+	return current, best
+}
+
+// 查询玩家日常任务每一天的完成情况，按模板ID分组并按日期排序
+// templateID为0时查询所有模板，任务线生成的任务不计入连续打卡
+func queryStreakDays(exec Executor, playerID, templateID int) (map[int][]streakDay, error) {
+	query := `
+		SELECT template_id, substr(start_time, 1, 10) AS day,
+			MAX(status = 'verified' AND COALESCE(player_id, 0) = ?),
+			MAX(status IN ('available', 'claimed', 'completed') AND (player_id IS NULL OR player_id = ?)),
+			MAX(player_id IS NULL OR player_id = ?),
+			EXISTS (SELECT 1 FROM streak_freezes f WHERE f.player_id = ? AND f.template_id = tasks.template_id AND f.day = substr(tasks.start_time, 1, 10))
+		FROM tasks
+		WHERE type = 'daily' AND template_id IS NOT NULL AND start_time IS NOT NULL AND start_time != '' AND quest_id IS NULL
+			AND (? = 0 OR template_id = ?) AND ` + taskVisibleToPlayer + `
+		GROUP BY template_id, day
+		ORDER BY template_id, day`
+	rows, err := exec.Query(query, playerID, playerID, playerID, playerID, templateID, templateID, playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make(map[int][]streakDay)
+	for rows.Next() {
+		var id int
+		var d streakDay
+		err := rows.Scan(&id, &d.day, &d.verified, &d.pending, &d.open, &d.frozen)
+		if err != nil {
+			return nil, err
+		}
+		days[id] = append(days[id], d)
+	}
+	return days, rows.Err()
+}
+
+// 获取玩家在所有日常任务模板上的连续打卡记录，没有任何任务记录的模板不返回
+func GetPlayerStreaks(playerID int) ([]Streak, error) {
+	player, err := GetPlayerInfo(playerID)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := GetAllTaskTemplatesByType("daily")
+	if err != nil {
+		return nil, err
+	}
+	milestones, err := GetStreakMilestones()
+	if err != nil {
+		return nil, err
+	}
+	days, err := queryStreakDays(DB, playerID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var streaks []Streak
+	for _, template := range templates {
+		templateDays, ok := days[template.ID]
+		if !ok {
+			continue
+		}
+		streak := Streak{
+			TemplateID:    template.ID,
+			TemplateTitle: template.Title,
+			PlayerID:      player.ID,
+			PlayerName:    player.Name,
+		}
+		streak.Current, streak.Best = walkStreak(templateDays)
+		for i := range milestones {
+			if milestones[i].Days > streak.Current && milestones[i].Bonus > 0 {
+				streak.NextMilestone = &milestones[i]
+				break
+			}
+		}
+		streaks = append(streaks, streak)
+	}
+	return streaks, nil
+}
+
+// 获取所有玩家的连续打卡记录，用于管理页面展示
+func GetAllStreaks() ([]Streak, error) {
+	players, err := GetAllPlayers()
+	if err != nil {
+		return nil, err
+	}
+	var streaks []Streak
+	for _, player := range players {
+		playerStreaks, err := GetPlayerStreaks(player.ID)
+		if err != nil {
+			return nil, err
+		}
+		streaks = append(streaks, playerStreaks...)
+	}
+	return streaks, nil
+}
+
+// 确认日常任务后检查是否达到连续打卡里程碑
+// 以任务所在的日期计算连续天数，刚好达到里程碑时返回该里程碑，同一天只会返回一次
+func ReachStreakMilestone(exec Executor, task Task) (*StreakMilestone, error) {
+	if task.Type != "daily" || task.TemplateID == nil || task.PlayerID == nil || task.QuestID != nil || len(task.StartTime) < 10 {
+		return nil, nil
+	}
+	day := task.StartTime[:10]
+
+	days, err := queryStreakDays(exec, *task.PlayerID, *task.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	var untilDay []streakDay
+	for _, d := range days[*task.TemplateID] {
+		if d.day <= day {
+			untilDay = append(untilDay, d)
+		}
+	}
+	current, _ := walkStreak(untilDay)
+
+	var milestone StreakMilestone
+	err = exec.QueryRow("SELECT days, bonus FROM streak_milestones WHERE days = ? AND bonus > 0", current).Scan(&milestone.Days, &milestone.Bonus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := exec.Exec(
+		"INSERT OR IGNORE INTO streak_rewards (player_id, template_id, day, days, bonus, task_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		*task.PlayerID, *task.TemplateID, day, milestone.Days, milestone.Bonus, task.ID, localTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return nil, err
+	}
+	return &milestone, nil
+}
+
+// 获取所有连续打卡里程碑，按天数排序
+func GetStreakMilestones() ([]StreakMilestone, error) {
+	rows, err := DB.Query("SELECT days, bonus FROM streak_milestones ORDER BY days")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var milestones []StreakMilestone
+	for rows.Next() {
+		var milestone StreakMilestone
+		err := rows.Scan(&milestone.Days, &milestone.Bonus)
+		if err != nil {
+			log.Println("扫描连续打卡里程碑失败:", err)
+			continue
+		}
+		milestones = append(milestones, milestone)
+	}
+	return milestones, nil
+}
+
+// 设置连续打卡里程碑的奖励，里程碑不存在时新建
+func SetStreakMilestone(days, bonus int) error {
+	_, err := DB.Exec("INSERT INTO streak_milestones (days, bonus) VALUES (?, ?) ON CONFLICT (days) DO UPDATE SET bonus = excluded.bonus", days, bonus)
+	return err
+}
+
+// 补充默认的连续打卡里程碑，已有的里程碑不会被修改
+func initStreakMilestones() error {
+	for _, milestone := range defaultStreakMilestones {
+		_, err := DB.Exec("INSERT OR IGNORE INTO streak_milestones (days, bonus) VALUES (?, ?)", milestone.Days, milestone.Bonus)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取玩家持有的保护卡数量
+func GetStreakFreezes(playerID int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT streak_freezes FROM players WHERE id = ?", playerID).Scan(&count)
+	return count, err
+}
+
+// 兑换的保护卡批准后直接发放给玩家，兑换记录标记为已发放
+func GrantStreakFreeze(exec Executor, playerID int, exchangeID int) error {
+	_, err := exec.Exec("UPDATE players SET streak_freezes = streak_freezes + 1 WHERE id = ?", playerID)
+	if err != nil {
+		return err
+	}
+	return FulfillExchange(exec, exchangeID)
+}
+
+// 为错过日常任务的玩家自动使用保护卡，在过期任务更新之后调用
+// 只处理最后一次完成之后连续错过的日期，保护卡足够覆盖所有错过的日期时才使用，否则连续打卡中断
+// now为调度器的当前时间，作为保护卡的使用时间
+func ApplyStreakFreezes(now time.Time) error {
+	rows, err := DB.Query("SELECT id, name FROM players WHERE streak_freezes > 0")
+	if err != nil {
+		return err
+	}
+	var players []Player
+	for rows.Next() {
+		var player Player
+		if err := rows.Scan(&player.ID, &player.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		players = append(players, player)
+	}
+	rows.Close()
+
+	for _, player := range players {
+		err := applyPlayerStreakFreezes(player, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyPlayerStreakFreezes(player Player, now time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var remaining int
+	err = tx.QueryRow("SELECT streak_freezes FROM players WHERE id = ?", player.ID).Scan(&remaining)
+	if err != nil {
+		return err
+	}
+	days, err := queryStreakDays(tx, player.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	templateIDs := make([]int, 0, len(days))
+	for templateID := range days {
+		templateIDs = append(templateIDs, templateID)
+	}
+	sort.Ints(templateIDs)
+
+	localTime := now.Format("2006-01-02 15:04:05")
+	used := 0
+	for _, templateID := range templateIDs {
+		templateDays := days[templateID]
+
+		// 从最后一天往前找到最后一次完成或使用保护卡的日期，收集之后错过的日期
+		var missed []string
+		last := len(templateDays) - 1
+		for ; last >= 0; last-- {
+			d := templateDays[last]
+			if d.verified || d.frozen {
+				break
+			}
+			if d.missed() {
+				missed = append(missed, d.day)
+			}
+		}
+		if len(missed) == 0 || len(missed) > remaining {
+			continue
+		}
+		if current, _ := walkStreak(templateDays[:last+1]); current == 0 {
+			continue
+		}
+
+		for _, day := range missed {
+			_, err := tx.Exec("INSERT OR IGNORE INTO streak_freezes (player_id, template_id, day, created_at) VALUES (?, ?, ?, ?)", player.ID, templateID, day, localTime)
+			if err != nil {
+				return err
+			}
+			log.Printf("玩家 %s 在模板 %d 的 %s 使用了保护卡", player.Name, templateID, day)
+		}
+		remaining -= len(missed)
+		used += len(missed)
+	}
+
+	if used == 0 {
+		return nil
+	}
+	_, err = tx.Exec("UPDATE players SET streak_freezes = streak_freezes - ? WHERE id = ?", used, player.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"minecraft-exchange/recurrence"
+)
+
+// 根据ID获取任务模板
+func GetTaskTemplateByID(templateID int) (TaskTemplate, error) {
+	var template TaskTemplate
+	err := DB.QueryRow("SELECT id, title, COALESCE(description, ''), difficulty, type, reward, COALESCE(repeat_days, ''), COALESCE(recurrence_rule, ''), COALESCE(recurrence_start, ''), version, status, COALESCE(paused_until, ''), per_player, required_minutes FROM task_templates WHERE id = ?", templateID).Scan(&template.ID, &template.Title, &template.Description, &template.Difficulty, &template.Type, &template.Reward, &template.RepeatDays, &template.RecurrenceRule, &template.RecurrenceStart, &template.Version, &template.Status, &template.PausedUntil, &template.PerPlayer, &template.RequiredMinutes)
+	return template, err
+}
+
+// 获取模板的重复规则，旧版本模板根据repeat_days生成每周重复的规则
+func (t TaskTemplate) Recurrence() (recurrence.Rule, error) {
+	if t.RecurrenceRule != "" {
+		return recurrence.Parse(t.RecurrenceRule)
+	}
+	if t.RepeatDays != "" {
+		return recurrence.FromWeekdays(t.RepeatDays)
+	}
+	return recurrence.Rule{}, errors.New("任务模板没有设置重复规则")
+}
+
+// 获取模板重复规则的起始日期，旧版本模板没有起始日期，返回零值
+// 旧版本模板只有每周重复且间隔为1，起始日期不影响计算
+func (t TaskTemplate) RecurrenceAnchor() time.Time {
+	anchor, err := time.ParseInLocation(recurrence.DateLayout, t.RecurrenceStart, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return anchor
+}
+
+// 获取模板重复规则的中文描述，用于页面展示
+func (t TaskTemplate) ScheduleText() string {
+	if t.Type != "daily" {
+		return ""
+	}
+	rule, err := t.Recurrence()
+	if err != nil {
+		return t.RepeatDays
+	}
+	return rule.Describe()
+}
+
+// 获取模板重复规则的RRULE文本，旧版本模板根据repeat_days生成，用于编辑表单
+func (t TaskTemplate) RuleText() string {
+	if t.Type != "daily" {
+		return ""
+	}
+	rule, err := t.Recurrence()
+	if err != nil {
+		return ""
+	}
+	return rule.String()
+}
+
+// 任务模板状态
+const (
+	TemplateActive   = "active"   // 正常生成任务
+	TemplatePaused   = "paused"   // 暂停生成任务，可以设置暂停到哪一天
+	TemplateArchived = "archived" // 已归档，不再生成任务，保留用于历史记录和统计
+)
+
+// 判断模板在指定日期是否应该生成任务
+func (t TaskTemplate) ActiveOn(day time.Time) bool {
+	switch t.Status {
+	case TemplateArchived:
+		return false
+	case TemplatePaused:
+		if t.PausedUntil == "" {
+			return false
+		}
+		// 暂停到PausedUntil这一天（包括这一天），之后恢复
+		return day.Format(recurrence.DateLayout) > t.PausedUntil
+	}
+	return true
+}
+
+// 获取模板状态的中文描述，用于页面展示
+func (t TaskTemplate) StatusText() string {
+	switch t.Status {
+	case TemplateArchived:
+		return "已归档"
+	case TemplatePaused:
+		if t.PausedUntil == "" {
+			return "已暂停"
+		}
+		return "暂停至" + t.PausedUntil
+	}
+	return "启用中"
+}
+
+// 设置任务模板状态，pausedUntil只在暂停时有效
+func SetTaskTemplateStatus(templateID int, status string, pausedUntil string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	var until interface{}
+	if status == TemplatePaused && pausedUntil != "" {
+		until = pausedUntil
+	}
+	result, err := DB.Exec("UPDATE task_templates SET status = ?, paused_until = ?, updated_at = ? WHERE id = ?", status, until, localTime, templateID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 将暂停日期已经过去的模板恢复为正常状态，today格式为2006-01-02
+func ResumeExpiredPauses(today string) error {
+	_, err := DB.Exec("UPDATE task_templates SET status = ?, paused_until = NULL WHERE status = ? AND paused_until IS NOT NULL AND paused_until != '' AND paused_until < ?", TemplateActive, TemplatePaused, today)
+	return err
+}
+
+// 获取已归档的任务模板
+func GetArchivedTaskTemplates() ([]TaskTemplate, error) {
+	rows, err := DB.Query("SELECT id, title, COALESCE(description, ''), difficulty, type, reward, COALESCE(repeat_days, ''), COALESCE(recurrence_rule, ''), COALESCE(recurrence_start, ''), version, status, COALESCE(paused_until, ''), per_player, required_minutes FROM task_templates WHERE status = ? ORDER BY updated_at DESC", TemplateArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var taskTemplates []TaskTemplate
+	for rows.Next() {
+		var template TaskTemplate
+		err := rows.Scan(&template.ID, &template.Title, &template.Description, &template.Difficulty, &template.Type, &template.Reward, &template.RepeatDays, &template.RecurrenceRule, &template.RecurrenceStart, &template.Version, &template.Status, &template.PausedUntil, &template.PerPlayer, &template.RequiredMinutes)
+		if err != nil {
+			log.Println("扫描任务模板数据失败:", err)
+			continue
+		}
+		taskTemplates = append(taskTemplates, template)
+	}
+	return taskTemplates, nil
+}
+
+// 根据模板创建任务实例，同时复制模板的清单，occurrenceKey标识模板的某一次重复
+// assignees为可以领取该任务的玩家，为空表示所有玩家
+// 同一模板相同标识的实例已经存在时不会重复创建，返回false
+// 创建时间使用task.CreatedAt，由调度器按自己的时钟设置，为零值时使用当前时间
+func CreateTaskInstance(task Task, occurrenceKey string, assignees []int) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	createdAt := task.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	localTime := createdAt.Format("2006-01-02 15:04:05")
+	result, err := tx.Exec(
+		"INSERT OR IGNORE INTO tasks (title, description, difficulty, type, reward, expiry_time, start_time, template_id, template_version, required_minutes, quest_id, occurrence_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		task.Title, task.Description, task.Difficulty, task.Type, task.Reward, task.ExpiryTime, task.StartTime, task.TemplateID, task.TemplateVersion, task.RequiredMinutes, task.QuestID, occurrenceKey, localTime, localTime,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	taskID, err := result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	for _, playerID := range assignees {
+		_, err := tx.Exec("INSERT INTO task_assignees (task_id, player_id) VALUES (?, ?)", taskID, playerID)
+		if err != nil {
+			return false, err
+		}
+	}
+	if task.TemplateID != nil {
+		err = copyTemplateChecklist(tx, *task.TemplateID, taskID)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// 将截止时间早于now且尚未完成的任务标记为已过期
+func ExpireTasks(now string) error {
+	_, err := DB.Exec("UPDATE tasks SET status = 'expired' WHERE expiry_time < ? AND status NOT IN ('completed', 'verified', 'expired')", now)
+	return err
+}
+
+// 为旧版本数据库中由模板生成的任务补充重复标识
+// 日常任务以开始时间（精确到分钟）为标识，限时任务固定为"limited"
+// 同一次重复如果已经有多个实例，只为最早的一个补充标识
+func backfillOccurrenceKeys() error {
+	_, err := DB.Exec(`
+		UPDATE tasks SET occurrence_key = substr(start_time, 1, 16)
+		WHERE id IN (
+			SELECT MIN(id) FROM tasks
+			WHERE template_id IS NOT NULL AND type = 'daily' AND start_time IS NOT NULL
+			GROUP BY template_id, substr(start_time, 1, 16)
+			HAVING SUM(occurrence_key IS NOT NULL) = 0
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		UPDATE tasks SET occurrence_key = 'limited'
+		WHERE id IN (
+			SELECT MIN(id) FROM tasks
+			WHERE template_id IS NOT NULL AND type = 'limited'
+			GROUP BY template_id
+			HAVING SUM(occurrence_key IS NOT NULL) = 0
+		)
+	`)
+	return err
+}
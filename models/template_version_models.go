@@ -0,0 +1,149 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// 编辑任务模板时模板已被其他人修改
+	ErrTemplateVersionConflict = errors.New("任务模板已被修改，请刷新后重试")
+	// 模板还有未结束的任务实例时不能切换是否按玩家生成
+	ErrPerPlayerInUse = errors.New("模板还有未结束的任务，不能修改是否按玩家生成，请等这些任务结束后再修改")
+)
+
+// 任务模板版本结构体，保存模板某个版本的完整内容
+type TaskTemplateVersion struct {
+	ID                 int
+	TemplateID         int
+	Version            int
+	Title              string
+	Description        string
+	Difficulty         string
+	Type               string
+	Reward             int
+	RepeatDays         string
+	RecurrenceRule     string
+	RecurrenceStart    string
+	AppliedToAvailable bool   // 该版本是否同时更新了当时可领取的任务
+	Checklist          string // 清单文本，每行一个步骤
+	RequiredMinutes    int    // 需要计时的分钟数
+	Actor              string
+	CreatedAt          string
+}
+
+// 记录任务模板的一个版本
+func AddTaskTemplateVersion(exec Executor, template TaskTemplate, appliedToAvailable bool, actor string) error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := exec.Exec(
+		"INSERT INTO task_template_versions (template_id, version, title, description, difficulty, type, reward, repeat_days, recurrence_rule, recurrence_start, applied_to_available, checklist, required_minutes, actor, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		template.ID, template.Version, template.Title, template.Description, template.Difficulty, template.Type, template.Reward, template.RepeatDays, template.RecurrenceRule, template.RecurrenceStart, appliedToAvailable, template.Checklist.Text(), template.RequiredMinutes, actor, localTime,
+	)
+	return err
+}
+
+// 更新任务模板，template.Version为编辑前的版本号
+// 模板在此期间已被修改时返回ErrTemplateVersionConflict，成功时返回新的版本号
+// 模板类型和重复起始日期不能修改
+// 按玩家生成时实例的重复标识带有玩家后缀，切换后同一次重复会再生成一遍
+// 因此模板还有未结束的实例时不能切换，返回ErrPerPlayerInUse
+func UpdateTaskTemplate(exec Executor, template TaskTemplate) (int, error) {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	var perPlayer bool
+	err := exec.QueryRow("SELECT per_player FROM task_templates WHERE id = ?", template.ID).Scan(&perPlayer)
+	if err != nil {
+		return 0, err
+	}
+	if perPlayer != template.PerPlayer {
+		var unfinished bool
+		err := exec.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM tasks WHERE template_id = ? AND occurrence_key IS NOT NULL AND (COALESCE(expiry_time, '') = '' OR expiry_time >= ?))",
+			template.ID, localTime,
+		).Scan(&unfinished)
+		if err != nil {
+			return 0, err
+		}
+		if unfinished {
+			return 0, ErrPerPlayerInUse
+		}
+	}
+
+	result, err := exec.Exec(
+		"UPDATE task_templates SET title = ?, description = ?, difficulty = ?, reward = ?, repeat_days = ?, recurrence_rule = ?, per_player = ?, required_minutes = ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ?",
+		template.Title, template.Description, template.Difficulty, template.Reward, template.RepeatDays, template.RecurrenceRule, template.PerPlayer, template.RequiredMinutes, localTime, template.ID, template.Version,
+	)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, ErrTemplateVersionConflict
+	}
+	return template.Version + 1, nil
+}
+
+// 将模板的新内容和清单应用到该模板生成的、仍可领取的任务上
+// 已领取或已完成的任务保持不变，返回更新的任务数量
+// 模板的清单需要在调用前保存
+func ApplyTemplateToAvailableTasks(exec Executor, template TaskTemplate) (int64, error) {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	result, err := exec.Exec(
+		"UPDATE tasks SET title = ?, description = ?, difficulty = ?, reward = ?, required_minutes = ?, template_version = ?, updated_at = ? WHERE template_id = ? AND status = 'available'",
+		template.Title, template.Description, template.Difficulty, template.Reward, template.RequiredMinutes, template.Version, localTime, template.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = exec.Exec("DELETE FROM task_checklist_items WHERE task_id IN (SELECT id FROM tasks WHERE template_id = ? AND status = 'available')", template.ID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = exec.Exec(`
+		INSERT INTO task_checklist_items (task_id, position, title, required)
+		SELECT t.id, c.position, c.title, c.required
+		FROM tasks t JOIN template_checklist_items c ON c.template_id = t.template_id
+		WHERE t.template_id = ? AND t.status = 'available'
+	`, template.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 获取任务模板的所有版本，最新的版本在前
+func GetTaskTemplateVersions(templateID int) ([]TaskTemplateVersion, error) {
+	rows, err := DB.Query("SELECT id, template_id, version, title, COALESCE(description, ''), difficulty, type, reward, COALESCE(repeat_days, ''), COALESCE(recurrence_rule, ''), COALESCE(recurrence_start, ''), applied_to_available, COALESCE(checklist, ''), required_minutes, COALESCE(actor, ''), created_at FROM task_template_versions WHERE template_id = ? ORDER BY version DESC", templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []TaskTemplateVersion
+	for rows.Next() {
+		var v TaskTemplateVersion
+		err := rows.Scan(&v.ID, &v.TemplateID, &v.Version, &v.Title, &v.Description, &v.Difficulty, &v.Type, &v.Reward, &v.RepeatDays, &v.RecurrenceRule, &v.RecurrenceStart, &v.AppliedToAvailable, &v.Checklist, &v.RequiredMinutes, &v.Actor, &v.CreatedAt)
+		if err != nil {
+			log.Println("扫描任务模板版本数据失败:", err)
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// 为旧版本数据库中的任务模板补充当前版本的记录
+func backfillTemplateVersions() error {
+	localTime := time.Now().Format("2006-01-02 15:04:05")
+	_, err := DB.Exec(`
+		INSERT INTO task_template_versions (template_id, version, title, description, difficulty, type, reward, repeat_days, recurrence_rule, recurrence_start, applied_to_available, actor, created_at)
+		SELECT id, version, title, description, difficulty, type, reward, repeat_days, recurrence_rule, recurrence_start, 0, 'system', ?
+		FROM task_templates
+		WHERE id NOT IN (SELECT template_id FROM task_template_versions)
+	`, localTime)
+	return err
+}
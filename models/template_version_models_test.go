@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateTaskTemplatePerPlayer(t *testing.T) {
+	setupTestDB(t)
+
+	id, err := CreateTaskTemplate(TaskTemplate{
+		Title:           "测试任务",
+		Difficulty:      "easy",
+		Type:            "daily",
+		Reward:          1,
+		RecurrenceRule:  "FREQ=DAILY",
+		RecurrenceStart: "2024-01-01",
+	}, "test")
+	if err != nil {
+		t.Fatal("创建任务模板失败:", err)
+	}
+	templateID := int(id)
+	addInstance := func(expiry time.Time) {
+		t.Helper()
+		_, err := CreateTaskInstance(Task{
+			Title:      "测试任务",
+			Difficulty: "easy",
+			Type:       "daily",
+			Reward:     1,
+			StartTime:  expiry.Format("2006-01-02") + " 00:00:00",
+			ExpiryTime: expiry.Format("2006-01-02 15:04:05"),
+			TemplateID: &templateID,
+		}, expiry.Format("2006-01-02 00:00"), nil)
+		if err != nil {
+			t.Fatal("创建任务实例失败:", err)
+		}
+	}
+	update := func(perPlayer bool) error {
+		t.Helper()
+		template, err := GetTaskTemplateByID(templateID)
+		if err != nil {
+			t.Fatal("查询任务模板失败:", err)
+		}
+		template.PerPlayer = perPlayer
+		_, err = UpdateTaskTemplate(DB, template)
+		return err
+	}
+
+	// 已经结束的实例不会再生成，可以切换
+	addInstance(time.Now().AddDate(0, 0, -1))
+	if err := update(true); err != nil {
+		t.Fatalf("没有未结束的实例时切换失败: %v", err)
+	}
+
+	// 还有未结束的实例时不能切换，其他修改不受影响
+	addInstance(time.Now().AddDate(0, 0, 1))
+	if err := update(false); err != ErrPerPlayerInUse {
+		t.Errorf("有未结束的实例时切换返回 %v，期望 %v", err, ErrPerPlayerInUse)
+	}
+	if err := update(true); err != nil {
+		t.Errorf("不切换时更新失败: %v", err)
+	}
+}
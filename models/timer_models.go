@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 任务不在可以计时的状态
+var ErrTimerNotAllowed = errors.New("只能为已领取、尚未提交的任务计时")
+
+// 已记录的计时秒数，包括正在进行的计时
+func (t Task) LoggedSeconds() int {
+	seconds := t.ElapsedSeconds
+	if t.TimerStartedAt != "" {
+		started, err := time.ParseInLocation("2006-01-02 15:04:05", t.TimerStartedAt, time.Local)
+		if err == nil && time.Now().After(started) {
+			seconds += int(time.Since(started).Seconds())
+		}
+	}
+	return seconds
+}
+
+// 需要计时的秒数
+func (t Task) RequiredSeconds() int {
+	return t.RequiredMinutes * 60
+}
+
+// 是否正在计时
+func (t Task) TimerRunning() bool {
+	return t.TimerStartedAt != ""
+}
+
+// 已记录的计时，格式为"X分Y秒"
+func (t Task) LoggedText() string {
+	return FormatDuration(t.LoggedSeconds())
+}
+
+// 将秒数格式化为"X分Y秒"
+func FormatDuration(seconds int) string {
+	return fmt.Sprintf("%d分%02d秒", seconds/60, seconds%60)
+}
+
+// 开始或继续任务计时，已经在计时时不做任何修改
+func StartTaskTimer(taskID int, now time.Time) error {
+	result, err := DB.Exec(
+		"UPDATE tasks SET timer_started_at = COALESCE(timer_started_at, ?) WHERE id = ? AND status = 'claimed'",
+		now.Format("2006-01-02 15:04:05"), taskID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTimerNotAllowed
+	}
+	return nil
+}
+
+// 暂停任务计时，把本次计时累加到已记录的时间中，没有在计时时不做任何修改
+// 开始时间是本地时间，在Go中计算经过的秒数，跨过夏令时切换时也是实际经过的时间
+func StopTaskTimer(exec Executor, taskID int, now time.Time) error {
+	var startedAt string
+	err := exec.QueryRow("SELECT COALESCE(timer_started_at, '') FROM tasks WHERE id = ?", taskID).Scan(&startedAt)
+	if err != nil || startedAt == "" {
+		return err
+	}
+
+	seconds := 0
+	started, err := time.ParseInLocation("2006-01-02 15:04:05", startedAt, time.Local)
+	if err == nil && now.After(started) {
+		seconds = int(now.Sub(started).Seconds())
+	}
+	// 只在开始时间没有变化时累加，同时暂停时不会重复计算
+	_, err = exec.Exec(
+		"UPDATE tasks SET elapsed_seconds = elapsed_seconds + ?, timer_started_at = NULL WHERE id = ? AND timer_started_at = ?",
+		seconds, taskID, startedAt,
+	)
+	return err
+}
+
+// 获取任务已记录的计时秒数，不包括正在进行的计时
+func GetTaskElapsedSeconds(exec Executor, taskID int) (int, error) {
+	var seconds int
+	err := exec.QueryRow("SELECT elapsed_seconds FROM tasks WHERE id = ?", taskID).Scan(&seconds)
+	return seconds, err
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestStopTaskTimer(t *testing.T) {
+	setupTestDB(t)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := time.Local
+	time.Local = newYork
+	t.Cleanup(func() { time.Local = local })
+
+	var taskID int
+	err = DB.QueryRow("SELECT MIN(id) FROM tasks").Scan(&taskID)
+	if err != nil {
+		t.Fatal("查询任务失败:", err)
+	}
+
+	tests := []struct {
+		name      string
+		startedAt string
+		now       time.Time
+		want      int
+	}{
+		{"普通计时", "2024-01-10 08:00:00", time.Date(2024, 1, 10, 8, 30, 0, 0, newYork), 30 * 60},
+		{"跨过夏令时开始", "2024-03-10 01:30:00", time.Date(2024, 3, 10, 3, 30, 0, 0, newYork), 60 * 60},
+		{"跨过夏令时结束", "2024-11-03 00:30:00", time.Date(2024, 11, 3, 1, 30, 0, 0, time.FixedZone("EST", -5*3600)), 2 * 60 * 60},
+		{"时间倒退时不累加", "2024-01-10 08:00:00", time.Date(2024, 1, 10, 7, 0, 0, 0, newYork), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DB.Exec("UPDATE tasks SET status = 'claimed', timer_started_at = ?, elapsed_seconds = 0 WHERE id = ?", tt.startedAt, taskID)
+			if err != nil {
+				t.Fatal("修改任务失败:", err)
+			}
+			if err := StopTaskTimer(DB, taskID, tt.now); err != nil {
+				t.Fatal("暂停计时失败:", err)
+			}
+			// 再次暂停不会重复累加
+			if err := StopTaskTimer(DB, taskID, tt.now.Add(time.Hour)); err != nil {
+				t.Fatal("暂停计时失败:", err)
+			}
+			seconds, err := GetTaskElapsedSeconds(DB, taskID)
+			if err != nil {
+				t.Fatal("查询计时失败:", err)
+			}
+			if seconds != tt.want {
+				t.Errorf("计时为 %d 秒，期望 %d 秒", seconds, tt.want)
+			}
+		})
+	}
+}
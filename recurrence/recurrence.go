@@ -0,0 +1,416 @@
+// recurrence包实现任务模板的重复规则
+// 规则使用RFC 5545 RRULE的一个子集表示，例如"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;BYHOUR=7,20"
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重复频率
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+// 查找下一次重复时最多向后查找的天数
+const maxLookaheadDays = 5 * 366
+
+// 日期格式，用于保存规则的起始日期
+const DateLayout = "2006-01-02"
+
+// RRULE中的星期缩写
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// 星期的中文名称
+var weekdayNames = []string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"}
+
+// 重复规则
+type Rule struct {
+	Freq       string         // DAILY, WEEKLY, MONTHLY
+	Interval   int            // 每隔几个周期重复一次，最小为1
+	ByDay      []time.Weekday // 星期几，WEEKLY时表示在哪几天重复，其他频率时作为过滤条件
+	ByMonthDay []int          // 每月几号，负数表示倒数第几天，例如-1表示最后一天
+	ByHour     []int          // 每天的哪几个整点开始，为空表示全天一次
+}
+
+// 一次重复对应的任务时间段
+type Occurrence struct {
+	Start time.Time
+	End   time.Time
+}
+
+// 解析RRULE字符串，可以带"RRULE:"前缀
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.ToUpper(s), "RRULE:")
+	if s == "" {
+		return rule, errors.New("重复规则不能为空")
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return rule, fmt.Errorf("无法解析重复规则: %s", part)
+		}
+
+		switch key {
+		case "FREQ":
+			if value != Daily && value != Weekly && value != Monthly {
+				return rule, fmt.Errorf("不支持的重复频率: %s", value)
+			}
+			rule.Freq = value
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 || interval > 366 {
+				return rule, fmt.Errorf("重复间隔必须是1到366之间的整数: %s", value)
+			}
+			rule.Interval = interval
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				weekday, err := parseWeekday(code)
+				if err != nil {
+					return rule, err
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(value, ",") {
+				day, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return rule, fmt.Errorf("每月日期必须是1到31或-1到-31之间的整数: %s", v)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, day)
+			}
+		case "BYHOUR":
+			for _, v := range strings.Split(value, ",") {
+				hour, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil || hour < 0 || hour > 23 {
+					return rule, fmt.Errorf("小时必须是0到23之间的整数: %s", v)
+				}
+				rule.ByHour = append(rule.ByHour, hour)
+			}
+		default:
+			return rule, fmt.Errorf("不支持的重复规则属性: %s", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, errors.New("重复规则缺少FREQ")
+	}
+	return rule.normalize()
+}
+
+// 根据旧版本逗号分隔的星期几（0表示周日）生成每周重复的规则
+func FromWeekdays(days string) (Rule, error) {
+	rule := Rule{Freq: Weekly, Interval: 1}
+	for _, v := range strings.Split(days, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		day, err := strconv.Atoi(v)
+		if err != nil || day < 0 || day > 6 {
+			return rule, fmt.Errorf("无效的星期: %s", v)
+		}
+		rule.ByDay = append(rule.ByDay, time.Weekday(day))
+	}
+	return rule.normalize()
+}
+
+// 检查规则并对列表排序去重
+func (r Rule) normalize() (Rule, error) {
+	if r.Interval < 1 {
+		r.Interval = 1
+	}
+	if r.Freq == Weekly && len(r.ByDay) == 0 {
+		return r, errors.New("每周重复需要选择星期几")
+	}
+	if r.Freq == Monthly && len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		return r, errors.New("每月重复需要选择日期")
+	}
+	if r.Freq != Monthly && len(r.ByMonthDay) > 0 {
+		return r, errors.New("只有每月重复可以指定日期")
+	}
+
+	sort.Slice(r.ByDay, func(i, j int) bool { return weekdayIndex(r.ByDay[i]) < weekdayIndex(r.ByDay[j]) })
+	r.ByDay = uniqueWeekdays(r.ByDay)
+	sort.Ints(r.ByMonthDay)
+	r.ByMonthDay = uniqueInts(r.ByMonthDay)
+	sort.Ints(r.ByHour)
+	r.ByHour = uniqueInts(r.ByHour)
+	return r, nil
+}
+
+// 将规则格式化为RRULE字符串
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			codes[i] = weekdayCodes[day]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByHour) > 0 {
+		parts = append(parts, "BYHOUR="+joinInts(r.ByHour))
+	}
+	return strings.Join(parts, ";")
+}
+
+// 用中文描述规则，用于页面展示
+func (r Rule) Describe() string {
+	var b strings.Builder
+
+	switch r.Freq {
+	case Daily:
+		if r.Interval == 1 {
+			b.WriteString("每天")
+		} else {
+			fmt.Fprintf(&b, "每%d天", r.Interval)
+		}
+		if len(r.ByDay) > 0 {
+			b.WriteString("（仅" + describeWeekdays(r.ByDay) + "）")
+		}
+	case Weekly:
+		if r.Interval == 1 {
+			b.WriteString("每周")
+		} else {
+			fmt.Fprintf(&b, "每%d周的", r.Interval)
+		}
+		b.WriteString(describeWeekdays(r.ByDay))
+	case Monthly:
+		if r.Interval == 1 {
+			b.WriteString("每月")
+		} else {
+			fmt.Fprintf(&b, "每%d个月的", r.Interval)
+		}
+		if len(r.ByMonthDay) > 0 {
+			days := make([]string, len(r.ByMonthDay))
+			for i, day := range r.ByMonthDay {
+				if day == -1 {
+					days[i] = "最后一天"
+				} else if day < 0 {
+					days[i] = fmt.Sprintf("倒数第%d天", -day)
+				} else {
+					days[i] = fmt.Sprintf("%d日", day)
+				}
+			}
+			b.WriteString(strings.Join(days, "、"))
+			if len(r.ByDay) > 0 {
+				b.WriteString("（仅" + describeWeekdays(r.ByDay) + "）")
+			}
+		} else {
+			b.WriteString("每个" + describeWeekdays(r.ByDay))
+		}
+	}
+
+	if len(r.ByHour) > 0 {
+		hours := make([]string, len(r.ByHour))
+		for i, hour := range r.ByHour {
+			hours[i] = fmt.Sprintf("%d:00", hour)
+		}
+		b.WriteString(" " + strings.Join(hours, "、"))
+	}
+	return b.String()
+}
+
+// 获取某一天的所有重复时间段，这一天不符合规则时返回空
+// anchor是规则的起始日期，用于计算INTERVAL，早于anchor的日期不会重复
+func (r Rule) OccurrencesOn(anchor, day time.Time) []Occurrence {
+	if !r.matchesDay(anchor, day) {
+		return nil
+	}
+
+	loc := day.Location()
+	year, month, date := day.Date()
+	endOfDay := time.Date(year, month, date, 23, 59, 59, 0, loc)
+
+	hours := r.ByHour
+	if len(hours) == 0 {
+		hours = []int{0}
+	}
+
+	// 每个时间段从对应的整点开始，到下一个时间段开始前或当天结束时截止
+	occurrences := make([]Occurrence, len(hours))
+	for i, hour := range hours {
+		occurrences[i].Start = time.Date(year, month, date, hour, 0, 0, 0, loc)
+		if i+1 < len(hours) {
+			occurrences[i].End = time.Date(year, month, date, hours[i+1], 0, 0, 0, loc).Add(-time.Second)
+		} else {
+			occurrences[i].End = endOfDay
+		}
+	}
+	return occurrences
+}
+
+// 获取after之后最近的n个尚未结束的重复时间段
+func (r Rule) Next(anchor, after time.Time, n int) []Occurrence {
+	var result []Occurrence
+	day := startOfDay(after)
+	for i := 0; i < maxLookaheadDays && len(result) < n; i++ {
+		for _, occurrence := range r.OccurrencesOn(anchor, day.AddDate(0, 0, i)) {
+			if occurrence.End.After(after) && len(result) < n {
+				result = append(result, occurrence)
+			}
+		}
+	}
+	return result
+}
+
+// 获取after之后第一个有重复的日期中，所有尚未结束的重复时间段
+// 用于一次生成同一天内的多个任务实例
+func (r Rule) NextDay(anchor, after time.Time) []Occurrence {
+	day := startOfDay(after)
+	for i := 0; i < maxLookaheadDays; i++ {
+		var result []Occurrence
+		for _, occurrence := range r.OccurrencesOn(anchor, day.AddDate(0, 0, i)) {
+			if occurrence.End.After(after) {
+				result = append(result, occurrence)
+			}
+		}
+		if len(result) > 0 {
+			return result
+		}
+	}
+	return nil
+}
+
+// 判断某一天是否符合规则
+func (r Rule) matchesDay(anchor, day time.Time) bool {
+	days := daysBetween(anchor, day)
+	if days < 0 {
+		return false
+	}
+
+	if len(r.ByDay) > 0 && !containsWeekday(r.ByDay, day.Weekday()) {
+		return false
+	}
+
+	switch r.Freq {
+	case Daily:
+		return days%r.Interval == 0
+	case Weekly:
+		// 以周一作为每周的第一天计算间隔周数
+		weeks := daysBetween(startOfWeek(anchor), startOfWeek(day)) / 7
+		return weeks%r.Interval == 0
+	case Monthly:
+		months := (day.Year()-anchor.Year())*12 + int(day.Month()) - int(anchor.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByMonthDay) == 0 {
+			return true
+		}
+		daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		for _, monthDay := range r.ByMonthDay {
+			if monthDay < 0 {
+				monthDay = daysInMonth + monthDay + 1
+			}
+			if monthDay == day.Day() {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// 计算两个日期之间相差的天数，只比较日历日期，不受夏令时影响
+func daysBetween(from, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// 获取某一时刻所在日期的零点
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// 获取某一天所在周的周一
+func startOfWeek(t time.Time) time.Time {
+	return startOfDay(t).AddDate(0, 0, -weekdayIndex(t.Weekday()))
+}
+
+// 以周一为0计算星期的序号
+func weekdayIndex(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+func parseWeekday(code string) (time.Weekday, error) {
+	code = strings.TrimSpace(code)
+	for i, c := range weekdayCodes {
+		if c == code {
+			return time.Weekday(i), nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("不支持的星期: %s", code)
+}
+
+// 获取星期的中文名称
+func WeekdayName(day time.Weekday) string {
+	return weekdayNames[day]
+}
+
+func describeWeekdays(days []time.Weekday) string {
+	names := make([]string, len(days))
+	for i, day := range days {
+		names[i] = weekdayNames[day]
+	}
+	return strings.Join(names, "、")
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueWeekdays(days []time.Weekday) []time.Weekday {
+	var result []time.Weekday
+	for i, day := range days {
+		if i == 0 || day != days[i-1] {
+			result = append(result, day)
+		}
+	}
+	return result
+}
+
+func uniqueInts(values []int) []int {
+	var result []int
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
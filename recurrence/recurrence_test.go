@@ -0,0 +1,328 @@
+package recurrence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 生成UTC时间，测试中的日期都使用UTC
+func at(year int, month time.Month, day, hour, min, sec int) time.Time {
+	return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return at(year, month, day, 0, 0, 0)
+}
+
+func mustParse(t *testing.T, s string) Rule {
+	t.Helper()
+	rule, err := Parse(s)
+	if err != nil {
+		t.Fatalf("解析 %q 失败: %v", s, err)
+	}
+	return rule
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+		{"rrule:freq=weekly;byday=we,mo,mo;interval=2", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE"},
+		{"FREQ=WEEKLY;BYDAY=SU,MO", "FREQ=WEEKLY;BYDAY=MO,SU"},
+		{" FREQ=MONTHLY;BYMONTHDAY=15,-1,15 ", "FREQ=MONTHLY;BYMONTHDAY=-1,15"},
+		{"FREQ=MONTHLY;BYDAY=SA", "FREQ=MONTHLY;BYDAY=SA"},
+		{"FREQ=DAILY;BYHOUR=20,7,7", "FREQ=DAILY;BYHOUR=7,20"},
+		{"FREQ=DAILY;;BYDAY=SA,SU;", "FREQ=DAILY;BYDAY=SA,SU"},
+		{"FREQ=DAILY;INTERVAL=366", "FREQ=DAILY;INTERVAL=366"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := mustParse(t, tt.in).String(); got != tt.want {
+				t.Errorf("Parse(%q) = %q，期望 %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"RRULE:",
+		"INTERVAL=2",
+		"FREQ",
+		"FREQ=",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=367",
+		"FREQ=DAILY;INTERVAL=abc",
+		"FREQ=WEEKLY",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY",
+		"FREQ=DAILY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYMONTHDAY=-32",
+		"FREQ=DAILY;BYHOUR=24",
+		"FREQ=DAILY;BYHOUR=-1",
+		"FREQ=DAILY;COUNT=3",
+	}
+	for _, in := range tests {
+		t.Run(in, func(t *testing.T) {
+			if _, err := Parse(in); err == nil {
+				t.Errorf("Parse(%q) 应该返回错误", in)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		want    Rule
+		wantErr bool
+	}{
+		{
+			name: "间隔最小为1，列表排序去重",
+			rule: Rule{Freq: Weekly, Interval: 0, ByDay: []time.Weekday{time.Sunday, time.Monday, time.Sunday}, ByHour: []int{9, 8, 9}},
+			want: Rule{Freq: Weekly, Interval: 1, ByDay: []time.Weekday{time.Monday, time.Sunday}, ByHour: []int{8, 9}},
+		},
+		{
+			name: "每月日期排序去重",
+			rule: Rule{Freq: Monthly, Interval: 2, ByMonthDay: []int{1, -1, 1}},
+			want: Rule{Freq: Monthly, Interval: 2, ByMonthDay: []int{-1, 1}},
+		},
+		{name: "每周缺少星期", rule: Rule{Freq: Weekly, Interval: 1}, wantErr: true},
+		{name: "每月缺少日期", rule: Rule{Freq: Monthly, Interval: 1}, wantErr: true},
+		{name: "每天不能指定日期", rule: Rule{Freq: Daily, Interval: 1, ByMonthDay: []int{1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rule.normalize()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("应该返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("返回错误: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalize() = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromWeekdays(t *testing.T) {
+	rule, err := FromWeekdays("0, 6,1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rule.String(), "FREQ=WEEKLY;BYDAY=MO,SA,SU"; got != want {
+		t.Errorf("FromWeekdays = %q，期望 %q", got, want)
+	}
+	for _, in := range []string{"", "7", "a"} {
+		if _, err := FromWeekdays(in); err == nil {
+			t.Errorf("FromWeekdays(%q) 应该返回错误", in)
+		}
+	}
+}
+
+func TestMatchesDay(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		anchor time.Time
+		day    time.Time
+		want   bool
+	}{
+		// 2024-01-01是周一
+		{"每天", "FREQ=DAILY", date(2024, 1, 1), date(2024, 3, 7), true},
+		{"早于起始日期", "FREQ=DAILY", date(2024, 1, 1), date(2023, 12, 31), false},
+		{"每2天当天", "FREQ=DAILY;INTERVAL=2", date(2024, 1, 1), date(2024, 1, 1), true},
+		{"每2天第二天", "FREQ=DAILY;INTERVAL=2", date(2024, 1, 1), date(2024, 1, 2), false},
+		{"每2天跨月", "FREQ=DAILY;INTERVAL=2", date(2024, 1, 1), date(2024, 2, 1), false},
+		{"每2天跨闰日", "FREQ=DAILY;INTERVAL=2", date(2024, 1, 1), date(2024, 3, 1), true},
+		{"每天仅周末，周六", "FREQ=DAILY;BYDAY=SA,SU", date(2024, 1, 1), date(2024, 1, 6), true},
+		{"每天仅周末，周五", "FREQ=DAILY;BYDAY=SA,SU", date(2024, 1, 1), date(2024, 1, 5), false},
+		{"每2周第一周周五", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", date(2024, 1, 1), date(2024, 1, 5), true},
+		{"每2周第二周周一", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", date(2024, 1, 1), date(2024, 1, 8), false},
+		{"每2周第三周周一", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", date(2024, 1, 1), date(2024, 1, 15), true},
+		{"每2周第三周周日", "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU", date(2024, 1, 1), date(2024, 1, 21), true},
+		{"起始日期在周中，同一周", "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU", date(2024, 1, 3), date(2024, 1, 7), true},
+		{"起始日期在周中，下一周", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", date(2024, 1, 3), date(2024, 1, 8), false},
+		{"起始日期在周中，隔一周", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", date(2024, 1, 3), date(2024, 1, 15), true},
+		{"起始日期在周中，起始前的周一", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", date(2024, 1, 3), date(2024, 1, 1), false},
+		{"最后一天，1月", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2023, 1, 1), date(2024, 1, 31), true},
+		{"最后一天，1月30日", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2023, 1, 1), date(2024, 1, 30), false},
+		{"最后一天，闰年2月29日", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2023, 1, 1), date(2024, 2, 29), true},
+		{"最后一天，闰年2月28日", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2023, 1, 1), date(2024, 2, 28), false},
+		{"最后一天，平年2月28日", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2023, 1, 1), date(2023, 2, 28), true},
+		{"最后一天，4月30日", "FREQ=MONTHLY;BYMONTHDAY=-1", date(2023, 1, 1), date(2024, 4, 30), true},
+		{"倒数第二天，平年2月27日", "FREQ=MONTHLY;BYMONTHDAY=-2", date(2023, 1, 1), date(2023, 2, 27), true},
+		{"31日在2月不重复", "FREQ=MONTHLY;BYMONTHDAY=31", date(2024, 1, 1), date(2024, 2, 29), false},
+		{"31日在3月", "FREQ=MONTHLY;BYMONTHDAY=31", date(2024, 1, 1), date(2024, 3, 31), true},
+		{"每2个月第一个月", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=15", date(2024, 1, 10), date(2024, 1, 15), true},
+		{"每2个月第二个月", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=15", date(2024, 1, 10), date(2024, 2, 15), false},
+		{"每2个月跨年", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=15", date(2024, 1, 10), date(2025, 1, 15), true},
+		{"每月的周六", "FREQ=MONTHLY;BYDAY=SA", date(2024, 1, 1), date(2024, 2, 3), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := mustParse(t, tt.rule)
+			if got := rule.matchesDay(tt.anchor, tt.day); got != tt.want {
+				t.Errorf("%s 在 %s 起始时 %s 的结果为 %v，期望 %v", tt.rule, tt.anchor.Format(DateLayout), tt.day.Format(DateLayout), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOccurrencesOnHours(t *testing.T) {
+	rule := mustParse(t, "FREQ=DAILY;BYHOUR=7,12,20")
+	got := rule.OccurrencesOn(date(2024, 1, 1), date(2024, 1, 2))
+	want := []Occurrence{
+		{at(2024, 1, 2, 7, 0, 0), at(2024, 1, 2, 11, 59, 59)},
+		{at(2024, 1, 2, 12, 0, 0), at(2024, 1, 2, 19, 59, 59)},
+		{at(2024, 1, 2, 20, 0, 0), at(2024, 1, 2, 23, 59, 59)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OccurrencesOn = %v，期望 %v", got, want)
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		anchor time.Time
+		after  time.Time
+		n      int
+		want   []Occurrence
+	}{
+		{
+			name:   "多个整点，包括尚未结束的时间段",
+			rule:   "FREQ=DAILY;BYHOUR=7,20",
+			anchor: date(2024, 1, 1),
+			after:  at(2024, 1, 1, 8, 0, 0),
+			n:      3,
+			want: []Occurrence{
+				{at(2024, 1, 1, 7, 0, 0), at(2024, 1, 1, 19, 59, 59)},
+				{at(2024, 1, 1, 20, 0, 0), at(2024, 1, 1, 23, 59, 59)},
+				{at(2024, 1, 2, 7, 0, 0), at(2024, 1, 2, 19, 59, 59)},
+			},
+		},
+		{
+			name:   "当天的时间段都已结束",
+			rule:   "FREQ=DAILY;BYHOUR=7,20",
+			anchor: date(2024, 1, 1),
+			after:  at(2024, 1, 1, 23, 59, 59),
+			n:      2,
+			want: []Occurrence{
+				{at(2024, 1, 2, 7, 0, 0), at(2024, 1, 2, 19, 59, 59)},
+				{at(2024, 1, 2, 20, 0, 0), at(2024, 1, 2, 23, 59, 59)},
+			},
+		},
+		{
+			name:   "每2周",
+			rule:   "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO",
+			anchor: date(2024, 1, 1),
+			after:  at(2024, 1, 2, 9, 0, 0),
+			n:      2,
+			want: []Occurrence{
+				{date(2024, 1, 15), at(2024, 1, 15, 23, 59, 59)},
+				{date(2024, 1, 29), at(2024, 1, 29, 23, 59, 59)},
+			},
+		},
+		{
+			name:   "每月最后一天跨过闰年2月",
+			rule:   "FREQ=MONTHLY;BYMONTHDAY=-1",
+			anchor: date(2024, 1, 1),
+			after:  date(2024, 2, 1),
+			n:      2,
+			want: []Occurrence{
+				{date(2024, 2, 29), at(2024, 2, 29, 23, 59, 59)},
+				{date(2024, 3, 31), at(2024, 3, 31, 23, 59, 59)},
+			},
+		},
+		{
+			name:   "起始日期之前不重复",
+			rule:   "FREQ=DAILY",
+			anchor: date(2024, 6, 1),
+			after:  date(2024, 1, 1),
+			n:      1,
+			want:   []Occurrence{{date(2024, 6, 1), at(2024, 6, 1, 23, 59, 59)}},
+		},
+		{
+			name:   "没有符合规则的日期",
+			rule:   "FREQ=MONTHLY;BYMONTHDAY=31;BYDAY=MO;INTERVAL=366",
+			anchor: date(2024, 1, 2),
+			after:  date(2024, 1, 2),
+			n:      1,
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustParse(t, tt.rule).Next(tt.anchor, tt.after, tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Next = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextDay(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		anchor time.Time
+		after  time.Time
+		want   []Occurrence
+	}{
+		{
+			name:   "当天还有未结束的时间段",
+			rule:   "FREQ=DAILY;BYHOUR=7,20",
+			anchor: date(2024, 1, 1),
+			after:  at(2024, 1, 1, 21, 0, 0),
+			want:   []Occurrence{{at(2024, 1, 1, 20, 0, 0), at(2024, 1, 1, 23, 59, 59)}},
+		},
+		{
+			name:   "当天都已结束时返回下一天的所有时间段",
+			rule:   "FREQ=DAILY;BYHOUR=7,20",
+			anchor: date(2024, 1, 1),
+			after:  at(2024, 1, 1, 23, 59, 59),
+			want: []Occurrence{
+				{at(2024, 1, 2, 7, 0, 0), at(2024, 1, 2, 19, 59, 59)},
+				{at(2024, 1, 2, 20, 0, 0), at(2024, 1, 2, 23, 59, 59)},
+			},
+		},
+		{
+			name:   "每2天跳过不重复的日期",
+			rule:   "FREQ=DAILY;INTERVAL=2",
+			anchor: date(2024, 1, 1),
+			after:  at(2024, 1, 2, 10, 0, 0),
+			want:   []Occurrence{{date(2024, 1, 3), at(2024, 1, 3, 23, 59, 59)}},
+		},
+		{
+			name:   "没有符合规则的日期",
+			rule:   "FREQ=MONTHLY;BYMONTHDAY=31;BYDAY=MO;INTERVAL=366",
+			anchor: date(2024, 1, 2),
+			after:  date(2024, 1, 2),
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustParse(t, tt.rule).NextDay(tt.anchor, tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NextDay = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"minecraft-exchange/models"
+)
+
+// 日常任务刷新的定时任务名称
+const DailyRefreshJob = "daily_refresh"
+
+// 检查定时任务是否到期的间隔
+// 使用轮询而不是长时间休眠，电脑休眠唤醒后也能及时发现错过的周期
+const jobPollInterval = time.Minute
+
+// 启动时最多补跑的周期数，避免长时间停机后一次生成过多任务
+const maxCatchUpPeriods = 31
+
+// 定时任务定义
+type job struct {
+	name  string
+	title string
+	// 计算给定时间之后的下一个计划运行时间
+	next func(after time.Time) time.Time
+	// 以计划运行时间作为当前时间执行任务
+	run func(s *Scheduler) error
+}
+
+// 所有需要定时运行的任务
+var jobs = []job{
+	{
+		name:  DailyRefreshJob,
+		title: "每日零点刷新日常任务",
+		next:  nextMidnight,
+		run:   (*Scheduler).RefreshDailyTasks,
+	},
+}
+
+// 获取定时任务的显示名称
+func JobTitle(name string) string {
+	for _, j := range jobs {
+		if j.name == name {
+			return j.title
+		}
+	}
+	return name
+}
+
+// 给定时间之后的下一个零点，按日期计算，夏令时切换时不会偏移
+func nextMidnight(after time.Time) time.Time {
+	return time.Date(after.Year(), after.Month(), after.Day()+1, 0, 0, 0, 0, after.Location())
+}
+
+// 固定时间的时钟，补跑错过的周期时使用
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// 启动定时任务：立即补跑停机期间错过的周期，之后定期检查是否到期
+func (s *Scheduler) StartJobs() {
+	go func() {
+		s.RunDueJobs()
+
+		ticker := time.NewTicker(jobPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.RunDueJobs()
+		}
+	}()
+}
+
+// 运行所有已经到期的定时任务，包括错过的周期
+func (s *Scheduler) RunDueJobs() {
+	for _, j := range jobs {
+		if err := s.runJob(j); err != nil {
+			log.Printf("定时任务 %s 运行失败: %v", j.name, err)
+		}
+	}
+}
+
+// 按顺序运行一个定时任务所有到期的周期
+// 每个周期都以计划时间作为当前时间运行，运行成功后才推进下一次运行时间
+func (s *Scheduler) runJob(j job) error {
+	now := s.clock.Now()
+
+	state, err := models.GetJob(j.name)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 第一次运行，从下一个周期开始计划
+		return models.EnsureJob(j.name, j.next(now).Format(timeLayout))
+	}
+	if err != nil {
+		return fmt.Errorf("查询定时任务状态失败: %w", err)
+	}
+
+	next, err := time.ParseInLocation(timeLayout, state.NextRun, now.Location())
+	if err != nil {
+		return fmt.Errorf("解析下一次运行时间失败: %w", err)
+	}
+
+	// 收集所有已经到期的周期
+	var periods []time.Time
+	for period := next; !period.After(now); period = j.next(period) {
+		periods = append(periods, period)
+	}
+	if len(periods) > maxCatchUpPeriods {
+		log.Printf("定时任务 %s 错过了 %d 个周期，只补跑最近的 %d 个", j.name, len(periods), maxCatchUpPeriods)
+		periods = periods[len(periods)-maxCatchUpPeriods:]
+	}
+	if len(periods) > 1 {
+		log.Printf("定时任务 %s 补跑错过的 %d 个周期，从 %s 开始", j.name, len(periods), periods[0].Format(timeLayout))
+	}
+
+	for _, period := range periods {
+		runErr := j.run(New(fixedClock(period)))
+		if runErr != nil {
+			// 记录错误并停止，下次检查时从这个周期重试
+			if err := models.RecordJobFailure(j.name, runErr); err != nil {
+				log.Println("记录定时任务失败状态失败:", err)
+			}
+			return runErr
+		}
+
+		err := models.RecordJobSuccess(j.name, period.Format(timeLayout), j.next(period).Format(timeLayout))
+		if err != nil {
+			return fmt.Errorf("记录定时任务运行状态失败: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+
+	"minecraft-exchange/models"
+)
+
+// 为每个玩家生成任务线当前步骤的任务
+// 玩家在当前步骤已经有未结束的任务时跳过，步骤任务过期后会重新生成
+// 步骤模板已暂停、已归档或没有指派给该玩家时不生成，玩家停在这一步
+// 可被创建任务线、确认任务和定时刷新调用，重复调用是安全的
+func (s *Scheduler) UnlockQuestSteps() error {
+	progresses, err := models.GetAllQuestProgress()
+	if err != nil {
+		return fmt.Errorf("查询任务线进度失败: %w", err)
+	}
+
+	now := s.clock.Now()
+	for _, progress := range progresses {
+		if progress.CompletedAt != "" || progress.CurrentStep > len(progress.Quest.Steps) {
+			continue
+		}
+		step := progress.Quest.Steps[progress.CurrentStep-1]
+
+		open, lastTaskID, err := models.GetQuestStepTasks(progress.Quest.ID, step.TemplateID, progress.PlayerID)
+		if err != nil {
+			return fmt.Errorf("查询任务线任务失败: %w", err)
+		}
+		if open {
+			continue
+		}
+
+		template, err := models.GetTaskTemplateByID(step.TemplateID)
+		if err != nil {
+			log.Printf("查询任务线 %d 第%d步的模板失败: %v", progress.Quest.ID, step.Position, err)
+			continue
+		}
+		if !template.ActiveOn(now) {
+			continue
+		}
+		assigned, err := templateAssignedTo(template.ID, progress.PlayerID)
+		if err != nil {
+			return err
+		}
+		if !assigned {
+			continue
+		}
+
+		templateID := template.ID
+		questID := progress.Quest.ID
+		task := models.Task{
+			Title:       template.Title,
+			Description: template.Description,
+			Difficulty:  template.Difficulty,
+			Type:        template.Type,
+			Reward:      template.Reward,
+			ExpiryTime:  now.AddDate(0, 0, progress.Quest.StepDays).Format(timeLayout),
+			Status:      "available",
+			TemplateID:  &templateID,
+			CreatedAt:   now,
+			StartTime:   now.Format(timeLayout),
+
+			TemplateVersion: template.Version,
+			RequiredMinutes: template.RequiredMinutes,
+			QuestID:         &questID,
+		}
+
+		// 标识由任务线、步骤、玩家和这一步上一个任务的ID决定
+		// 同时解锁时生成相同的标识，由唯一索引保证只创建一个任务；过期后重新生成时上一个任务不同，标识也不同
+		key := fmt.Sprintf("quest=%d#step=%d#player=%d#after=%d", questID, step.Position, progress.PlayerID, lastTaskID)
+		created, err := models.CreateTaskInstance(task, key, []int{progress.PlayerID})
+		if err != nil {
+			return fmt.Errorf("创建任务线任务失败: %w", err)
+		}
+		if created {
+			log.Printf("为玩家 %s 解锁任务线 '%s' 第%d步: %s", progress.PlayerName, progress.Quest.Title, step.Position, template.Title)
+		}
+	}
+	return nil
+}
+
+// 判断模板是否指派给了玩家，没有指派玩家的模板属于所有玩家
+func templateAssignedTo(templateID, playerID int) (bool, error) {
+	assignees, err := models.GetTemplateAssignees(templateID)
+	if err != nil {
+		return false, fmt.Errorf("查询模板指派玩家失败: %w", err)
+	}
+	if len(assignees) == 0 {
+		return true, nil
+	}
+	for _, assignee := range assignees {
+		if assignee == playerID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"minecraft-exchange/models"
+)
+
+// 创建只有一步的任务线，返回步骤模板
+func createQuest(t *testing.T, assignees []int, status string) models.TaskTemplate {
+	t.Helper()
+	template := createDailyTemplate(t, "FREQ=DAILY")
+	err := models.SetTemplateAssignees(models.DB, template.ID, assignees)
+	if err != nil {
+		t.Fatal("设置模板指派玩家失败:", err)
+	}
+	err = models.SetTaskTemplateStatus(template.ID, status, "")
+	if err != nil {
+		t.Fatal("设置模板状态失败:", err)
+	}
+	_, err = models.CreateQuest(models.Quest{Title: "测试任务线", StepDays: 3}, []int{template.ID})
+	if err != nil {
+		t.Fatal("创建任务线失败:", err)
+	}
+	return template
+}
+
+// 统计玩家在模板上的任务数量
+func countPlayerTasks(t *testing.T, templateID, playerID int) int {
+	t.Helper()
+	var count int
+	err := models.DB.QueryRow("SELECT COUNT(*) FROM tasks t JOIN task_assignees a ON a.task_id = t.id WHERE t.template_id = ? AND a.player_id = ?", templateID, playerID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUnlockQuestSteps(t *testing.T) {
+	setupTestDB(t)
+	id, err := models.CreatePlayer("测试玩家", 0, "test")
+	if err != nil {
+		t.Fatal("创建玩家失败:", err)
+	}
+	player1, player2 := 1, int(id)
+
+	tests := []struct {
+		name      string
+		assignees []int
+		status    string
+		want      map[int]int // 每个玩家的任务数量
+	}{
+		{"没有指派时所有玩家解锁", nil, models.TemplateActive, map[int]int{player1: 1, player2: 1}},
+		{"只为指派的玩家解锁", []int{player2}, models.TemplateActive, map[int]int{player1: 0, player2: 1}},
+		{"已暂停的模板不解锁", nil, models.TemplatePaused, map[int]int{player1: 0, player2: 0}},
+		{"已归档的模板不解锁", nil, models.TemplateArchived, map[int]int{player1: 0, player2: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := createQuest(t, tt.assignees, tt.status)
+
+			// 在不同时刻同时解锁也只生成一个任务
+			now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.Local)
+			var wg sync.WaitGroup
+			errs := make([]error, 5)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = New(fixedClock(now.Add(time.Duration(i) * time.Second))).UnlockQuestSteps()
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					t.Fatal("解锁任务线失败:", err)
+				}
+			}
+			for playerID, want := range tt.want {
+				if got := countPlayerTasks(t, template.ID, playerID); got != want {
+					t.Errorf("玩家 %d 有 %d 个任务，期望 %d 个", playerID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnlockQuestStepsAfterExpiry(t *testing.T) {
+	setupTestDB(t)
+	template := createQuest(t, nil, models.TemplateActive)
+	const playerID = 1
+
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.Local)
+	if err := New(fixedClock(now)).UnlockQuestSteps(); err != nil {
+		t.Fatal("解锁任务线失败:", err)
+	}
+	_, err := models.DB.Exec("UPDATE tasks SET status = 'expired' WHERE template_id = ?", template.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 过期后重新生成一次，同一时刻重复调用不会再生成
+	later := now.AddDate(0, 0, 3)
+	for i := 0; i < 2; i++ {
+		if err := New(fixedClock(later)).UnlockQuestSteps(); err != nil {
+			t.Fatal("解锁任务线失败:", err)
+		}
+	}
+	if got := countPlayerTasks(t, template.ID, playerID); got != 2 {
+		t.Errorf("玩家有 %d 个任务，期望 2 个", got)
+	}
+}
@@ -0,0 +1,310 @@
+// scheduler包负责根据任务模板生成任务实例，以及每天定时刷新日常任务
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"minecraft-exchange/models"
+	"minecraft-exchange/recurrence"
+)
+
+// 时间格式，与数据库中保存的时间格式一致
+const timeLayout = "2006-01-02 15:04:05"
+
+// 限时任务只会生成一个实例，使用固定的重复标识
+const limitedOccurrenceKey = "limited"
+
+// 时钟接口，便于在测试或补跑时指定当前时间
+type Clock interface {
+	Now() time.Time
+}
+
+// 系统时钟
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// 使用系统时钟的时钟实例
+var SystemClock Clock = systemClock{}
+
+// 任务调度器
+type Scheduler struct {
+	clock Clock
+}
+
+// 创建使用指定时钟的调度器
+func New(clock Clock) *Scheduler {
+	return &Scheduler{clock: clock}
+}
+
+// 使用系统时钟的默认调度器
+var Default = New(SystemClock)
+
+// 根据模板创建任务实例，可被创建模板、提交任务和定时刷新调用
+// 日常任务按照重复规则生成，限时任务只生成一个实例
+// 同一模板的同一次重复只会生成一个实例，重复调用是安全的
+// 已暂停或已归档的模板不会生成实例，属于任务线的模板由UnlockQuestSteps按玩家进度生成
+func (s *Scheduler) CreateTaskInstancesFromTemplate(templateID int, expiryTimeForLimited string, startTimeForLimited string) error {
+	template, err := models.GetTaskTemplateByID(templateID)
+	if err != nil {
+		return fmt.Errorf("查询任务模板失败: %w", err)
+	}
+
+	switch template.Type {
+	case "daily":
+		return s.CreateRecurringTaskInstances(template)
+	case "limited":
+		return s.createLimitedTaskInstance(template, expiryTimeForLimited, startTimeForLimited)
+	}
+	return nil
+}
+
+// 根据日常任务模板的重复规则创建任务实例
+// 找到当前时间之后第一个有重复的日期，为这一天中尚未结束的每个时间段创建任务实例
+// 模板暂停或处于假期中的日期不创建实例
+func (s *Scheduler) CreateRecurringTaskInstances(template models.TaskTemplate) error {
+	rule, err := template.Recurrence()
+	if err != nil {
+		return err
+	}
+
+	// 属于任务线的模板不按重复规则生成
+	inQuest, err := models.IsQuestTemplate(template.ID)
+	if err != nil {
+		return fmt.Errorf("查询任务线失败: %w", err)
+	}
+	if inQuest {
+		return nil
+	}
+
+	targets, err := instanceTargets(template)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	for _, occurrence := range rule.NextDay(template.RecurrenceAnchor(), now) {
+		// 模板在这一天暂停或已归档时跳过
+		if !template.ActiveOn(occurrence.Start) {
+			continue
+		}
+
+		// 这一天处于假期中时跳过
+		day := occurrence.Start.Format(recurrence.DateLayout)
+		blackedOut, err := models.IsTemplateBlackedOut(template.ID, day)
+		if err != nil {
+			return fmt.Errorf("查询假期失败: %w", err)
+		}
+		if blackedOut {
+			continue
+		}
+
+		templateID := template.ID
+		task := models.Task{
+			Title:       template.Title,
+			Description: template.Description,
+			Difficulty:  template.Difficulty,
+			Type:        "daily",
+			Reward:      template.Reward,
+			ExpiryTime:  occurrence.End.Format(timeLayout),
+			Status:      "available",
+			TemplateID:  &templateID,
+			CreatedAt:   now,
+			StartTime:   occurrence.Start.Format(timeLayout),
+
+			TemplateVersion: template.Version,
+			RequiredMinutes: template.RequiredMinutes,
+		}
+
+		for _, target := range targets {
+			// 按玩家生成的实例跳过处于该玩家假期中的日期
+			if template.PerPlayer {
+				blackedOut, err := models.IsPlayerBlackedOut(template.ID, target.players[0], day)
+				if err != nil {
+					return fmt.Errorf("查询假期失败: %w", err)
+				}
+				if blackedOut {
+					continue
+				}
+			}
+
+			// 以重复的开始时间作为标识，同一时间段已经有实例时不会重复创建
+			created, err := models.CreateTaskInstance(task, occurrenceKey(occurrence.Start)+target.keySuffix, target.players)
+			if err != nil {
+				return fmt.Errorf("创建日常任务实例失败: %w", err)
+			}
+			if created {
+				log.Printf("成功创建日常任务 '%s' 实例，开始时间: %s%s", template.Title, task.StartTime, target.keySuffix)
+			}
+		}
+	}
+	return nil
+}
+
+// 为限时任务模板创建唯一的任务实例
+func (s *Scheduler) createLimitedTaskInstance(template models.TaskTemplate, expiryTime string, startTime string) error {
+	if !template.ActiveOn(s.clock.Now()) {
+		return nil
+	}
+
+	// 属于任务线的模板按玩家进度生成
+	inQuest, err := models.IsQuestTemplate(template.ID)
+	if err != nil {
+		return fmt.Errorf("查询任务线失败: %w", err)
+	}
+	if inQuest {
+		return nil
+	}
+
+	var startTimeStr string
+	if startTime != "" {
+		// 使用用户设置的开始时间，尝试多种常见格式解析
+		parsedTime, err := ParseFormTime(startTime)
+		if err != nil {
+			// 不自动使用当前时间，而是返回错误，确保用户知道开始时间设置有问题
+			return fmt.Errorf("解析开始时间失败: %w, 原始值: %s", err, startTime)
+		}
+		startTimeStr = parsedTime.Format(timeLayout)
+	} else {
+		// 如果没有设置开始时间，则使用当前时间
+		startTimeStr = s.clock.Now().Format(timeLayout)
+	}
+
+	templateID := template.ID
+	task := models.Task{
+		Title:       template.Title,
+		Description: template.Description,
+		Difficulty:  template.Difficulty,
+		Type:        template.Type,
+		Reward:      template.Reward,
+		ExpiryTime:  expiryTime,
+		Status:      "available",
+		TemplateID:  &templateID,
+		CreatedAt:   s.clock.Now(),
+		StartTime:   startTimeStr,
+
+		TemplateVersion: template.Version,
+		RequiredMinutes: template.RequiredMinutes,
+	}
+
+	targets, err := instanceTargets(template)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		created, err := models.CreateTaskInstance(task, limitedOccurrenceKey+target.keySuffix, target.players)
+		if err != nil {
+			return fmt.Errorf("创建限时任务实例失败: %w", err)
+		}
+		if created {
+			log.Printf("成功创建限时任务 '%s' 实例，开始时间: %s%s", template.Title, startTimeStr, target.keySuffix)
+		}
+	}
+	return nil
+}
+
+// 任务实例的指派对象
+type instanceTarget struct {
+	keySuffix string // 附加在重复标识后，区分同一次重复中不同玩家的实例
+	players   []int  // 可以领取实例的玩家，为空表示所有玩家
+}
+
+// 根据模板的指派方式决定每次重复生成哪些实例
+// 按玩家生成时每个玩家一个实例，没有指派玩家时为所有玩家各生成一个；否则所有指派的玩家共用一个实例
+func instanceTargets(template models.TaskTemplate) ([]instanceTarget, error) {
+	assignees, err := models.GetTemplateAssignees(template.ID)
+	if err != nil {
+		return nil, fmt.Errorf("查询模板指派玩家失败: %w", err)
+	}
+	if !template.PerPlayer {
+		return []instanceTarget{{players: assignees}}, nil
+	}
+
+	if len(assignees) == 0 {
+		players, err := models.GetAllPlayers()
+		if err != nil {
+			return nil, fmt.Errorf("查询玩家失败: %w", err)
+		}
+		for _, player := range players {
+			assignees = append(assignees, player.ID)
+		}
+	}
+
+	var targets []instanceTarget
+	for _, playerID := range assignees {
+		targets = append(targets, instanceTarget{
+			keySuffix: fmt.Sprintf("#player=%d", playerID),
+			players:   []int{playerID},
+		})
+	}
+	return targets, nil
+}
+
+// 刷新日常任务：为所有日常任务模板生成任务实例，并将过期的任务标记为expired
+func (s *Scheduler) RefreshDailyTasks() error {
+	log.Println("开始刷新日常任务")
+
+	// 暂停日期已经过去的模板恢复正常
+	err := models.ResumeExpiredPauses(s.clock.Now().Format(recurrence.DateLayout))
+	if err != nil {
+		return fmt.Errorf("恢复暂停的任务模板失败: %w", err)
+	}
+
+	// 查询所有未归档的日常任务模板
+	taskTemplates, err := models.GetAllTaskTemplatesByType("daily")
+	if err != nil {
+		return fmt.Errorf("查询日常任务模板失败: %w", err)
+	}
+
+	// 处理每个日常任务模板，单个模板失败不影响其他模板
+	for _, template := range taskTemplates {
+		err := s.CreateRecurringTaskInstances(template)
+		if err != nil {
+			log.Printf("刷新任务模板ID %d 失败: %v", template.ID, err)
+		}
+	}
+
+	// 更新过期的任务状态
+	err = models.ExpireTasks(s.clock.Now().Format(timeLayout))
+	if err != nil {
+		return fmt.Errorf("更新过期任务状态失败: %w", err)
+	}
+
+	// 错过日常任务的玩家自动使用保护卡
+	err = models.ApplyStreakFreezes(s.clock.Now())
+	if err != nil {
+		return fmt.Errorf("使用连续打卡保护卡失败: %w", err)
+	}
+
+	// 为任务线补充当前步骤的任务，包括刚刚过期的步骤
+	err = s.UnlockQuestSteps()
+	if err != nil {
+		return fmt.Errorf("解锁任务线步骤失败: %w", err)
+	}
+
+	log.Println("日常任务刷新完成")
+	return nil
+}
+
+// 日常任务实例的重复标识，精确到分钟
+func occurrenceKey(start time.Time) string {
+	return start.Format("2006-01-02 15:04")
+}
+
+// 解析表单提交的时间，支持标准格式和datetime-local格式
+func ParseFormTime(value string) (time.Time, error) {
+	parsedTime, err := time.ParseInLocation(timeLayout, value, time.Local)
+	if err != nil {
+		// 尝试带T的ISO格式
+		parsedTime, err = time.ParseInLocation("2006-01-02T15:04:05", value, time.Local)
+	}
+	if err != nil {
+		// 尝试datetime-local格式（不带秒）
+		parsedTime, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+	}
+	return parsedTime, err
+}
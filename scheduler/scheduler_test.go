@@ -0,0 +1,322 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"minecraft-exchange/models"
+)
+
+// 使用临时数据库初始化models.DB，测试结束后关闭
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	t.Setenv("ADMIN_USERNAME", "")
+	t.Setenv("ADMIN_PASSWORD", "")
+	models.InitDB()
+	t.Cleanup(func() { models.DB.Close() })
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// 创建一个日常任务模板，重复规则从2024-01-01（周一）开始
+func createDailyTemplate(t *testing.T, rule string) models.TaskTemplate {
+	t.Helper()
+	id, err := models.CreateTaskTemplate(models.TaskTemplate{
+		Title:           "测试任务",
+		Difficulty:      "easy",
+		Type:            "daily",
+		Reward:          1,
+		RecurrenceRule:  rule,
+		RecurrenceStart: "2024-01-01",
+	}, "test")
+	if err != nil {
+		t.Fatal("创建任务模板失败:", err)
+	}
+	template, err := models.GetTaskTemplateByID(int(id))
+	if err != nil {
+		t.Fatal("查询任务模板失败:", err)
+	}
+	return template
+}
+
+// 生成的任务实例的时间
+type instanceTimes struct {
+	Start  string
+	Expiry string
+}
+
+// 查询模板生成的任务实例，检查创建时间都等于now
+func templateInstances(t *testing.T, templateID int, now time.Time) []instanceTimes {
+	t.Helper()
+	rows, err := models.DB.Query("SELECT start_time, expiry_time, CAST(created_at AS TEXT) FROM tasks WHERE template_id = ? ORDER BY start_time", templateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var instances []instanceTimes
+	for rows.Next() {
+		var instance instanceTimes
+		var createdAt string
+		if err := rows.Scan(&instance.Start, &instance.Expiry, &createdAt); err != nil {
+			t.Fatal(err)
+		}
+		if createdAt != now.Format(timeLayout) {
+			t.Errorf("任务创建时间为 %s，期望使用调度器的时间 %s", createdAt, now.Format(timeLayout))
+		}
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+func TestNextMidnight(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+
+	tests := []struct {
+		name  string
+		after time.Time
+		want  time.Time
+	}{
+		{"白天", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"零点前一秒", time.Date(2024, 1, 1, 23, 59, 59, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"正好零点", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"跨年", time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"闰日", time.Date(2024, 2, 28, 8, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"夏令时开始前一天", time.Date(2024, 3, 9, 12, 0, 0, 0, newYork), time.Date(2024, 3, 10, 0, 0, 0, 0, newYork)},
+		{"夏令时开始当天，只有23小时", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 11, 0, 0, 0, 0, newYork)},
+		{"夏令时结束当天，有25小时", time.Date(2024, 11, 3, 1, 30, 0, 0, newYork), time.Date(2024, 11, 4, 0, 0, 0, 0, newYork)},
+		{"东八区", time.Date(2024, 1, 7, 16, 30, 0, 0, time.UTC).In(shanghai), time.Date(2024, 1, 9, 0, 0, 0, 0, shanghai)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextMidnight(tt.after)
+			if !got.Equal(tt.want) || got.Location() != tt.want.Location() {
+				t.Errorf("nextMidnight(%s) = %s，期望 %s", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateRecurringTaskInstances(t *testing.T) {
+	setupTestDB(t)
+	newYork := mustLoadLocation(t, "America/New_York")
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+
+	tests := []struct {
+		name string
+		rule string
+		now  time.Time
+		want []instanceTimes
+	}{
+		{
+			name: "周日深夜生成周一的任务",
+			rule: "FREQ=WEEKLY;BYDAY=MO",
+			now:  time.Date(2024, 1, 7, 23, 59, 59, 0, time.UTC),
+			want: []instanceTimes{{"2024-01-08 00:00:00", "2024-01-08 23:59:59"}},
+		},
+		{
+			name: "周一零点生成当天的任务",
+			rule: "FREQ=WEEKLY;BYDAY=MO",
+			now:  time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+			want: []instanceTimes{{"2024-01-08 00:00:00", "2024-01-08 23:59:59"}},
+		},
+		{
+			name: "周一最后一秒时当天已经结束",
+			rule: "FREQ=WEEKLY;BYDAY=MO",
+			now:  time.Date(2024, 1, 8, 23, 59, 59, 0, time.UTC),
+			want: []instanceTimes{{"2024-01-15 00:00:00", "2024-01-15 23:59:59"}},
+		},
+		{
+			name: "工作日规则在周五结束后跳到周一",
+			rule: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+			now:  time.Date(2024, 1, 5, 23, 59, 59, 0, time.UTC),
+			want: []instanceTimes{{"2024-01-08 00:00:00", "2024-01-08 23:59:59"}},
+		},
+		{
+			name: "每2周的周日属于第一周",
+			rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU",
+			now:  time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC),
+			want: []instanceTimes{{"2024-01-07 00:00:00", "2024-01-07 23:59:59"}},
+		},
+		{
+			name: "零点前一秒生成第二天的所有时间段",
+			rule: "FREQ=DAILY;BYHOUR=7,20",
+			now:  time.Date(2024, 1, 1, 23, 59, 59, 0, time.UTC),
+			want: []instanceTimes{
+				{"2024-01-02 07:00:00", "2024-01-02 19:59:59"},
+				{"2024-01-02 20:00:00", "2024-01-02 23:59:59"},
+			},
+		},
+		{
+			name: "零点生成当天的所有时间段",
+			rule: "FREQ=DAILY;BYHOUR=7,20",
+			now:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			want: []instanceTimes{
+				{"2024-01-02 07:00:00", "2024-01-02 19:59:59"},
+				{"2024-01-02 20:00:00", "2024-01-02 23:59:59"},
+			},
+		},
+		{
+			name: "当天只剩最后一个时间段",
+			rule: "FREQ=DAILY;BYHOUR=7,20",
+			now:  time.Date(2024, 1, 2, 20, 30, 0, 0, time.UTC),
+			want: []instanceTimes{{"2024-01-02 20:00:00", "2024-01-02 23:59:59"}},
+		},
+		{
+			name: "夏令时开始当天按本地时间生成",
+			rule: "FREQ=DAILY;BYHOUR=7,20",
+			now:  time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			want: []instanceTimes{
+				{"2024-03-10 07:00:00", "2024-03-10 19:59:59"},
+				{"2024-03-10 20:00:00", "2024-03-10 23:59:59"},
+			},
+		},
+		{
+			name: "夏令时结束当天按本地时间生成",
+			rule: "FREQ=DAILY",
+			now:  time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			want: []instanceTimes{{"2024-11-03 00:00:00", "2024-11-03 23:59:59"}},
+		},
+		{
+			name: "跨过夏令时的每周任务",
+			rule: "FREQ=WEEKLY;BYDAY=SU",
+			now:  time.Date(2024, 3, 9, 12, 0, 0, 0, newYork),
+			want: []instanceTimes{{"2024-03-10 00:00:00", "2024-03-10 23:59:59"}},
+		},
+		{
+			name: "按时钟所在时区的日期计算星期",
+			rule: "FREQ=WEEKLY;BYDAY=MO",
+			// UTC时间还是周日，东八区已经是周一
+			now:  time.Date(2024, 1, 7, 16, 30, 0, 0, time.UTC).In(shanghai),
+			want: []instanceTimes{{"2024-01-08 00:00:00", "2024-01-08 23:59:59"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := createDailyTemplate(t, tt.rule)
+			err := New(fixedClock(tt.now)).CreateRecurringTaskInstances(template)
+			if err != nil {
+				t.Fatal("生成任务实例失败:", err)
+			}
+			got := templateInstances(t, template.ID, tt.now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("生成的任务实例为 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunJobCatchUpAcrossDST(t *testing.T) {
+	setupTestDB(t)
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	const name = "test_job"
+	err := models.EnsureJob(name, "2024-03-09 00:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs []string
+	j := job{
+		name: name,
+		next: nextMidnight,
+		run: func(s *Scheduler) error {
+			runs = append(runs, s.clock.Now().Format(timeLayout+" MST"))
+			return nil
+		},
+	}
+	now := time.Date(2024, 3, 12, 8, 0, 0, 0, newYork)
+	if err := New(fixedClock(now)).runJob(j); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"2024-03-09 00:00:00 EST",
+		"2024-03-10 00:00:00 EST",
+		"2024-03-11 00:00:00 EDT",
+		"2024-03-12 00:00:00 EDT",
+	}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("补跑的周期为 %v，期望 %v", runs, want)
+	}
+	state, err := models.GetJob(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NextRun != "2024-03-13 00:00:00" {
+		t.Errorf("下一次运行时间为 %s，期望 2024-03-13 00:00:00", state.NextRun)
+	}
+}
+
+func TestRefreshDailyTasksUsesSchedulerClock(t *testing.T) {
+	setupTestDB(t)
+	template := createDailyTemplate(t, "FREQ=DAILY")
+
+	// 玩家前天完成了任务，昨天错过了，有一张保护卡
+	const playerID = 1
+	for _, task := range []struct {
+		day      string
+		status   string
+		playerID interface{}
+	}{
+		{"2023-12-31", "verified", playerID},
+		{"2024-01-01", "available", nil},
+	} {
+		_, err := models.DB.Exec(
+			"INSERT INTO tasks (title, description, difficulty, type, reward, expiry_time, start_time, template_id, status, player_id) VALUES ('测试任务', '', 'easy', 'daily', 1, ?, ?, ?, ?, ?)",
+			task.day+" 23:59:59", task.day+" 00:00:00", template.ID, task.status, task.playerID,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := models.DB.Exec("UPDATE players SET streak_freezes = 1 WHERE id = ?", playerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+	if err := New(fixedClock(now)).RefreshDailyTasks(); err != nil {
+		t.Fatal(err)
+	}
+
+	var status string
+	err = models.DB.QueryRow("SELECT status FROM tasks WHERE template_id = ? AND start_time = '2024-01-01 00:00:00'", template.ID).Scan(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "expired" {
+		t.Errorf("昨天的任务状态为 %s，期望 expired", status)
+	}
+
+	var createdAt string
+	err = models.DB.QueryRow("SELECT CAST(created_at AS TEXT) FROM tasks WHERE template_id = ? AND start_time = '2024-01-02 00:00:00'", template.ID).Scan(&createdAt)
+	if err != nil {
+		t.Fatal("没有生成今天的任务:", err)
+	}
+	if createdAt != now.Format(timeLayout) {
+		t.Errorf("今天的任务创建时间为 %s，期望 %s", createdAt, now.Format(timeLayout))
+	}
+
+	var day, freezeCreatedAt string
+	err = models.DB.QueryRow("SELECT day, created_at FROM streak_freezes WHERE player_id = ? AND template_id = ?", playerID, template.ID).Scan(&day, &freezeCreatedAt)
+	if err != nil {
+		t.Fatal("没有使用保护卡:", err)
+	}
+	if day != "2024-01-01" || freezeCreatedAt != now.Format(timeLayout) {
+		t.Errorf("保护卡使用记录为 %s %s，期望 2024-01-01 %s", day, freezeCreatedAt, now.Format(timeLayout))
+	}
+}
@@ -44,6 +44,25 @@ function updateTaskCountdowns() {
 	});
 }
 
+// 页面加载的时间，用于计算正在进行的任务计时
+const pageLoadedAt = Date.now();
+
+// 更新正在进行的任务计时，已计时的秒数由服务器在页面加载时给出
+function updateTaskTimers() {
+	const timerElements = document.querySelectorAll('.task-timer.running');
+	timerElements.forEach((element) => {
+		const elapsed = parseInt(element.getAttribute('data-elapsed'), 10) || 0;
+		const required = parseInt(element.getAttribute('data-required'), 10) || 0;
+		const seconds = elapsed + Math.floor((Date.now() - pageLoadedAt) / 1000);
+		const minutes = Math.floor(seconds / 60);
+		const rest = seconds % 60;
+		element.textContent = '已计时 ' + minutes + '分' + (rest < 10 ? '0' : '') + rest + '秒';
+		if (required > 0 && seconds >= required) {
+			element.classList.add('done');
+		}
+	});
+}
+
 // 格式化任务开始时间
 function formatTaskStartTimes() {
 	// 找到所有任务开始时间元素
@@ -252,6 +271,12 @@ function ajaxFormSubmit(form, successCallback, errorCallback) {
             confirmMessage = '确定要删除这个模板吗？';
         } else if (action.includes('/delete_item')) {
             confirmMessage = '确定要删除这个物品吗？';
+        } else if (action.includes('/delete_player')) {
+            confirmMessage = '确定要删除这个玩家吗？';
+        } else if (action.includes('/revoke_session')) {
+            confirmMessage = '确定要撤销这个会话吗？';
+        } else if (action.includes('/delete_admin')) {
+            confirmMessage = '确定要删除这个家长账号吗？';
         }
         
         // 显示confirm对话框，如果用户取消，则阻止表单提交
@@ -339,7 +364,7 @@ function ajaxFormSubmit(form, successCallback, errorCallback) {
                 if (data.refresh) {
                     // 根据表单action决定刷新哪个模块
                     const action = form.action;
-                    if (action.includes('/create_task') || action.includes('/verify_task') || action.includes('/delete_task')) {
+                    if (action.includes('/create_task') || action.includes('/verify_task') || action.includes('/reject_task') || action.includes('/delete_task')) {
                         // 刷新任务相关模块
                         refreshModule('.task-table');
                     } else if (action.includes('/create_item') || action.includes('/update_item') || action.includes('/delete_item')) {
@@ -877,6 +902,8 @@ window.addEventListener('DOMContentLoaded', function() {
     updateTaskCountdowns();
     // 每秒更新一次倒计时
     setInterval(updateTaskCountdowns, 1000);
+    // 每秒更新一次正在进行的任务计时
+    setInterval(updateTaskTimers, 1000);
     
     // 格式化任务开始时间
     formatTaskStartTimes();
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// 单张图片的最大字节数
+const MaxImageSize = 5 << 20
+
+// 单张图片的最大像素数，防止声明了超大尺寸的图片在解码时占用大量内存
+const MaxImagePixels = 25000000
+
+// 缩略图的最大边长
+const thumbnailSize = 240
+
+// 允许上传的图片类型及对应的文件扩展名
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+var (
+	// 图片超过大小限制
+	ErrImageTooLarge = errors.New("图片不能超过5MB")
+	// 不支持的图片格式
+	ErrUnsupportedImage = errors.New("只支持JPG、PNG和GIF格式的图片")
+	// 图片尺寸超过像素限制
+	ErrImageDimensions = errors.New("图片尺寸太大，不能超过2500万像素")
+)
+
+// 获取上传文件的保存目录，可以通过环境变量UPLOAD_PATH配置，默认为./uploads
+func UploadDir() string {
+	dir := os.Getenv("UPLOAD_PATH")
+	if dir == "" {
+		dir = "./uploads"
+	}
+	return dir
+}
+
+// 检查图片数据的大小和格式，返回检测到的内容类型
+// 格式根据文件内容判断，不信任客户端提供的文件名和Content-Type
+func ValidateImage(data []byte) (string, error) {
+	if len(data) > MaxImageSize {
+		return "", ErrImageTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := allowedImageTypes[contentType]; !ok {
+		return "", ErrUnsupportedImage
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrUnsupportedImage
+	}
+	// 解码前先检查尺寸，使用int64避免宽高相乘溢出
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return "", ErrImageDimensions
+	}
+	return contentType, nil
+}
+
+// 将图片和缩略图保存到上传目录，返回原图和缩略图的文件名
+// 调用前应先用ValidateImage检查图片
+func SaveImage(data []byte, contentType string) (string, string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", ErrUnsupportedImage
+	}
+
+	dir := UploadDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+
+	name := GenerateSecureToken(16)
+	if name == "" {
+		return "", "", errors.New("生成文件名失败")
+	}
+	fileName := name + allowedImageTypes[contentType]
+	thumbName := name + "_thumb.jpg"
+
+	if err := os.WriteFile(filepath.Join(dir, fileName), data, 0644); err != nil {
+		return "", "", err
+	}
+
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, resizeImage(img, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		os.Remove(filepath.Join(dir, fileName))
+		return "", "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, thumbName), thumb.Bytes(), 0644); err != nil {
+		os.Remove(filepath.Join(dir, fileName))
+		return "", "", err
+	}
+
+	return fileName, thumbName, nil
+}
+
+// 删除上传目录中的文件，用于保存失败时清理
+func RemoveUploadedFiles(names ...string) {
+	for _, name := range names {
+		if name != "" {
+			os.Remove(filepath.Join(UploadDir(), filepath.Base(name)))
+		}
+	}
+}
+
+// 按比例缩小图片，使最长边不超过maxSize，使用最近邻采样
+// 透明背景会被填充为白色，因为缩略图保存为JPEG
+func resizeImage(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 {
+		return src
+	}
+
+	newWidth, newHeight := width, height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			newWidth = maxSize
+			newHeight = height * maxSize / width
+		} else {
+			newHeight = maxSize
+			newWidth = width * maxSize / height
+		}
+		if newWidth < 1 {
+			newWidth = 1
+		}
+		if newHeight < 1 {
+			newHeight = 1
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		srcY := bounds.Min.Y + y*height/newHeight
+		for x := 0; x < newWidth; x++ {
+			srcX := bounds.Min.X + x*width/newWidth
+			r, g, b, a := src.At(srcX, srcY).RGBA()
+			// RGBA()返回预乘透明度的颜色，与白色背景混合
+			r += 0xffff - a
+			g += 0xffff - a
+			b += 0xffff - a
+			dst.Set(x, y, color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: 0xffff})
+		}
+	}
+	return dst
+}
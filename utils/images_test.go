@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// 生成一张1x1的PNG图片，并把IHDR中声明的宽高改为width和height
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// 8字节文件头之后是IHDR块：4字节长度、4字节类型、13字节数据、4字节CRC
+	binary.BigEndian.PutUint32(data[16:20], width)
+	binary.BigEndian.PutUint32(data[20:24], height)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"普通图片", pngWithSize(t, 1, 1), nil},
+		{"刚好达到像素限制", pngWithSize(t, 5000, 5000), nil},
+		{"超过像素限制", pngWithSize(t, 50000, 50000), ErrImageDimensions},
+		{"单边过长", pngWithSize(t, 1000000, 30), ErrImageDimensions},
+		{"不是图片", []byte("hello world"), ErrUnsupportedImage},
+		{"超过大小限制", make([]byte, MaxImageSize+1), ErrImageTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateImage(tt.data)
+			if err != tt.want {
+				t.Errorf("返回 %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}